@@ -1,217 +1,193 @@
 package main
 
 import (
-	"encoding/base64"
+	"context"
+	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
+
+	"go-proxy-server/proxy"
 )
 
-// ProxyServer represents the HTTP proxy server
-type ProxyServer struct {
-	username string
-	password string
-	port     string
-}
+// version is the release version, set at build time with
+// -ldflags "-X main.version=v1.2.3"
+var version = "dev"
 
-// NewProxyServer creates a new proxy server instance
-func NewProxyServer(username, password, port string) *ProxyServer {
-	return &ProxyServer{
-		username: username,
-		password: password,
-		port:     port,
+// printBanner writes the startup banner to w unless quiet. Credentials are
+// left out so they do not end up in logs.
+func printBanner(w io.Writer, server *proxy.Server, quiet bool) {
+	if quiet {
+		return
 	}
+	fmt.Fprintf(w, "=== HTTP Proxy Server ===\n")
+	fmt.Fprintf(w, "Port: %s\n", server.Port())
+	fmt.Fprintf(w, "========================\n\n")
 }
 
-// authenticateRequest checks if the request has valid Basic Auth credentials
-func (ps *ProxyServer) authenticateRequest(r *http.Request) bool {
-	auth := r.Header.Get("Proxy-Authorization")
-	if auth == "" {
-		return false
-	}
+func main() {
+	configPath := flag.String("config", os.Getenv("PROXY_CONFIG"), "path to a YAML or JSON config file")
+	manifestPath := flag.String("manifest", "", "write a JSON manifest of the running server to this path")
+	validateOnly := flag.Bool("validate", false, "check the configuration and exit without starting the server")
+	quiet := flag.Bool("quiet", os.Getenv("PROXY_QUIET") == "true", "do not print the startup banner")
+	flag.Parse()
+	proxy.Version = version
+
+	var server *proxy.Server
+	if *configPath != "" {
+		// Load configuration from file, with environment overrides
+		cfg, err := proxy.LoadConfig(*configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server, err = proxy.NewProxyServerFromConfig(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		// Get configuration from environment variables
+		username := os.Getenv("PROXY_USERNAME")
+		password := os.Getenv("PROXY_PASSWORD")
+		port := os.Getenv("PROXY_PORT")
+
+		// Set default values if not provided
+		if username == "" {
+			username = "admin"
+		}
+		if password == "" {
+			password = "password123"
+		}
+		if port == "" {
+			port = "8080"
+		}
 
-	// Check if it's Basic authentication
-	if !strings.HasPrefix(auth, "Basic ") {
-		return false
-	}
+		// Validate configuration
+		if username == "" || password == "" {
+			log.Fatal("Username and password are required")
+		}
 
-	// Decode the base64 encoded credentials
-	payload, err := base64.StdEncoding.DecodeString(auth[6:])
-	if err != nil {
-		return false
+		server = proxy.NewProxyServer(username, password, port)
 	}
 
-	// Split username and password
-	credentials := strings.SplitN(string(payload), ":", 2)
-	if len(credentials) != 2 {
-		return false
+	server.AdminPort = os.Getenv("PROXY_ADMIN_PORT")
+	if os.Getenv("PROXY_HASHED_CREDENTIALS") == "true" {
+		server.HashedCredentials = true
 	}
-
-	username, password := credentials[0], credentials[1]
-	return username == ps.username && password == ps.password
-}
-
-// handleHTTP handles HTTP requests through the proxy
-func (ps *ProxyServer) handleHTTP(w http.ResponseWriter, r *http.Request) {
-	// Check authentication
-	if !ps.authenticateRequest(r) {
-		w.Header().Set("Proxy-Authenticate", "Basic realm=\"Proxy Server\"")
-		http.Error(w, "Proxy Authentication Required", http.StatusProxyAuthRequired)
-		return
+	if os.Getenv("PROXY_PROXY_PROTOCOL") == "true" {
+		server.ProxyProtocol = true
 	}
-
-	// Remove proxy-specific headers
-	r.Header.Del("Proxy-Authorization")
-	r.Header.Del("Proxy-Connection")
-
-	// Create HTTP client
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+	if caFile := os.Getenv("PROXY_UPSTREAM_CA_FILE"); caFile != "" {
+		pool, err := proxy.LoadCertPool(caFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.RootCAs = pool
 	}
-
-	// Create new request
-	proxyReq, err := http.NewRequest(r.Method, r.URL.String(), r.Body)
-	if err != nil {
-		http.Error(w, "Error creating proxy request", http.StatusInternalServerError)
-		return
+	if ldapURL := os.Getenv("PROXY_LDAP_URL"); ldapURL != "" {
+		server.Authenticator = proxy.NewLDAPAuthenticator(ldapURL, os.Getenv("PROXY_LDAP_BASE_DN"), os.Getenv("PROXY_LDAP_BIND_TEMPLATE"))
 	}
-
-	// Copy headers
-	for name, values := range r.Header {
-		for _, value := range values {
-			proxyReq.Header.Add(name, value)
+	if caCert := os.Getenv("PROXY_INTERCEPT_CA_CERT"); caCert != "" {
+		ca, err := proxy.LoadInterceptCA(caCert, os.Getenv("PROXY_INTERCEPT_CA_KEY"))
+		if err != nil {
+			log.Fatal(err)
 		}
+		server.InterceptHTTPS, server.InterceptCA = true, ca
 	}
-
-	// Make the request
-	resp, err := client.Do(proxyReq)
-	if err != nil {
-		http.Error(w, "Error making proxy request", http.StatusBadGateway)
-		return
+	if bindAddress := os.Getenv("PROXY_BIND_ADDRESS"); bindAddress != "" {
+		server.BindAddress = bindAddress
 	}
-	defer resp.Body.Close()
-
-	// Copy response headers
-	for name, values := range resp.Header {
-		for _, value := range values {
-			w.Header().Add(name, value)
-		}
+	if realm := os.Getenv("PROXY_REALM"); realm != "" {
+		server.Realm = realm
 	}
-
-	// Set status code
-	w.WriteHeader(resp.StatusCode)
-
-	// Copy response body
-	_, err = io.Copy(w, resp.Body)
-	if err != nil {
-		log.Printf("Error copying response body: %v", err)
+	if viaName := os.Getenv("PROXY_VIA_NAME"); viaName != "" {
+		server.ViaName = viaName
 	}
-}
-
-// handleHTTPS handles HTTPS CONNECT requests
-func (ps *ProxyServer) handleHTTPS(w http.ResponseWriter, r *http.Request) {
-	// Check authentication
-	if !ps.authenticateRequest(r) {
-		w.Header().Set("Proxy-Authenticate", "Basic realm=\"Proxy Server\"")
-		http.Error(w, "Proxy Authentication Required", http.StatusProxyAuthRequired)
-		return
+	if pacPath := os.Getenv("PROXY_PAC_PATH"); pacPath != "" {
+		server.PACPath = pacPath
 	}
-
-	// Get the destination host
-	destConn, err := net.DialTimeout("tcp", r.Host, 30*time.Second)
-	if err != nil {
-		http.Error(w, "Error connecting to destination", http.StatusBadGateway)
-		return
+	if pacHost := os.Getenv("PROXY_PAC_HOST"); pacHost != "" {
+		server.PACHost = pacHost
 	}
-	defer destConn.Close()
-
-	// Send 200 Connection established
-	w.WriteHeader(http.StatusOK)
-
-	// Get the underlying connection
-	hijacker, ok := w.(http.Hijacker)
-	if !ok {
-		http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
-		return
+	server.ManifestPath = *manifestPath
+	if err := server.Validate(); err != nil {
+		log.Fatal(err)
 	}
-
-	clientConn, _, err := hijacker.Hijack()
-	if err != nil {
-		http.Error(w, "Error hijacking connection", http.StatusInternalServerError)
+	if *validateOnly {
+		fmt.Println("Configuration is valid")
 		return
 	}
-	defer clientConn.Close()
-
-	// Start copying data between client and destination
-	go func() {
-		defer destConn.Close()
-		defer clientConn.Close()
-		io.Copy(destConn, clientConn)
-	}()
-
-	io.Copy(clientConn, destConn)
-}
-
-// ServeHTTP implements the http.Handler interface
-func (ps *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	log.Printf("%s %s %s", r.RemoteAddr, r.Method, r.URL.String())
-
-	if r.Method == "CONNECT" {
-		ps.handleHTTPS(w, r)
-	} else {
-		ps.handleHTTP(w, r)
-	}
-}
-
-// Start starts the proxy server
-func (ps *ProxyServer) Start() error {
-	server := &http.Server{
-		Addr:    ":" + ps.port,
-		Handler: ps,
+	if os.Getenv("PROXY_ACCESS_LOG") == "json" {
+		server.AccessLog = proxy.NewJSONLogger(os.Stdout)
+	}
+	if path := os.Getenv("PROXY_ACCESS_LOG_FILE"); path != "" {
+		maxBytes, keep := int64(proxy.DefaultLogMaxBytes), proxy.DefaultLogKeep
+		if v := os.Getenv("PROXY_ACCESS_LOG_MAX_BYTES"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				log.Fatalf("Invalid PROXY_ACCESS_LOG_MAX_BYTES %q", v)
+			}
+			maxBytes = n
+		}
+		if v := os.Getenv("PROXY_ACCESS_LOG_KEEP"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				log.Fatalf("Invalid PROXY_ACCESS_LOG_KEEP %q", v)
+			}
+			keep = n
+		}
+		file, err := proxy.NewRotatingFile(path, maxBytes, keep)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		server.AccessLog = proxy.NewJSONLogger(file)
 	}
 
-	log.Printf("Starting HTTP Proxy Server on port %s", ps.port)
-	log.Printf("Username: %s", ps.username)
-	log.Printf("Server ready to accept connections...")
-
-	return server.ListenAndServe()
-}
-
-func main() {
-	// Get configuration from environment variables
-	username := os.Getenv("PROXY_USERNAME")
-	password := os.Getenv("PROXY_PASSWORD")
-	port := os.Getenv("PROXY_PORT")
+	printBanner(os.Stdout, server, *quiet)
 
-	// Set default values if not provided
-	if username == "" {
-		username = "admin"
-	}
-	if password == "" {
-		password = "password123"
-	}
-	if port == "" {
-		port = "8080"
-	}
+	go func() {
+		if err := server.Start(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
 
-	// Validate configuration
-	if username == "" || password == "" {
-		log.Fatal("Username and password are required")
+	if socksPort := os.Getenv("PROXY_SOCKS5_PORT"); socksPort != "" {
+		go func() {
+			if err := server.StartSOCKS5(socksPort); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	if *configPath != "" {
+		go func() {
+			reload := make(chan os.Signal, 1)
+			signal.Notify(reload, syscall.SIGHUP)
+			for range reload {
+				if err := server.ReloadConfig(*configPath); err != nil {
+					log.Printf("Reloading config: %v", err)
+					continue
+				}
+				log.Printf("Reloaded config from %s", *configPath)
+			}
+		}()
+	}
+
+	// Wait for a termination signal and let in-flight requests finish
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	log.Printf("Shutting down...")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error during shutdown: %v", err)
 	}
-
-	// Create and start proxy server
-	proxy := NewProxyServer(username, password, port)
-
-	fmt.Printf("=== HTTP Proxy Server ===\n")
-	fmt.Printf("Port: %s\n", port)
-	fmt.Printf("Username: %s\n", username)
-	fmt.Printf("Password: %s\n", strings.Repeat("*", len(password)))
-	fmt.Printf("========================\n\n")
-
-	log.Fatal(proxy.Start())
 }
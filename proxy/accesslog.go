@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AccessLogEntry describes one completed request
+type AccessLogEntry struct {
+	Time      time.Time
+	RequestID string
+	ClientIP  string
+	User      string
+	Tag       string
+	Method    string
+	Host      string
+	Status    int
+	Bytes     int64
+	Duration  time.Duration
+	// Unzipped is the decompressed size of an inspected gzip response
+	// body, or zero when it was not inspected
+	Unzipped int64
+}
+
+// Logger records access log entries. Implementations must be safe for
+// concurrent use.
+type Logger interface {
+	Log(entry AccessLogEntry)
+}
+
+// JSONLogger writes access log entries as JSON lines
+type JSONLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONLogger creates a logger writing one JSON object per line to w
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	return &JSONLogger{w: w}
+}
+
+// jsonLogLine is the wire format of a JSON access log line
+type jsonLogLine struct {
+	Timestamp  string  `json:"timestamp"`
+	RequestID  string  `json:"request_id,omitempty"`
+	ClientIP   string  `json:"client_ip"`
+	User       string  `json:"user,omitempty"`
+	Tag        string  `json:"tag,omitempty"`
+	Method     string  `json:"method"`
+	Host       string  `json:"host"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMs float64 `json:"duration_ms"`
+	Unzipped   int64   `json:"unzipped_bytes,omitempty"`
+}
+
+// Log writes the entry as a single JSON line
+func (l *JSONLogger) Log(entry AccessLogEntry) {
+	line, err := json.Marshal(jsonLogLine{
+		Timestamp:  entry.Time.UTC().Format(time.RFC3339Nano),
+		RequestID:  entry.RequestID,
+		ClientIP:   entry.ClientIP,
+		User:       entry.User,
+		Tag:        entry.Tag,
+		Method:     entry.Method,
+		Host:       entry.Host,
+		Status:     entry.Status,
+		Bytes:      entry.Bytes,
+		DurationMs: float64(entry.Duration) / float64(time.Millisecond),
+		Unzipped:   entry.Unzipped,
+	})
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(append(line, '\n'))
+}
+
+// requestInfo collects details about a request while it is handled
+type requestInfo struct {
+	user string
+	tag  string
+	// unzipped is set when InspectBodies measured the response body
+	unzipped int64
+	// gotConn is set once a forwarded request obtained an upstream
+	// connection, and reused tells whether it was an idle one
+	gotConn bool
+	reused  bool
+	// requestedURL is the URL the client asked for, before HostRewrites or
+	// UpstreamPools changed its host
+	requestedURL string
+}
+
+type requestInfoKey struct{}
+
+// withRequestInfo returns a copy of ctx carrying info
+func withRequestInfo(ctx context.Context, info *requestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// requestInfoFromContext returns the info stored in ctx. It returns a
+// throwaway value when there is none so callers can always write to it.
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{}
+}
+
+// responseRecorder records the status and body size of a response. Bytes
+// written to a hijacked connection are counted as well.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  atomic.Int64
+}
+
+// newResponseRecorder wraps w to record its status and size
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w}
+}
+
+// WriteHeader records the status code. Informational responses are passed
+// on without being recorded, since the final status follows them.
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 && (status >= 200 || status == http.StatusSwitchingProtocols) {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+// Write counts the bytes written
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes.Add(int64(n))
+	return n, err
+}
+
+// Flush passes flushes through to the underlying writer
+func (rr *responseRecorder) Flush() {
+	if flusher, ok := rr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// writer returns rr for handlers to write to. It is an http.Hijacker only
+// when the underlying writer is, so handlers can tell whether hijacking
+// will work before committing to it.
+func (rr *responseRecorder) writer() http.ResponseWriter {
+	if _, ok := rr.ResponseWriter.(http.Hijacker); ok {
+		return hijackableRecorder{rr}
+	}
+	return rr
+}
+
+// hijackableRecorder is a responseRecorder around a writer that can be
+// hijacked
+type hijackableRecorder struct {
+	*responseRecorder
+}
+
+// Hijack hands over the connection, counting bytes written to it
+func (hr hijackableRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := hr.ResponseWriter.(http.Hijacker).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &countingConn{Conn: conn, written: &hr.bytes}, rw, nil
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// statusCode returns the recorded status, defaulting to 200
+func (rr *responseRecorder) statusCode() int {
+	if rr.status == 0 {
+		return http.StatusOK
+	}
+	return rr.status
+}
+
+// countingConn counts the bytes written to a connection
+type countingConn struct {
+	net.Conn
+	written *atomic.Int64
+}
+
+// Write counts the bytes written to the connection
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// CloseWrite half-closes the connection if the underlying one can
+func (c *countingConn) CloseWrite() error {
+	if hc, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return hc.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
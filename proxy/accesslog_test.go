@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJSONAccessLog(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("0123456789"))
+	}))
+	defer backendServer.Close()
+
+	var output bytes.Buffer
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.AccessLog = NewJSONLogger(&output)
+	proxy.PolicyTags = map[string]string{"admin": "tier=premium"}
+
+	req := httptest.NewRequest("POST", backendServer.URL+"/items", strings.NewReader("{}"))
+	req.RemoteAddr = "192.0.2.10:4321"
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	w := httptest.NewRecorder()
+
+	proxy.ServeHTTP(w, req)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(output.Bytes(), &entry); err != nil {
+		t.Fatalf("Access log is not valid JSON: %v (%q)", err, output.String())
+	}
+
+	expected := map[string]interface{}{
+		"client_ip": "192.0.2.10",
+		"method":    "POST",
+		"host":      strings.TrimPrefix(backendServer.URL, "http://"),
+		"status":    float64(http.StatusCreated),
+		"bytes":     float64(10),
+		"user":      "admin",
+		"tag":       "tier=premium",
+	}
+	for field, value := range expected {
+		if entry[field] != value {
+			t.Errorf("Expected %s=%v, got %v", field, value, entry[field])
+		}
+	}
+	for _, field := range []string{"timestamp", "duration_ms", "request_id"} {
+		if _, ok := entry[field]; !ok {
+			t.Errorf("Expected field %s in access log", field)
+		}
+	}
+}
+
+func TestJSONAccessLog_Failure(t *testing.T) {
+	var output bytes.Buffer
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.AccessLog = NewJSONLogger(&output)
+
+	// An unauthenticated request is still logged with its status
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	w := httptest.NewRecorder()
+
+	proxy.ServeHTTP(w, req)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(output.Bytes(), &entry); err != nil {
+		t.Fatalf("Access log is not valid JSON: %v", err)
+	}
+	if entry["status"] != float64(http.StatusProxyAuthRequired) {
+		t.Errorf("Expected status %d, got %v", http.StatusProxyAuthRequired, entry["status"])
+	}
+	if _, ok := entry["user"]; ok {
+		t.Error("Unauthenticated request should not log a user")
+	}
+}
+
+func TestJSONAccessLog_Sampled(t *testing.T) {
+	var output bytes.Buffer
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.AccessLog = NewJSONLogger(&output)
+	proxy.SampleRate = 0
+
+	req := httptest.NewRequest("GET", "/robots.txt", nil)
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	if output.Len() != 0 {
+		t.Errorf("Unsampled request should not be logged, got %q", output.String())
+	}
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseCIDRs parses a list of CIDR blocks. Bare IP addresses are accepted
+// and treated as single-host networks.
+func ParseCIDRs(entries []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// remoteIP returns the IP of the directly connected client. Headers such as
+// X-Forwarded-For are deliberately ignored since clients can forge them.
+// The zone of link-local IPv6 addresses, as in fe80::1%eth0, is dropped.
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(r.RemoteAddr, "["), "]")
+	}
+	if i := strings.LastIndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+	return net.ParseIP(host)
+}
+
+// ipAllowed reports whether the client IP passes the deny and allow lists.
+// The denylist takes precedence, and an empty allowlist allows everyone.
+func (ps *Server) ipAllowed(ip net.IP) bool {
+	ps.usersMu.RLock()
+	defer ps.usersMu.RUnlock()
+	if ip == nil {
+		return len(ps.AllowedCIDRs) == 0 && len(ps.DeniedCIDRs) == 0
+	}
+
+	for _, network := range ps.DeniedCIDRs {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(ps.AllowedCIDRs) == 0 {
+		return true
+	}
+	for _, network := range ps.AllowedCIDRs {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPAllowlist(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backendServer.Close()
+
+	allowed, err := ParseCIDRs([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	denied, err := ParseCIDRs([]string{"10.1.0.0/16", "192.0.2.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.AllowedCIDRs = allowed
+	proxy.DeniedCIDRs = denied
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		forwardedFor   string
+		expectedStatus int
+	}{
+		{"Allowed IP", "10.2.3.4:5000", "", http.StatusOK},
+		{"Denied IP inside allowlist", "10.1.2.3:5000", "", http.StatusForbidden},
+		{"Denied single host", "192.0.2.5:5000", "", http.StatusForbidden},
+		{"IP in neither list", "203.0.113.9:5000", "", http.StatusForbidden},
+		{"Spoofed X-Forwarded-For is ignored", "203.0.113.9:5000", "10.2.3.4", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", backendServer.URL, nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			w := httptest.NewRecorder()
+
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestIPAllowlist_CheckedBeforeAuth(t *testing.T) {
+	denied, _ := ParseCIDRs([]string{"192.0.2.0/24"})
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.DeniedCIDRs = denied
+
+	// A denied client gets 403 rather than an auth challenge
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	req.RemoteAddr = "192.0.2.1:5000"
+	w := httptest.NewRecorder()
+
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	networks, err := ParseCIDRs([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(networks) != 3 {
+		t.Fatalf("Expected 3 networks, got %d", len(networks))
+	}
+	if networks[1].String() != "192.0.2.1/32" {
+		t.Errorf("Expected bare IP as /32, got %s", networks[1])
+	}
+
+	if _, err := ParseCIDRs([]string{"not-an-ip"}); err == nil {
+		t.Error("Expected error for invalid entry")
+	}
+}
+
+func TestIPAllowlist_IPv6(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backendServer.Close()
+
+	allowed, err := ParseCIDRs([]string{"2001:db8::/32", "fe80::/10", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	denied, err := ParseCIDRs([]string{"2001:db8:dead::/48"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.AllowedCIDRs = allowed
+	proxy.DeniedCIDRs = denied
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		expectedStatus int
+	}{
+		{"IPv6 inside allowlist", "[2001:db8::1]:5000", http.StatusOK},
+		{"IPv6 outside allowlist", "[2001:db9::1]:5000", http.StatusForbidden},
+		{"IPv6 inside denylist", "[2001:db8:dead::1]:5000", http.StatusForbidden},
+		{"Link-local with zone", "[fe80::1%eth0]:5000", http.StatusOK},
+		{"Zone outside allowlist", "[fd00::1%eth0]:5000", http.StatusForbidden},
+		{"IPv4-mapped IPv6 in IPv4 range", "[::ffff:10.1.2.3]:5000", http.StatusOK},
+		{"Loopback outside allowlist", "[::1]:5000", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", backendServer.URL, nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+			w := httptest.NewRecorder()
+
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		expected   string
+	}{
+		{"192.0.2.1:1234", "192.0.2.1"},
+		{"[2001:db8::1]:1234", "2001:db8::1"},
+		{"[fe80::1%eth0]:1234", "fe80::1"},
+		{"fe80::1%eth0", "fe80::1"},
+		{"[2001:db8::1]", "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.remoteAddr, func(t *testing.T) {
+			ip := remoteIP(&http.Request{RemoteAddr: tt.remoteAddr})
+			if ip.String() != tt.expected {
+				t.Errorf("Expected %s, got %v", tt.expected, ip)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultAdaptiveAlpha weighs each new latency sample in the moving average
+const defaultAdaptiveAlpha = 0.2
+
+// AdaptiveTimeout derives per-host timeouts from a moving average of the
+// latency recently observed to each host
+type AdaptiveTimeout struct {
+	// Multiplier is applied to the average latency to get the timeout
+	Multiplier float64
+	// Min and Max clamp the computed timeout
+	Min time.Duration
+	Max time.Duration
+	// Alpha is the EWMA smoothing factor in (0, 1]. Zero uses 0.2.
+	Alpha float64
+}
+
+// latencyTracker keeps an exponentially weighted moving average of latency
+// per host
+type latencyTracker struct {
+	mu   sync.Mutex
+	ewma map[string]time.Duration
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{ewma: make(map[string]time.Duration)}
+}
+
+// observe folds a latency sample for host into its moving average
+func (lt *latencyTracker) observe(host string, latency time.Duration, alpha float64) {
+	if alpha <= 0 || alpha > 1 {
+		alpha = defaultAdaptiveAlpha
+	}
+
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	avg, found := lt.ewma[host]
+	if !found {
+		lt.ewma[host] = latency
+		return
+	}
+	lt.ewma[host] = avg + time.Duration(alpha*float64(latency-avg))
+}
+
+// average returns the moving average latency of host, if any was observed
+func (lt *latencyTracker) average(host string) (time.Duration, bool) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	avg, found := lt.ewma[host]
+	return avg, found
+}
+
+// timeout computes the timeout for host from its average latency, falling
+// back to fallback until the host has been observed
+func (at *AdaptiveTimeout) timeout(lt *latencyTracker, host string, fallback time.Duration) time.Duration {
+	avg, found := lt.average(host)
+	if !found {
+		return fallback
+	}
+
+	timeout := time.Duration(at.Multiplier * float64(avg))
+	if at.Min > 0 && timeout < at.Min {
+		timeout = at.Min
+	}
+	if at.Max > 0 && timeout > at.Max {
+		timeout = at.Max
+	}
+	return timeout
+}
+
+// requestTimeoutFor returns the timeout for a forwarded request to host
+func (ps *Server) requestTimeoutFor(host string) time.Duration {
+	if ps.AdaptiveTimeout == nil {
+		return ps.RequestTimeout
+	}
+	return ps.AdaptiveTimeout.timeout(ps.responseLatency, host, ps.RequestTimeout)
+}
+
+// dialTimeoutFor returns the timeout for dialing host
+func (ps *Server) dialTimeoutFor(host string) time.Duration {
+	if ps.AdaptiveTimeout == nil {
+		return ps.DialTimeout
+	}
+	return ps.AdaptiveTimeout.timeout(ps.dialLatency, host, ps.DialTimeout)
+}
+
+// observeLatency records the latency of an attempt that ended with err when
+// adaptive timeouts are enabled. Timeouts count too, so a host that slows
+// down raises its own timeout instead of failing forever at the old one.
+func (ps *Server) observeLatency(lt *latencyTracker, host string, latency time.Duration, err error) {
+	if ps.AdaptiveTimeout == nil {
+		return
+	}
+	var netErr net.Error
+	if err != nil && !(errors.As(err, &netErr) && netErr.Timeout()) {
+		return
+	}
+	lt.observe(host, latency, ps.AdaptiveTimeout.Alpha)
+}
+
+// headerTimeoutError is returned when response headers take longer than the
+// adaptive timeout
+type headerTimeoutError struct{}
+
+func (headerTimeoutError) Error() string   { return "timeout awaiting response headers" }
+func (headerTimeoutError) Timeout() bool   { return true }
+func (headerTimeoutError) Temporary() bool { return true }
+
+// headerTimeoutTransport fails requests whose response headers do not arrive
+// within timeout. Unlike http.Client.Timeout it does not limit reading the
+// body, since adaptive timeouts are learned from the time to headers only.
+type headerTimeoutTransport struct {
+	transport http.RoundTripper
+	timeout   time.Duration
+}
+
+func (ht headerTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(ht.timeout, cancel)
+	resp, err := ht.transport.RoundTrip(req.WithContext(ctx))
+	if !timer.Stop() {
+		// The timer fired, so the request was cancelled even if a response
+		// arrived just in time
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return nil, headerTimeoutError{}
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases a request's context once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (cc *cancelOnClose) Close() error {
+	err := cc.ReadCloser.Close()
+	cc.cancel()
+	return err
+}
+
+// upstreamClient returns the client for a forwarded request to host. With
+// AdaptiveTimeout the learned timeout only bounds the wait for response
+// headers; otherwise RequestTimeout bounds the whole request.
+func (ps *Server) upstreamClient(host string) *http.Client {
+	if ps.AdaptiveTimeout == nil {
+		return &http.Client{Timeout: ps.RequestTimeout, Transport: ps.upstreamTransport()}
+	}
+	timeout := ps.requestTimeoutFor(host)
+	if timeout <= 0 {
+		return &http.Client{Transport: ps.upstreamTransport()}
+	}
+	return &http.Client{Transport: headerTimeoutTransport{transport: ps.upstreamTransport(), timeout: timeout}}
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveTimeout(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.AdaptiveTimeout = &AdaptiveTimeout{
+		Multiplier: 4,
+		Min:        100 * time.Millisecond,
+		Max:        5 * time.Second,
+		Alpha:      0.5,
+	}
+	host := "api.example.com:443"
+
+	if result := proxy.requestTimeoutFor(host); result != proxy.RequestTimeout {
+		t.Errorf("Expected fallback %v before any samples, got %v", proxy.RequestTimeout, result)
+	}
+
+	tests := []struct {
+		name            string
+		latency         time.Duration
+		expectedTimeout time.Duration
+	}{
+		// The first sample seeds the average: 4 x 200ms
+		{"First sample", 200 * time.Millisecond, 800 * time.Millisecond},
+		// avg = 200 + 0.5 x (400 - 200) = 300ms
+		{"Slower sample", 400 * time.Millisecond, 1200 * time.Millisecond},
+		// avg = 300 + 0.5 x (100 - 300) = 200ms
+		{"Faster sample", 100 * time.Millisecond, 800 * time.Millisecond},
+		// avg = 200 + 0.5 x (0 - 200) = 100ms, below the minimum
+		{"Clamped to minimum", 0, 400 * time.Millisecond},
+		{"Clamped to maximum", 10 * time.Second, 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy.observeLatency(proxy.responseLatency, host, tt.latency, nil)
+			if result := proxy.requestTimeoutFor(host); result != tt.expectedTimeout {
+				t.Errorf("Expected %v, got %v", tt.expectedTimeout, result)
+			}
+		})
+	}
+
+	// Hosts and dial latencies are tracked separately
+	if result := proxy.requestTimeoutFor("other.example.com:443"); result != proxy.RequestTimeout {
+		t.Errorf("Expected fallback for another host, got %v", result)
+	}
+	if result := proxy.dialTimeoutFor(host); result != proxy.DialTimeout {
+		t.Errorf("Expected dial fallback, got %v", result)
+	}
+}
+
+func TestAdaptiveTimeout_IgnoresFailures(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.AdaptiveTimeout = &AdaptiveTimeout{Multiplier: 2}
+
+	proxy.observeLatency(proxy.dialLatency, "down.example.com:443", time.Millisecond, errors.New("connection refused"))
+	if result := proxy.dialTimeoutFor("down.example.com:443"); result != proxy.DialTimeout {
+		t.Errorf("Expected refused dials not to be sampled, got %v", result)
+	}
+
+	proxy.observeLatency(proxy.dialLatency, "slow.example.com:443", time.Second, timeoutError{})
+	if result := proxy.dialTimeoutFor("slow.example.com:443"); result != 2*time.Second {
+		t.Errorf("Expected timed out dials to be sampled, got %v", result)
+	}
+}
+
+func TestAdaptiveTimeout_Disabled(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.observeLatency(proxy.responseLatency, "api.example.com:443", time.Millisecond, nil)
+	if result := proxy.requestTimeoutFor("api.example.com:443"); result != proxy.RequestTimeout {
+		t.Errorf("Expected static timeout when disabled, got %v", result)
+	}
+}
+
+// timeoutError is a net.Error reporting a timeout
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestAdaptiveTimeout_HeadersOnly(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow-headers" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		if r.URL.Path == "/slow-body" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		io.WriteString(w, "done")
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.AdaptiveTimeout = &AdaptiveTimeout{Multiplier: 2, Min: 100 * time.Millisecond, Max: 100 * time.Millisecond}
+	proxy.observeLatency(proxy.responseLatency, backendServer.Listener.Addr().String(), 50*time.Millisecond, nil)
+
+	tests := []struct {
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"/slow-body", http.StatusOK, "done"},
+		{"/slow-headers", http.StatusGatewayTimeout, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest("GET", backendServer.URL+tt.path, nil)
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedBody != "" && w.Body.String() != tt.expectedBody {
+				t.Errorf("Expected body %q, got %q", tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"net/http"
+)
+
+// adminHandler serves the admin endpoints, kept off the proxy port so they
+// never collide with proxied traffic
+func (ps *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", ps.metrics)
+	mux.HandleFunc("/stats", ps.serveStats)
+	return mux
+}
+
+// startAdmin starts the admin listener in the background when AdminPort is set
+func (ps *Server) startAdmin() error {
+	if ps.AdminPort == "" {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", net.JoinHostPort(ps.BindAddress, ps.AdminPort))
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: ps.adminHandler()}
+	ps.mu.Lock()
+	ps.adminServer, ps.adminAddr = server, ln.Addr()
+	ps.mu.Unlock()
+
+	log.Printf("Starting admin server on port %s", ps.AdminPort)
+	go func() {
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("Admin server error: %v", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,18 @@
+package proxy
+
+import "net/http"
+
+// Authenticator identifies the user that sent a proxy request. The returned
+// user is used for logging, policy tags, rate limits and domain allowlists.
+// Implementations must be safe for concurrent use.
+type Authenticator interface {
+	Authenticate(r *http.Request) (user string, ok bool)
+}
+
+// AuthenticatorFunc adapts an ordinary function to an Authenticator
+type AuthenticatorFunc func(r *http.Request) (string, bool)
+
+// Authenticate calls f(r)
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (string, bool) {
+	return f(r)
+}
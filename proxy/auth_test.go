@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// headerAuthenticator accepts requests carrying a known API key header
+type headerAuthenticator struct {
+	keys map[string]string
+}
+
+func (ha *headerAuthenticator) Authenticate(r *http.Request) (string, bool) {
+	user, ok := ha.keys[r.Header.Get("X-Api-Key")]
+	return user, ok
+}
+
+func TestCustomAuthenticator(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backendServer.Close()
+
+	var output bytes.Buffer
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.Authenticator = &headerAuthenticator{keys: map[string]string{"key-1": "carol"}}
+	proxy.AccessLog = NewJSONLogger(&output)
+	proxy.PolicyTags = map[string]string{"carol": "tier=gold"}
+
+	tests := []struct {
+		name     string
+		header   string
+		value    string
+		expected int
+	}{
+		{"Known key", "X-Api-Key", "key-1", http.StatusOK},
+		{"Unknown key", "X-Api-Key", "key-2", http.StatusProxyAuthRequired},
+		// The built-in Basic check is replaced, not combined
+		{"Basic credentials", "Proxy-Authorization", CreateBasicAuth("admin", "password123"), http.StatusProxyAuthRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output.Reset()
+			req := httptest.NewRequest("GET", backendServer.URL, nil)
+			req.Header.Set(tt.header, tt.value)
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+			if tt.expected != http.StatusOK {
+				return
+			}
+			var entry map[string]interface{}
+			if err := json.Unmarshal(output.Bytes(), &entry); err != nil {
+				t.Fatalf("Access log is not valid JSON: %v (%q)", err, output.String())
+			}
+			if entry["user"] != "carol" || entry["tag"] != "tier=gold" {
+				t.Errorf("Expected user carol with tag tier=gold, got %v and %v", entry["user"], entry["tag"])
+			}
+		})
+	}
+}
+
+func TestAuthenticatorFunc_FallBack(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.Authenticator = AuthenticatorFunc(func(r *http.Request) (string, bool) {
+		if r.Header.Get("X-Api-Key") == "key-1" {
+			return "carol", true
+		}
+		return proxy.Authenticate(r)
+	})
+
+	if user, ok := proxy.authenticatedUser(authRequest("admin", "password123")); !ok || user != "admin" {
+		t.Errorf("Expected fallback to Basic credentials, got %q, %v", user, ok)
+	}
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("X-Api-Key", "key-1")
+	if user, ok := proxy.authenticatedUser(req); !ok || user != "carol" {
+		t.Errorf("Expected carol, got %q, %v", user, ok)
+	}
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxBanTrackedClients bounds the clients with recent authentication failures
+// tracked at once. Stale entries are swept once it is reached.
+const maxBanTrackedClients = 10000
+
+// AuthBan temporarily bans client IPs that keep failing to authenticate
+type AuthBan struct {
+	// MaxFailures failed attempts within Window ban the client
+	MaxFailures int
+	Window      time.Duration
+	// Duration is how long a ban lasts
+	Duration time.Duration
+}
+
+// banState is the authentication failure history of a single client
+type banState struct {
+	failures    []time.Time
+	bannedUntil time.Time
+}
+
+// banTracker keeps the recent authentication failures and bans per client
+type banTracker struct {
+	mu      sync.Mutex
+	clients map[string]*banState
+}
+
+func newBanTracker() *banTracker {
+	return &banTracker{clients: make(map[string]*banState)}
+}
+
+// banned reports whether client is banned at now
+func (bt *banTracker) banned(client string, now time.Time) bool {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	state, found := bt.clients[client]
+	return found && now.Before(state.bannedUntil)
+}
+
+// recordFailure notes a failed authentication by client and reports whether
+// it got the client banned
+func (bt *banTracker) recordFailure(client string, now time.Time, ban *AuthBan) bool {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	state, found := bt.clients[client]
+	if !found {
+		if len(bt.clients) >= maxBanTrackedClients {
+			bt.sweep(now, ban.Window)
+		}
+		state = &banState{}
+		bt.clients[client] = state
+	}
+
+	state.failures = append(recentFailures(state.failures, now, ban.Window), now)
+	if len(state.failures) < ban.MaxFailures {
+		return false
+	}
+	state.failures = nil
+	state.bannedUntil = now.Add(ban.Duration)
+	return true
+}
+
+// sweep forgets clients that are not banned and have no recent failures
+func (bt *banTracker) sweep(now time.Time, window time.Duration) {
+	for client, state := range bt.clients {
+		if !now.Before(state.bannedUntil) && len(recentFailures(state.failures, now, window)) == 0 {
+			delete(bt.clients, client)
+		}
+	}
+}
+
+// recentFailures drops the failures older than window
+func recentFailures(failures []time.Time, now time.Time, window time.Duration) []time.Time {
+	for len(failures) > 0 && now.Sub(failures[0]) >= window {
+		failures = failures[1:]
+	}
+	return failures
+}
+
+// clientBanned reports whether ip is banned for failing to authenticate.
+// No client is banned without an AuthBan.
+func (ps *Server) clientBanned(ip net.IP) bool {
+	return ps.AuthBan != nil && ip != nil && ps.authBans.banned(ip.String(), ps.now())
+}
+
+// recordAuthFailure counts a failed authentication against ip when AuthBan
+// is set
+func (ps *Server) recordAuthFailure(ip net.IP) {
+	if ps.AuthBan == nil || ip == nil {
+		return
+	}
+	if ps.authBans.recordFailure(ip.String(), ps.now(), ps.AuthBan) {
+		log.Printf("Banning %s for %v after %d failed authentication attempts", ip, ps.AuthBan.Duration, ps.AuthBan.MaxFailures)
+	}
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBanTracker(t *testing.T) {
+	bt := newBanTracker()
+	ban := &AuthBan{MaxFailures: 3, Window: time.Minute, Duration: 10 * time.Minute}
+	now := time.Unix(1700000000, 0)
+
+	// Failures spread wider than the window never add up to a ban
+	for i := 0; i < 5; i++ {
+		if bt.recordFailure("192.0.2.1", now.Add(time.Duration(i)*40*time.Second), ban) {
+			t.Fatalf("Expected no ban for spread-out failure %d", i+1)
+		}
+	}
+
+	now = now.Add(time.Hour)
+	bt.recordFailure("192.0.2.2", now, ban)
+	bt.recordFailure("192.0.2.2", now.Add(time.Second), ban)
+	if !bt.recordFailure("192.0.2.2", now.Add(2*time.Second), ban) {
+		t.Fatal("Expected the third failure within the window to ban the client")
+	}
+	if !bt.banned("192.0.2.2", now.Add(9*time.Minute)) {
+		t.Error("Expected the client to stay banned for the duration")
+	}
+	if bt.banned("192.0.2.3", now) {
+		t.Error("Expected other clients to be unaffected")
+	}
+	if bt.banned("192.0.2.2", now.Add(11*time.Minute)) {
+		t.Error("Expected the ban to lift after the duration")
+	}
+}
+
+func TestBanTracker_Sweep(t *testing.T) {
+	bt := newBanTracker()
+	ban := &AuthBan{MaxFailures: 1, Window: time.Minute, Duration: time.Hour}
+	now := time.Unix(1700000000, 0)
+	bt.recordFailure("192.0.2.1", now, ban)
+	bt.clients["192.0.2.2"] = &banState{failures: []time.Time{now}}
+
+	bt.sweep(now.Add(2*time.Minute), ban.Window)
+	if _, found := bt.clients["192.0.2.1"]; !found {
+		t.Error("Expected banned clients to be kept")
+	}
+	if _, found := bt.clients["192.0.2.2"]; found {
+		t.Error("Expected clients without recent failures to be forgotten")
+	}
+}
+
+func TestAuthBan(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.AuthBan = &AuthBan{MaxFailures: 3, Window: time.Minute, Duration: 5 * time.Minute}
+	now := time.Unix(1700000000, 0)
+	proxy.now = func() time.Time { return now }
+
+	request := func(remoteAddr, user, pass string) int {
+		req := httptest.NewRequest("GET", backendServer.URL, nil)
+		req.RemoteAddr = remoteAddr
+		if user != "" {
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth(user, pass))
+		}
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Challenges for requests without credentials do not count
+	for i := 0; i < 5; i++ {
+		if code := request("192.0.2.1:1000", "", ""); code != http.StatusProxyAuthRequired {
+			t.Fatalf("Expected status %d, got %d", http.StatusProxyAuthRequired, code)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		if code := request("192.0.2.1:1000", "admin", "wrong"); code != http.StatusProxyAuthRequired {
+			t.Fatalf("Expected status %d for failure %d, got %d", http.StatusProxyAuthRequired, i+1, code)
+		}
+	}
+	if code := request("192.0.2.1:1001", "admin", "password123"); code != http.StatusForbidden {
+		t.Errorf("Expected banned client to get %d even with valid credentials, got %d", http.StatusForbidden, code)
+	}
+	if code := request("192.0.2.2:1000", "admin", "password123"); code != http.StatusOK {
+		t.Errorf("Expected other clients to get %d, got %d", http.StatusOK, code)
+	}
+
+	now = now.Add(5 * time.Minute)
+	if code := request("192.0.2.1:1002", "admin", "password123"); code != http.StatusOK {
+		t.Errorf("Expected the ban to lift after the cooldown, got %d", code)
+	}
+}
+
+func TestAuthBan_Config(t *testing.T) {
+	cfg, err := LoadConfig(writeConfigFile(t, "config.yaml", "port: \"8080\"\nusers:\n  - username: a\n    password: b\nauth_ban:\n  max_failures: 5\n  window: 1m\n  duration: 15m\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := NewProxyServerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := AuthBan{MaxFailures: 5, Window: time.Minute, Duration: 15 * time.Minute}
+	if proxy.AuthBan == nil || *proxy.AuthBan != expected {
+		t.Errorf("Expected auth ban %+v, got %+v", expected, proxy.AuthBan)
+	}
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// LoadDomainList reads a domain list file with one domain per line. Blank
+// lines and text after "#" are ignored.
+func LoadDomainList(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	domains, err := ParseDomainList(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return domains, nil
+}
+
+// ParseDomainList parses a domain list in the format read by LoadDomainList
+func ParseDomainList(r io.Reader) (map[string]struct{}, error) {
+	domains := make(map[string]struct{})
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		if strings.ContainsAny(text, " \t/:") {
+			return nil, fmt.Errorf("line %d: invalid domain %q", line, text)
+		}
+		domains[normalizeDomain(text)] = struct{}{}
+	}
+	return domains, scanner.Err()
+}
+
+// normalizeDomain lowercases a domain and drops its trailing root dot
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(domain), ".")
+}
+
+// domainBlocked reports whether host or any of its parent domains is in
+// BlockedDomains, so blocking example.com also blocks ads.example.com
+func (ps *Server) domainBlocked(host string) bool {
+	return domainListed(ps.BlockedDomains, host)
+}
+
+// LoadBlockedPage reads an HTML template for BlockedPage from path
+func LoadBlockedPage(path string) (*template.Template, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBlockedPage(string(text))
+}
+
+// ParseBlockedPage parses an HTML template for BlockedPage. {{.Host}} is
+// replaced by the blocked host.
+func ParseBlockedPage(text string) (*template.Template, error) {
+	return template.New("blocked").Parse(text)
+}
+
+// writeBlocked rejects a request for the blocked host, with BlockedPage when
+// one is configured
+func (ps *Server) writeBlocked(w http.ResponseWriter, r *http.Request, host string) {
+	if ps.BlockedPage == nil {
+		ps.writeError(w, r, "Access to this domain is blocked", http.StatusForbidden)
+		return
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	var page bytes.Buffer
+	if err := ps.BlockedPage.Execute(&page, struct{ Host string }{host}); err != nil {
+		log.Printf("Error rendering blocked page for %s: %v", host, err)
+		ps.writeError(w, r, "Access to this domain is blocked", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write(page.Bytes())
+}
+
+// domainListed reports whether host, with or without a port, or any of its
+// parent domains is in domains
+func domainListed(domains map[string]struct{}, host string) bool {
+	if len(domains) == 0 {
+		return false
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	domain := normalizeDomain(host)
+	for domain != "" {
+		if _, found := domains[domain]; found {
+			return true
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			break
+		}
+		domain = domain[i+1:]
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDomainBlocked(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.BlockedDomains = map[string]struct{}{"example.com": {}}
+
+	tests := []struct {
+		name     string
+		host     string
+		expected bool
+	}{
+		{"Exact match", "example.com", true},
+		{"Exact match with port", "example.com:443", true},
+		{"Subdomain match", "ads.example.com", true},
+		{"Nested subdomain match", "a.b.example.com:8080", true},
+		{"Case and root dot", "Ads.Example.COM.", true},
+		{"Non-matching domain", "example.org", false},
+		{"Suffix without dot", "badexample.com", false},
+		{"IP address", "127.0.0.1:80", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := proxy.domainBlocked(tt.host); result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestBlockedDomains_Handlers(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.BlockedDomains = map[string]struct{}{"example.com": {}}
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		expected int
+	}{
+		{"HTTP exact match", "GET", "http://example.com/", http.StatusForbidden},
+		{"HTTP subdomain match", "GET", "http://ads.example.com/banner.js", http.StatusForbidden},
+		{"CONNECT subdomain match", "CONNECT", "ads.example.com:443", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.method == "CONNECT" {
+				req.URL.Path = ""
+				req.RequestURI = tt.target
+			}
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+			w := httptest.NewRecorder()
+
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+
+	// A non-matching domain is proxied normally
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backendServer.Close()
+
+	req := httptest.NewRequest("GET", backendServer.URL, nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestLoadDomainList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	content := "# ad networks\nads.example.com\n\n  Tracker.Example.NET  # analytics\nmalware.test.\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	domains, err := LoadDomainList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"ads.example.com", "tracker.example.net", "malware.test"}
+	if len(domains) != len(expected) {
+		t.Errorf("Expected %d domains, got %d (%v)", len(expected), len(domains), domains)
+	}
+	for _, domain := range expected {
+		if _, found := domains[domain]; !found {
+			t.Errorf("Expected %s to be loaded", domain)
+		}
+	}
+
+	if _, err := ParseDomainList(strings.NewReader("http://example.com/\n")); err == nil {
+		t.Error("Expected error for a URL in the domain list")
+	}
+}
+
+func TestBlockedPage(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.BlockedDomains = map[string]struct{}{"example.com": {}}
+	page, err := ParseBlockedPage("<html><body><h1>{{.Host}} is blocked by company policy</h1></body></html>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.BlockedPage = page
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{"HTTP", "GET", "http://ads.example.com/banner", "<h1>ads.example.com is blocked by company policy</h1>"},
+		{"HTTP with port", "GET", "http://example.com:8080/", "<h1>example.com is blocked by company policy</h1>"},
+		{"CONNECT", "CONNECT", "example.com:443", "<h1>example.com is blocked by company policy</h1>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+				t.Errorf("Expected an HTML page, got Content-Type %q", ct)
+			}
+			if !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("Expected body to contain %q, got %q", tt.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestBlockedPage_EscapesHost(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	page, err := ParseBlockedPage("<p>{{.Host}}</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.BlockedPage = page
+
+	w := httptest.NewRecorder()
+	proxy.writeBlocked(w, httptest.NewRequest("GET", "http://example.com/", nil), "<script>x</script>")
+	if strings.Contains(w.Body.String(), "<script>") {
+		t.Errorf("Expected the host to be escaped, got %q", w.Body.String())
+	}
+}
+
+func TestLoadBlockedPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocked.html")
+	if err := os.WriteFile(path, []byte("<p>{{.Host}} blocked</p>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(writeConfigFile(t, "config.yaml", "port: \"8080\"\nusers:\n  - username: a\n    password: b\nblocked_page_file: "+path+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := NewProxyServerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var page strings.Builder
+	if err := proxy.BlockedPage.Execute(&page, struct{ Host string }{"example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if page.String() != "<p>example.com blocked</p>" {
+		t.Errorf("Expected the page from the file, got %q", page.String())
+	}
+
+	if _, err := LoadBlockedPage(filepath.Join(t.TempDir(), "missing.html")); err == nil {
+		t.Error("Expected an error for a missing page")
+	}
+}
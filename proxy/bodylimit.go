@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+)
+
+// errResponseTooLarge is returned once an upstream response body grows past
+// MaxResponseBody
+var errResponseTooLarge = errors.New("upstream response body too large")
+
+// limitedReader is an io.LimitReader that fails instead of reporting EOF
+// when the underlying reader has more than limit bytes
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+// newLimitedReader limits r to limit bytes, or returns r unchanged if limit
+// is not positive
+func newLimitedReader(r io.Reader, limit int64) io.Reader {
+	if limit <= 0 {
+		return r
+	}
+	return &limitedReader{r: r, remaining: limit}
+}
+
+func (lr *limitedReader) Read(b []byte) (int, error) {
+	if lr.remaining < 0 {
+		return 0, errResponseTooLarge
+	}
+	// Read one byte past the limit to tell an exact fit from an overflow
+	if int64(len(b)) > lr.remaining+1 {
+		b = b[:lr.remaining+1]
+	}
+	n, err := lr.r.Read(b)
+	if int64(n) > lr.remaining {
+		n = int(lr.remaining)
+		lr.remaining = -1
+		return n, errResponseTooLarge
+	}
+	lr.remaining -= int64(n)
+	return n, err
+}
+
+// maxDrainBody is how much of an unread request body drainBody discards.
+// Larger bodies are left for the HTTP server, which closes the connection
+// rather than read them.
+const maxDrainBody = 256 << 10
+
+// drainBody discards the rest of a request body that will not be forwarded
+// so the client connection can be reused, then closes it
+func drainBody(body io.ReadCloser) {
+	if body == nil {
+		return
+	}
+	io.CopyN(io.Discard, body, maxDrainBody)
+	body.Close()
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaxRequestBody(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Bodies cut off by the proxy end early, which is expected here
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.MaxRequestBody = 1024
+
+	tests := []struct {
+		name          string
+		size          int
+		unknownLength bool
+		expected      int
+	}{
+		{"Under limit", 512, false, http.StatusOK},
+		{"At limit", 1024, false, http.StatusOK},
+		{"Over limit with Content-Length", 1025, false, http.StatusRequestEntityTooLarge},
+		{"Over limit without Content-Length", 4096, true, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader = bytes.NewReader(make([]byte, tt.size))
+			if tt.unknownLength {
+				body = io.MultiReader(body)
+			}
+			req := httptest.NewRequest("POST", backendServer.URL, body)
+			if tt.unknownLength {
+				req.ContentLength = -1
+			}
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+			w := httptest.NewRecorder()
+
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestMaxResponseBody(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked" {
+			// Flushing first leaves the length unknown
+			w.(http.Flusher).Flush()
+		} else {
+			w.Header().Set("Content-Length", "5000")
+		}
+		w.Write(bytes.Repeat([]byte("z"), 5000))
+	}))
+	defer backendServer.Close()
+
+	tests := []struct {
+		name  string
+		path  string
+		major int
+		minor int
+	}{
+		{"HTTP/1.1 with Content-Length", "/", 1, 1},
+		{"HTTP/1.0 with Content-Length", "/", 1, 0},
+		{"HTTP/1.0 buffered", "/chunked", 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLog(t)
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.MaxResponseBody = 100
+
+			req := httptest.NewRequest("GET", backendServer.URL+tt.path, nil)
+			req.ProtoMajor, req.ProtoMinor = tt.major, tt.minor
+			req.Proto = fmt.Sprintf("HTTP/%d.%d", tt.major, tt.minor)
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+			w := httptest.NewRecorder()
+
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != http.StatusBadGateway {
+				t.Errorf("Expected status %d, got %d", http.StatusBadGateway, w.Code)
+			}
+			if w.Body.Len() >= 5000 {
+				t.Errorf("Expected the oversized body to be withheld, got %d bytes", w.Body.Len())
+			}
+			if tt.path == "/chunked" {
+				waitForLog(t, logs, "upstream response body too large")
+			}
+		})
+	}
+}
+
+func TestLimitedReader(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		limit       int64
+		expected    string
+		expectedErr error
+	}{
+		{"Under limit", "abc", 5, "abc", nil},
+		{"Exact fit", "abcde", 5, "abcde", nil},
+		{"Over limit", "abcdef", 5, "abcde", errResponseTooLarge},
+		{"No limit", "abcdef", 0, "abcdef", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := io.ReadAll(newLimitedReader(strings.NewReader(tt.input), tt.limit))
+			if err != tt.expectedErr {
+				t.Errorf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
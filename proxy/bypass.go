@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// noProxyMatch reports whether addr, a host with an optional port, matches
+// one of entries. Entries follow the NO_PROXY conventions of Go's httpproxy
+// package: "*" matches everything, an IP address or CIDR matches hosts
+// given as IPs, a domain matches itself and its subdomains, and a domain
+// with a leading "." or "*." matches only subdomains. IP addresses and
+// domains may carry a port, which then has to match as well.
+func noProxyMatch(entries []string, addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, ""
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		entryHost, entryPort, err := net.SplitHostPort(entry)
+		if err != nil {
+			entryHost, entryPort = entry, ""
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		entryHost = strings.TrimSuffix(strings.TrimPrefix(entryHost, "*"), ".")
+		if strings.HasPrefix(entryHost, ".") {
+			if strings.HasSuffix(host, entryHost) {
+				return true
+			}
+			continue
+		}
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
+
+// viaParent reports whether connections to addr are chained through
+// ParentProxy rather than dialed directly
+func (ps *Server) viaParent(addr string) bool {
+	return ps.ParentProxy != nil && !noProxyMatch(ps.NoProxyHosts, addr)
+}
+
+// parentProxyFor is the transport's Proxy function. It sends requests
+// through ParentProxy unless their host is in NoProxyHosts.
+func (ps *Server) parentProxyFor(req *http.Request) (*url.URL, error) {
+	if !ps.viaParent(upstreamAddr(req)) {
+		return nil, nil
+	}
+	return ps.ParentProxy, nil
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestNoProxyMatch(t *testing.T) {
+	entries := []string{"example.com", ".internal.test", "*.corp.test", "10.0.0.0/8", "192.168.1.5", "api.test:8443", "[fd00::1]:80"}
+
+	tests := []struct {
+		name     string
+		addr     string
+		expected bool
+	}{
+		{"Domain itself", "example.com:443", true},
+		{"Subdomain of domain", "www.EXAMPLE.com:80", true},
+		{"Domain suffix only", "notexample.com:443", false},
+		{"Leading dot subdomain", "db.internal.test:5432", true},
+		{"Leading dot apex", "internal.test:443", false},
+		{"Wildcard subdomain", "git.corp.test:443", true},
+		{"Wildcard apex", "corp.test:443", false},
+		{"CIDR", "10.1.2.3:443", true},
+		{"Outside CIDR", "11.1.2.3:443", false},
+		{"IP address", "192.168.1.5:22", true},
+		{"Other IP address", "192.168.1.6:22", false},
+		{"Port matches", "api.test:8443", true},
+		{"Port differs", "api.test:443", false},
+		{"IPv6 with port", "[fd00::1]:80", true},
+		{"IPv6 other port", "[fd00::1]:443", false},
+		{"Host without port", "example.com", true},
+		{"Unlisted host", "golang.org:443", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := noProxyMatch(entries, tt.addr); got != tt.expected {
+				t.Errorf("Expected %v for %s, got %v", tt.expected, tt.addr, got)
+			}
+		})
+	}
+
+	if !noProxyMatch([]string{"*"}, "anything.test:443") {
+		t.Error("Expected * to match every host")
+	}
+	if noProxyMatch(nil, "example.com:443") {
+		t.Error("Expected an empty list to match nothing")
+	}
+}
+
+func TestNoProxyHosts_BypassParent(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backendServer.Close()
+
+	tests := []struct {
+		name         string
+		noProxyHosts []string
+		expectedCode int
+	}{
+		{"Bypassed", []string{"127.0.0.0/8"}, http.StatusOK},
+		{"Through parent", []string{"example.com"}, http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Nothing listens on the parent, so only bypassed hosts are reachable
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.ParentProxy = &url.URL{Scheme: "http", Host: "127.0.0.1:" + freePort(t)}
+			proxy.NoProxyHosts = tt.noProxyHosts
+			allowConnectPort(t, proxy, echoAddr)
+			server := httptest.NewServer(proxy)
+			defer server.Close()
+
+			conn, _, resp := openTunnel(t, server.Listener.Addr().String(), echoAddr)
+			conn.Close()
+			if resp.StatusCode != tt.expectedCode {
+				t.Errorf("Expected CONNECT status %d, got %d", tt.expectedCode, resp.StatusCode)
+			}
+
+			req := httptest.NewRequest("GET", backendServer.URL, nil)
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != tt.expectedCode {
+				t.Errorf("Expected GET status %d, got %d", tt.expectedCode, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheableStatus lists the response codes stored by the response cache
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// hopByHopHeaders are connection specific and never stored in the cache
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Connection", "Proxy-Authenticate",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// cacheEntry is a stored response
+type cacheEntry struct {
+	key      string
+	status   int
+	header   http.Header
+	body     []byte
+	stored   time.Time
+	age      time.Duration
+	lifetime time.Duration
+}
+
+func (e *cacheEntry) size() int64 {
+	return int64(len(e.key) + len(e.body))
+}
+
+// responseCache is an in-memory LRU cache of GET responses bounded by the
+// total bytes of their keys and bodies
+type responseCache struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	entries  map[string]*list.Element
+	lru      *list.List
+}
+
+// newResponseCache creates a cache holding up to capacity bytes
+func newResponseCache(capacity int64) *responseCache {
+	return &responseCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// get returns the fresh entry for key at time now, if any. Stale entries are
+// removed.
+func (c *responseCache) get(key string, now time.Time) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if entry.currentAge(now) >= entry.lifetime {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry, true
+}
+
+// put stores entry, evicting the least recently used entries to make room.
+// Entries larger than the whole cache are not stored.
+func (c *responseCache) put(entry *cacheEntry) {
+	if entry.size() > c.capacity {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[entry.key]; ok {
+		c.remove(elem)
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += entry.size()
+	for c.size > c.capacity {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops elem from the cache. The caller must hold c.mu.
+func (c *responseCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size()
+}
+
+// len returns the number of stored entries
+func (c *responseCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// currentAge is how old the stored response is at time now, including the
+// age it already had when it was stored
+func (e *cacheEntry) currentAge(now time.Time) time.Duration {
+	return e.age + now.Sub(e.stored)
+}
+
+// cacheDirectives parses a Cache-Control header into lowercase directive
+// names and their values
+func cacheDirectives(h http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// cacheableRequest reports whether the response to r may be served from or
+// stored in the cache
+func cacheableRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet || r.Header.Get("Authorization") != "" {
+		return false
+	}
+	directives := cacheDirectives(r.Header)
+	_, noStore := directives["no-store"]
+	_, noCache := directives["no-cache"]
+	return !noStore && !noCache && r.Header.Get("Pragma") != "no-cache"
+}
+
+// freshnessLifetime returns how long resp stays fresh according to its
+// Cache-Control max-age or Expires header. It returns false for responses a
+// shared cache must not store or that carry no explicit freshness.
+func freshnessLifetime(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if !cacheableStatus[resp.StatusCode] || resp.Header.Get("Set-Cookie") != "" || resp.Header.Get("Vary") != "" {
+		return 0, false
+	}
+
+	directives := cacheDirectives(resp.Header)
+	for _, name := range []string{"no-store", "no-cache", "private"} {
+		if _, found := directives[name]; found {
+			return 0, false
+		}
+	}
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if value, found := directives[name]; found {
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+
+	if value := resp.Header.Get("Expires"); value != "" {
+		expires, err := http.ParseTime(value)
+		if err != nil {
+			return 0, false
+		}
+		date := now
+		if parsed, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+			date = parsed
+		}
+		if lifetime := expires.Sub(date); lifetime > 0 {
+			return lifetime, true
+		}
+	}
+	return 0, false
+}
+
+// newCacheEntry captures resp with the given body for storage at time now
+func newCacheEntry(key string, resp *http.Response, body []byte, lifetime time.Duration, now time.Time) *cacheEntry {
+	header := resp.Header.Clone()
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+	var age time.Duration
+	if seconds, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		age = time.Duration(seconds) * time.Second
+	}
+	return &cacheEntry{
+		key:      key,
+		status:   resp.StatusCode,
+		header:   header,
+		body:     body,
+		stored:   now,
+		age:      age,
+		lifetime: lifetime,
+	}
+}
+
+// responseCache returns the cache, creating it on first use, or nil when
+// ResponseCacheBytes is zero
+func (ps *Server) responseCache() *responseCache {
+	if ps.ResponseCacheBytes <= 0 {
+		return nil
+	}
+	ps.cacheOnce.Do(func() {
+		ps.cache = newResponseCache(ps.ResponseCacheBytes)
+	})
+	return ps.cache
+}
+
+// serveCached writes a cached response with its current Age, counting it
+// towards the requesting user's traffic
+func (ps *Server) serveCached(w http.ResponseWriter, entry *cacheEntry, traffic *userTraffic) {
+	for name, values := range entry.header {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	w.Header().Set("Age", strconv.FormatInt(int64(entry.currentAge(ps.now())/time.Second), 10))
+	w.Header().Set(cacheStatusHeader, "HIT")
+	ps.addVia(w.Header())
+	overrideHeaders(w.Header(), ps.ResponseHeaders)
+	w.WriteHeader(entry.status)
+	n, _ := w.Write(entry.body)
+	ps.metrics.recordBytes(0, int64(n))
+	if counter := traffic.downstreamCounter(); counter != nil {
+		counter.Add(int64(n))
+	}
+}
+
+// cacheStatusHeader tells clients whether a response came from the cache
+const cacheStatusHeader = "X-Cache"
+
+// captureWriter records up to limit bytes written through it, within the
+// request's memory budget, and notes when either ran out
+type captureWriter struct {
+	buf      bytes.Buffer
+	limit    int64
+	budget   *memoryBudget
+	overflow bool
+}
+
+func (cw *captureWriter) Write(p []byte) (int, error) {
+	if cw.overflow {
+		return len(p), nil
+	}
+	if int64(cw.buf.Len()+len(p)) > cw.limit || !cw.budget.reserve(int64(len(p))) {
+		// Give up on caching this response and free what was captured
+		cw.overflow = true
+		cw.budget.release(int64(cw.buf.Len()))
+		cw.buf = bytes.Buffer{}
+		return len(p), nil
+	}
+	cw.buf.Write(p)
+	return len(p), nil
+}
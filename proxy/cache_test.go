@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cacheTestProxy returns a proxy with a response cache and a clock tests
+// can move forward
+func cacheTestProxy(capacity int64) (*Server, *time.Time) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.ResponseCacheBytes = capacity
+	proxy.now = func() time.Time { return now }
+	return proxy, &now
+}
+
+func proxyGet(t *testing.T, proxy *Server, url string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("GET", url, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	return w
+}
+
+func TestResponseCache(t *testing.T) {
+	tests := []struct {
+		name           string
+		cacheControl   string
+		expires        string
+		requestHeader  http.Header
+		advance        time.Duration
+		expectedHits   int64
+		expectedHeader string
+	}{
+		{"max-age hit", "max-age=60", "", nil, 30 * time.Second, 1, "HIT"},
+		{"max-age expired", "max-age=60", "", nil, 61 * time.Second, 2, "MISS"},
+		{"s-maxage wins", "max-age=1, s-maxage=60", "", nil, 30 * time.Second, 1, "HIT"},
+		{"Expires hit", "", "Mon, 01 Jan 2024 12:05:00 GMT", nil, time.Minute, 1, "HIT"},
+		{"Expires passed", "", "Mon, 01 Jan 2024 12:05:00 GMT", nil, 6 * time.Minute, 2, "MISS"},
+		{"no-store", "no-store, max-age=60", "", nil, 0, 2, "MISS"},
+		{"no-cache", "no-cache, max-age=60", "", nil, 0, 2, "MISS"},
+		{"private", "private, max-age=60", "", nil, 0, 2, "MISS"},
+		{"No freshness", "", "", nil, 0, 2, "MISS"},
+		{"Client no-cache", "max-age=60", "", http.Header{"Cache-Control": {"no-cache"}}, 0, 2, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int64
+			backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := hits.Add(1)
+				w.Header().Set("Date", "Mon, 01 Jan 2024 12:00:00 GMT")
+				if tt.cacheControl != "" {
+					w.Header().Set("Cache-Control", tt.cacheControl)
+				}
+				if tt.expires != "" {
+					w.Header().Set("Expires", tt.expires)
+				}
+				fmt.Fprintf(w, "response %d", n)
+			}))
+			defer backendServer.Close()
+
+			proxy, now := cacheTestProxy(1 << 20)
+			first := proxyGet(t, proxy, backendServer.URL+"/page", tt.requestHeader)
+			if first.Body.String() != "response 1" {
+				t.Fatalf("Expected first response from backend, got %q", first.Body.String())
+			}
+
+			*now = now.Add(tt.advance)
+			second := proxyGet(t, proxy, backendServer.URL+"/page", tt.requestHeader)
+			if result := hits.Load(); result != tt.expectedHits {
+				t.Errorf("Expected %d backend requests, got %d", tt.expectedHits, result)
+			}
+			if result := second.Header().Get(cacheStatusHeader); result != tt.expectedHeader {
+				t.Errorf("Expected %s %q, got %q", cacheStatusHeader, tt.expectedHeader, result)
+			}
+			if tt.expectedHits == 1 {
+				if second.Body.String() != "response 1" {
+					t.Errorf("Expected cached body %q, got %q", "response 1", second.Body.String())
+				}
+				expectedAge := fmt.Sprint(int(tt.advance.Seconds()))
+				if age := second.Header().Get("Age"); age != expectedAge {
+					t.Errorf("Expected Age %s, got %q", expectedAge, age)
+				}
+			}
+		})
+	}
+}
+
+func TestResponseCache_Disabled(t *testing.T) {
+	var hits atomic.Int64
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("OK"))
+	}))
+	defer backendServer.Close()
+
+	proxy, _ := cacheTestProxy(0)
+	proxyGet(t, proxy, backendServer.URL, nil)
+	w := proxyGet(t, proxy, backendServer.URL, nil)
+	if hits.Load() != 2 {
+		t.Errorf("Expected 2 backend requests without a cache, got %d", hits.Load())
+	}
+	if result := w.Header().Get(cacheStatusHeader); result != "" {
+		t.Errorf("Expected no %s header, got %q", cacheStatusHeader, result)
+	}
+}
+
+func TestResponseCache_LRUEviction(t *testing.T) {
+	cache := newResponseCache(100)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	entry := func(key string) *cacheEntry {
+		return &cacheEntry{key: key, status: http.StatusOK, body: []byte(strings.Repeat("x", 40)), stored: now, lifetime: time.Minute}
+	}
+
+	cache.put(entry("a"))
+	cache.put(entry("b"))
+	// Touch a so b is the least recently used
+	if _, ok := cache.get("a", now); !ok {
+		t.Fatal("Expected a to be cached")
+	}
+	cache.put(entry("c"))
+
+	if _, ok := cache.get("b", now); ok {
+		t.Error("Expected least recently used entry b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.get(key, now); !ok {
+			t.Errorf("Expected %s to stay cached", key)
+		}
+	}
+	if cache.len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", cache.len())
+	}
+
+	// An entry larger than the whole cache is never stored
+	cache.put(&cacheEntry{key: "huge", body: make([]byte, 200), stored: now, lifetime: time.Minute})
+	if _, ok := cache.get("huge", now); ok {
+		t.Error("Expected oversized entry not to be cached")
+	}
+}
+
+func TestResponseCache_KeyedByRequestedURL(t *testing.T) {
+	var hits atomic.Int64
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprintf(w, "response %d", n)
+	})
+	first := httptest.NewServer(handler)
+	defer first.Close()
+	second := httptest.NewServer(handler)
+	defer second.Close()
+
+	proxy, _ := cacheTestProxy(1 << 20)
+	proxy.UpstreamPools = map[string][]Upstream{"api.internal": {
+		{Addr: first.Listener.Addr().String(), Weight: 1},
+		{Addr: second.Listener.Addr().String(), Weight: 1},
+	}}
+
+	proxyGet(t, proxy, "http://api.internal/page", nil)
+	w := proxyGet(t, proxy, "http://api.internal/page", nil)
+	if result := w.Header().Get(cacheStatusHeader); result != "HIT" {
+		t.Errorf("Expected %s HIT across pool backends, got %q", cacheStatusHeader, result)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("Expected 1 backend request, got %d", hits.Load())
+	}
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned instead of contacting an upstream whose circuit
+// breaker is open
+var errCircuitOpen = errors.New("upstream circuit breaker is open")
+
+// CircuitBreaker stops contacting an upstream host after repeated failures.
+// Once Cooldown has passed a single trial request is let through, and its
+// outcome closes the circuit again or keeps it open for another Cooldown.
+type CircuitBreaker struct {
+	// Threshold is the number of consecutive failures that open the circuit
+	Threshold int
+	// Cooldown is how long an open circuit rejects requests
+	Cooldown time.Duration
+}
+
+// circuitState is the breaker state of a single host
+type circuitState struct {
+	failures int
+	open     bool
+	openedAt time.Time
+	// trialAt is when the half-open trial was let through, zero when none
+	// is in flight
+	trialAt time.Time
+}
+
+// circuitTracker keeps the circuit breaker state per host. Hosts without
+// recent failures have no entry.
+type circuitTracker struct {
+	mu    sync.Mutex
+	hosts map[string]*circuitState
+}
+
+func newCircuitTracker() *circuitTracker {
+	return &circuitTracker{hosts: make(map[string]*circuitState)}
+}
+
+// allow reports whether host may be contacted at now
+func (ct *circuitTracker) allow(host string, now time.Time, cooldown time.Duration) bool {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	state, found := ct.hosts[host]
+	if !found || !state.open {
+		return true
+	}
+	if now.Sub(state.openedAt) < cooldown {
+		return false
+	}
+	// Half-open: one trial at a time. A trial whose outcome was never
+	// recorded stops blocking others after another cooldown.
+	if !state.trialAt.IsZero() && now.Sub(state.trialAt) < cooldown {
+		return false
+	}
+	state.trialAt = now
+	return true
+}
+
+// isOpen reports whether allow would refuse host at now, without letting a
+// half-open trial through
+func (ct *circuitTracker) isOpen(host string, now time.Time, cooldown time.Duration) bool {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	state, found := ct.hosts[host]
+	if !found || !state.open {
+		return false
+	}
+	if now.Sub(state.openedAt) < cooldown {
+		return true
+	}
+	return !state.trialAt.IsZero() && now.Sub(state.trialAt) < cooldown
+}
+
+// record notes whether contacting host failed
+func (ct *circuitTracker) record(host string, failed bool, now time.Time, threshold int) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	if !failed {
+		delete(ct.hosts, host)
+		return
+	}
+
+	state, found := ct.hosts[host]
+	if !found {
+		state = &circuitState{}
+		ct.hosts[host] = state
+	}
+	state.failures++
+	state.trialAt = time.Time{}
+	if state.open || state.failures >= threshold {
+		state.open = true
+		state.openedAt = now
+	}
+}
+
+// circuitAllows reports whether host may be contacted. Every host is
+// allowed without a CircuitBreaker.
+func (ps *Server) circuitAllows(host string) bool {
+	if ps.CircuitBreaker == nil {
+		return true
+	}
+	return ps.circuits.allow(host, ps.now(), ps.CircuitBreaker.Cooldown)
+}
+
+// circuitOpen reports whether circuitAllows would refuse host. Unlike
+// circuitAllows it never claims the half-open trial.
+func (ps *Server) circuitOpen(host string) bool {
+	if ps.CircuitBreaker == nil {
+		return false
+	}
+	return ps.circuits.isOpen(host, ps.now(), ps.CircuitBreaker.Cooldown)
+}
+
+// recordCircuit feeds the outcome of contacting host into its circuit
+// breaker, if enabled
+func (ps *Server) recordCircuit(host string, failed bool) {
+	if ps.CircuitBreaker == nil {
+		return
+	}
+	ps.circuits.record(host, failed, ps.now(), ps.CircuitBreaker.Threshold)
+}
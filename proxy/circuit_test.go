@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitTracker(t *testing.T) {
+	ct := newCircuitTracker()
+	now := time.Unix(1700000000, 0)
+	cooldown := time.Minute
+
+	ct.record("a:80", true, now, 3)
+	ct.record("a:80", true, now, 3)
+	if !ct.allow("a:80", now, cooldown) {
+		t.Error("Expected circuit to stay closed below the threshold")
+	}
+	ct.record("a:80", true, now, 3)
+	if ct.allow("a:80", now.Add(30*time.Second), cooldown) {
+		t.Error("Expected circuit to open at the threshold")
+	}
+	if !ct.allow("b:80", now, cooldown) {
+		t.Error("Expected other hosts to be unaffected")
+	}
+
+	// Half-open: a single trial, whose failure keeps the circuit open
+	now = now.Add(cooldown)
+	if !ct.allow("a:80", now, cooldown) {
+		t.Fatal("Expected a trial after the cooldown")
+	}
+	if ct.allow("a:80", now, cooldown) {
+		t.Error("Expected only one trial at a time")
+	}
+	ct.record("a:80", true, now, 3)
+	if ct.allow("a:80", now.Add(time.Second), cooldown) {
+		t.Error("Expected a failed trial to reopen the circuit")
+	}
+
+	// A successful trial closes it
+	now = now.Add(cooldown)
+	if !ct.allow("a:80", now, cooldown) {
+		t.Fatal("Expected a trial after the cooldown")
+	}
+	ct.record("a:80", false, now, 3)
+	if !ct.allow("a:80", now, cooldown) || !ct.allow("a:80", now, cooldown) {
+		t.Error("Expected a successful trial to close the circuit")
+	}
+	ct.record("a:80", true, now, 3)
+	if !ct.allow("a:80", now, cooldown) {
+		t.Error("Expected failures to be counted afresh after closing")
+	}
+}
+
+func TestCircuitTracker_AbandonedTrial(t *testing.T) {
+	ct := newCircuitTracker()
+	now := time.Unix(1700000000, 0)
+	ct.record("a:80", true, now, 1)
+
+	now = now.Add(time.Minute)
+	if !ct.allow("a:80", now, time.Minute) {
+		t.Fatal("Expected a trial after the cooldown")
+	}
+	// The trial's outcome is never recorded
+	if !ct.allow("a:80", now.Add(time.Minute), time.Minute) {
+		t.Error("Expected a new trial once the abandoned one has had a cooldown")
+	}
+}
+
+// newBreakerProxy returns a proxy with a circuit breaker that opens after two
+// failures, and a function advancing its clock
+func newBreakerProxy() (*Server, func(time.Duration)) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.CircuitBreaker = &CircuitBreaker{Threshold: 2, Cooldown: time.Minute}
+	now := time.Unix(1700000000, 0)
+	proxy.now = func() time.Time { return now }
+	return proxy, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestHandleHTTP_CircuitBreaker(t *testing.T) {
+	proxy, advance := newBreakerProxy()
+	addr := "127.0.0.1:" + freePort(t)
+
+	get := func() int {
+		req := httptest.NewRequest("GET", "http://"+addr+"/", nil)
+		req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := get(); code != http.StatusBadGateway {
+			t.Fatalf("Expected status %d while the upstream is down, got %d", http.StatusBadGateway, code)
+		}
+	}
+	if code := get(); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d once the circuit is open, got %d", http.StatusServiceUnavailable, code)
+	}
+
+	// The upstream recovers during the cooldown
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &httptest.Server{Listener: ln, Config: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}}
+	backend.Start()
+	defer backend.Close()
+
+	if code := get(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d during the cooldown, got %d", http.StatusServiceUnavailable, code)
+	}
+	advance(time.Minute)
+	for i := 0; i < 2; i++ {
+		if code := get(); code != http.StatusOK {
+			t.Errorf("Expected status %d after recovery, got %d", http.StatusOK, code)
+		}
+	}
+}
+
+func TestHandleHTTPS_CircuitBreaker(t *testing.T) {
+	proxy, advance := newBreakerProxy()
+	addr := "127.0.0.1:" + freePort(t)
+	allowConnectPort(t, proxy, addr)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	connect := func() int {
+		_, _, resp := openTunnel(t, server.Listener.Addr().String(), addr)
+		return resp.StatusCode
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := connect(); code != http.StatusBadGateway {
+			t.Fatalf("Expected status %d while the upstream is down, got %d", http.StatusBadGateway, code)
+		}
+	}
+	if code := connect(); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d once the circuit is open, got %d", http.StatusServiceUnavailable, code)
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	advance(time.Minute)
+	if code := connect(); code != http.StatusOK {
+		t.Errorf("Expected status %d after recovery, got %d", http.StatusOK, code)
+	}
+}
@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// clientHelloTimeout bounds how long a tunnel waits for the ClientHello
+const clientHelloTimeout = 10 * time.Second
+
+// tlsAlertProtocolVersion is a fatal protocol_version alert record, sent to
+// clients rejected by the ClientHello policy
+var tlsAlertProtocolVersion = []byte{0x15, 0x03, 0x01, 0x00, 0x02, 0x02, 0x46}
+
+// clientHelloInfo is the part of a TLS ClientHello the proxy inspects
+type clientHelloInfo struct {
+	maxVersion   uint16
+	cipherSuites []uint16
+}
+
+// peekClientHello returns the first TLS record sent by the client without
+// consuming it, along with the reader the tunnel must continue from. The
+// record is nil if the client is not speaking TLS.
+func peekClientHello(r *bufio.Reader) ([]byte, *bufio.Reader, error) {
+	header, err := r.Peek(5)
+	if err != nil {
+		return nil, r, err
+	}
+	if header[0] != 0x16 {
+		// Not a TLS handshake record
+		return nil, r, nil
+	}
+
+	size := 5 + int(binary.BigEndian.Uint16(header[3:5]))
+	if size > r.Size() {
+		// Grow the buffer so the whole record can be peeked
+		r = bufio.NewReaderSize(r, size)
+	}
+	record, err := r.Peek(size)
+	if err != nil {
+		return nil, r, err
+	}
+	return record, r, nil
+}
+
+// parseClientHello extracts the offered versions and cipher suites from a
+// TLS record containing a ClientHello
+func parseClientHello(record []byte) (*clientHelloInfo, error) {
+	errMalformed := errors.New("malformed ClientHello")
+
+	if len(record) < 5+4+2+32+1 || record[5] != 0x01 {
+		return nil, errMalformed
+	}
+	// Skip the record header, handshake header and random
+	data := record[5+4:]
+	info := &clientHelloInfo{maxVersion: binary.BigEndian.Uint16(data[0:2])}
+	data = data[2+32:]
+
+	// Session ID
+	sessionLen := int(data[0])
+	if len(data) < 1+sessionLen+2 {
+		return nil, errMalformed
+	}
+	data = data[1+sessionLen:]
+
+	// Cipher suites
+	suitesLen := int(binary.BigEndian.Uint16(data[0:2]))
+	if suitesLen%2 != 0 || len(data) < 2+suitesLen+1 {
+		return nil, errMalformed
+	}
+	for i := 0; i < suitesLen; i += 2 {
+		info.cipherSuites = append(info.cipherSuites, binary.BigEndian.Uint16(data[2+i:]))
+	}
+	data = data[2+suitesLen:]
+
+	// Compression methods
+	compressionLen := int(data[0])
+	if len(data) < 1+compressionLen {
+		return nil, errMalformed
+	}
+	data = data[1+compressionLen:]
+
+	// Extensions are optional in old ClientHellos
+	if len(data) < 2 {
+		return info, nil
+	}
+	extensionsLen := int(binary.BigEndian.Uint16(data[0:2]))
+	data = data[2:]
+	if len(data) < extensionsLen {
+		return nil, errMalformed
+	}
+	data = data[:extensionsLen]
+
+	for len(data) >= 4 {
+		extType := binary.BigEndian.Uint16(data[0:2])
+		extLen := int(binary.BigEndian.Uint16(data[2:4]))
+		if len(data) < 4+extLen {
+			return nil, errMalformed
+		}
+		ext := data[4 : 4+extLen]
+		data = data[4+extLen:]
+
+		// supported_versions supersedes the legacy version field
+		if extType == 43 && len(ext) >= 1 {
+			listLen := int(ext[0])
+			if len(ext) < 1+listLen {
+				return nil, errMalformed
+			}
+			info.maxVersion = 0
+			for i := 1; i+1 < 1+listLen; i += 2 {
+				version := binary.BigEndian.Uint16(ext[i:])
+				if !isGREASE(version) && version > info.maxVersion {
+					info.maxVersion = version
+				}
+			}
+		}
+	}
+	return info, nil
+}
+
+// isGREASE reports whether v is a reserved GREASE value (RFC 8701)
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a
+}
+
+// onlyWeakCiphers reports whether every real cipher suite offered is one
+// Go considers insecure. Unknown suites are not counted as weak.
+func onlyWeakCiphers(suites []uint16) bool {
+	insecure := make(map[uint16]bool)
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.ID] = true
+	}
+
+	offered := 0
+	for _, suite := range suites {
+		// Skip GREASE and the renegotiation and fallback signaling values
+		if isGREASE(suite) || suite == 0x00ff || suite == 0x5600 {
+			continue
+		}
+		offered++
+		if !insecure[suite] {
+			return false
+		}
+	}
+	return offered > 0
+}
+
+// clientHelloAllowed applies the ClientHello policy and returns the reason
+// for rejecting the client, if any
+func (ps *Server) clientHelloAllowed(info *clientHelloInfo) (bool, string) {
+	if ps.MinClientTLSVersion != 0 && info.maxVersion < ps.MinClientTLSVersion {
+		return false, fmt.Sprintf("client offers at most %s", tls.VersionName(info.maxVersion))
+	}
+	if ps.RejectWeakCiphers && onlyWeakCiphers(info.cipherSuites) {
+		return false, "client offers only weak cipher suites"
+	}
+	return true, ""
+}
+
+// checkClientHello inspects the TLS ClientHello at the start of a tunnel
+// without terminating TLS. It returns the reader the tunnel must continue
+// from and whether the tunnel may proceed. Rejected clients receive a TLS
+// alert. Tunnels that do not carry TLS are left alone.
+func (ps *Server) checkClientHello(clientConn net.Conn, r *bufio.Reader) (*bufio.Reader, bool) {
+	clientConn.SetReadDeadline(time.Now().Add(clientHelloTimeout))
+	record, r, err := peekClientHello(r)
+	clientConn.SetReadDeadline(time.Time{})
+	if err != nil {
+		log.Printf("Closing tunnel from %s: reading ClientHello: %v", clientConn.RemoteAddr(), err)
+		return r, false
+	}
+	if record == nil {
+		return r, true
+	}
+
+	info, err := parseClientHello(record)
+	if err != nil {
+		log.Printf("Closing tunnel from %s: %v", clientConn.RemoteAddr(), err)
+		return r, false
+	}
+	if ok, reason := ps.clientHelloAllowed(info); !ok {
+		log.Printf("Closing tunnel from %s: %s", clientConn.RemoteAddr(), reason)
+		clientConn.Write(tlsAlertProtocolVersion)
+		return r, false
+	}
+	return r, true
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// buildClientHello assembles a minimal TLS record carrying a ClientHello.
+// A non-empty versions list is sent as the supported_versions extension.
+func buildClientHello(legacyVersion uint16, versions []uint16, suites []uint16) []byte {
+	var body []byte
+	body = binary.BigEndian.AppendUint16(body, legacyVersion)
+	body = append(body, make([]byte, 32)...) // random
+	body = append(body, 0)                   // session ID
+
+	body = binary.BigEndian.AppendUint16(body, uint16(2*len(suites)))
+	for _, suite := range suites {
+		body = binary.BigEndian.AppendUint16(body, suite)
+	}
+	body = append(body, 1, 0) // null compression
+
+	if len(versions) > 0 {
+		var ext []byte
+		ext = binary.BigEndian.AppendUint16(ext, 43)
+		ext = binary.BigEndian.AppendUint16(ext, uint16(1+2*len(versions)))
+		ext = append(ext, byte(2*len(versions)))
+		for _, version := range versions {
+			ext = binary.BigEndian.AppendUint16(ext, version)
+		}
+		body = binary.BigEndian.AppendUint16(body, uint16(len(ext)))
+		body = append(body, ext...)
+	}
+
+	handshake := []byte{0x01, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+	handshake = append(handshake, body...)
+
+	record := []byte{0x16, 0x03, 0x01}
+	record = binary.BigEndian.AppendUint16(record, uint16(len(handshake)))
+	return append(record, handshake...)
+}
+
+func TestParseClientHello(t *testing.T) {
+	tests := []struct {
+		name            string
+		hello           []byte
+		expectedVersion uint16
+	}{
+		{"Legacy TLS 1.0", buildClientHello(tls.VersionTLS10, nil, []uint16{tls.TLS_RSA_WITH_AES_128_CBC_SHA}), tls.VersionTLS10},
+		{"TLS 1.3 via supported_versions", buildClientHello(tls.VersionTLS12, []uint16{0x0a0a, tls.VersionTLS13, tls.VersionTLS12}, []uint16{tls.TLS_AES_128_GCM_SHA256}), tls.VersionTLS13},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := parseClientHello(tt.hello)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if info.maxVersion != tt.expectedVersion {
+				t.Errorf("Expected max version %x, got %x", tt.expectedVersion, info.maxVersion)
+			}
+		})
+	}
+
+	if _, err := parseClientHello([]byte{0x16, 0x03, 0x01, 0x00, 0x01, 0x02}); err == nil {
+		t.Error("Expected error for truncated ClientHello")
+	}
+}
+
+func TestClientHelloPolicy(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.MinClientTLSVersion = tls.VersionTLS12
+	proxy.RejectWeakCiphers = true
+	allowConnectPort(t, proxy, echoAddr)
+	proxyServer := httptest.NewServer(proxy)
+	defer proxyServer.Close()
+
+	tests := []struct {
+		name    string
+		hello   []byte
+		allowed bool
+	}{
+		{"TLS 1.0 only is rejected", buildClientHello(tls.VersionTLS10, nil, []uint16{tls.TLS_RSA_WITH_AES_128_CBC_SHA}), false},
+		{"TLS 1.3 is allowed", buildClientHello(tls.VersionTLS12, []uint16{tls.VersionTLS13, tls.VersionTLS12}, []uint16{tls.TLS_AES_128_GCM_SHA256}), true},
+		{"Weak ciphers only are rejected", buildClientHello(tls.VersionTLS12, nil, []uint16{tls.TLS_RSA_WITH_RC4_128_SHA}), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, reader, resp := openTunnel(t, proxyServer.Listener.Addr().String(), echoAddr)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+			}
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			conn.Write(tt.hello)
+
+			if tt.allowed {
+				// The echo server returns the ClientHello untouched
+				echoed := make([]byte, len(tt.hello))
+				if _, err := io.ReadFull(reader, echoed); err != nil {
+					t.Fatalf("Expected tunnel to stay open: %v", err)
+				}
+				if !bytes.Equal(echoed, tt.hello) {
+					t.Error("ClientHello was altered in the tunnel")
+				}
+				return
+			}
+
+			rest, _ := io.ReadAll(reader)
+			if !bytes.Equal(rest, tlsAlertProtocolVersion) {
+				t.Errorf("Expected a TLS alert and close, got %x", rest)
+			}
+		})
+	}
+}
@@ -0,0 +1,498 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvironmentConfiguration(t *testing.T) {
+	// Save original environment
+	originalUsername := os.Getenv("PROXY_USERNAME")
+	originalPassword := os.Getenv("PROXY_PASSWORD")
+	originalPort := os.Getenv("PROXY_PORT")
+
+	// Clean up after test
+	defer func() {
+		os.Setenv("PROXY_USERNAME", originalUsername)
+		os.Setenv("PROXY_PASSWORD", originalPassword)
+		os.Setenv("PROXY_PORT", originalPort)
+	}()
+
+	tests := []struct {
+		name         string
+		envUsername  string
+		envPassword  string
+		envPort      string
+		expectedUser string
+		expectedPass string
+		expectedPort string
+	}{
+		{
+			name:         "All environment variables set",
+			envUsername:  "envuser",
+			envPassword:  "envpass",
+			envPort:      "9090",
+			expectedUser: "envuser",
+			expectedPass: "envpass",
+			expectedPort: "9090",
+		},
+		{
+			name:         "Only username set, others default",
+			envUsername:  "envuser",
+			envPassword:  "",
+			envPort:      "",
+			expectedUser: "envuser",
+			expectedPass: "password123",
+			expectedPort: "8080",
+		},
+		{
+			name:         "No environment variables, use defaults",
+			envUsername:  "",
+			envPassword:  "",
+			envPort:      "",
+			expectedUser: "admin",
+			expectedPass: "password123",
+			expectedPort: "8080",
+		},
+		{
+			name:         "Custom port only",
+			envUsername:  "",
+			envPassword:  "",
+			envPort:      "3128",
+			expectedUser: "admin",
+			expectedPass: "password123",
+			expectedPort: "3128",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Set environment variables
+			os.Setenv("PROXY_USERNAME", tt.envUsername)
+			os.Setenv("PROXY_PASSWORD", tt.envPassword)
+			os.Setenv("PROXY_PORT", tt.envPort)
+
+			// Get configuration like main() does
+			username := os.Getenv("PROXY_USERNAME")
+			password := os.Getenv("PROXY_PASSWORD")
+			port := os.Getenv("PROXY_PORT")
+
+			// Set default values if not provided
+			if username == "" {
+				username = "admin"
+			}
+			if password == "" {
+				password = "password123"
+			}
+			if port == "" {
+				port = "8080"
+			}
+
+			// Verify configuration
+			if username != tt.expectedUser {
+				t.Errorf("Expected username %s, got %s", tt.expectedUser, username)
+			}
+			if password != tt.expectedPass {
+				t.Errorf("Expected password %s, got %s", tt.expectedPass, password)
+			}
+			if port != tt.expectedPort {
+				t.Errorf("Expected port %s, got %s", tt.expectedPort, port)
+			}
+		})
+	}
+}
+
+func TestConfigurationValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		isValid  bool
+	}{
+		{
+			name:     "Valid credentials",
+			username: "admin",
+			password: "password123",
+			isValid:  true,
+		},
+		{
+			name:     "Empty username after defaults",
+			username: "",
+			password: "password123",
+			isValid:  false,
+		},
+		{
+			name:     "Empty password after defaults",
+			username: "admin",
+			password: "",
+			isValid:  false,
+		},
+		{
+			name:     "Both empty after defaults",
+			username: "",
+			password: "",
+			isValid:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Simulate validation logic from main()
+			isValid := tt.username != "" && tt.password != ""
+
+			if isValid != tt.isValid {
+				t.Errorf("Expected validity %v, got %v", tt.isValid, isValid)
+			}
+		})
+	}
+}
+
+func TestPortValidation(t *testing.T) {
+	validPorts := []string{"80", "8080", "3128", "1080", "9090"}
+	invalidPorts := []string{"", "0", "65536", "abc", "-1"}
+
+	for _, port := range validPorts {
+		t.Run("Valid port "+port, func(t *testing.T) {
+			if err := NewProxyServer("admin", "password123", port).Validate(); err != nil {
+				t.Errorf("Port %s should be valid, got %v", port, err)
+			}
+		})
+	}
+
+	for _, port := range invalidPorts {
+		t.Run("Invalid port "+port, func(t *testing.T) {
+			err := NewProxyServer("admin", "password123", port).Validate()
+			if err == nil {
+				t.Fatalf("Port %q should be invalid", port)
+			}
+			if !strings.Contains(err.Error(), fmt.Sprintf("%q", port)) {
+				t.Errorf("Expected error to name the port %q, got %v", port, err)
+			}
+		})
+	}
+
+	t.Run("Start rejects invalid port", func(t *testing.T) {
+		if err := NewProxyServer("admin", "password123", "abc").Start(); err == nil {
+			t.Error("Expected Start to fail for an invalid port")
+		}
+	})
+
+	t.Run("Invalid admin port", func(t *testing.T) {
+		proxy := NewProxyServer("admin", "password123", "8080")
+		proxy.AdminPort = "99999"
+		if err := proxy.Validate(); err == nil {
+			t.Error("Expected invalid admin port to be rejected")
+		}
+	})
+}
+
+func TestRandomPort(t *testing.T) {
+	tests := []struct {
+		name       string
+		port       string
+		adminPort  string
+		randomPort bool
+		valid      bool
+	}{
+		{name: "Port 0 rejected by default", port: "0", valid: false},
+		{name: "Admin port 0 rejected by default", port: "8080", adminPort: "0", valid: false},
+		{name: "Port 0 allowed", port: "0", randomPort: true, valid: true},
+		{name: "Admin port 0 allowed", port: "8080", adminPort: "0", randomPort: true, valid: true},
+		{name: "Out of range still rejected", port: "65536", randomPort: true, valid: false},
+		{name: "Negative still rejected", port: "-1", randomPort: true, valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := NewProxyServer("admin", "password123", tt.port)
+			proxy.AdminPort = tt.adminPort
+			proxy.RandomPort = tt.randomPort
+			err := proxy.Validate()
+			if (err == nil) != tt.valid {
+				t.Errorf("Expected valid %v, got %v", tt.valid, err)
+			}
+		})
+	}
+
+	t.Run("Binds a free port", func(t *testing.T) {
+		proxy := NewProxyServer("admin", "password123", "0")
+		proxy.RandomPort = true
+		go proxy.Start()
+		defer proxy.Shutdown(context.Background())
+
+		addr := waitForAddr(t, proxy)
+		if _, port, _ := net.SplitHostPort(addr.String()); port == "0" {
+			t.Errorf("Expected a bound port, got %s", addr)
+		}
+	})
+
+	t.Run("SOCKS5 rejects port 0 by default", func(t *testing.T) {
+		proxy := NewProxyServer("admin", "password123", "8080")
+		if err := proxy.StartSOCKS5("0"); err == nil {
+			t.Error("Expected StartSOCKS5 to reject port 0")
+		}
+	})
+}
+
+// writeConfigFile writes a config file with the given name into a temp dir
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("PROXY_USERNAME", "")
+	t.Setenv("PROXY_PASSWORD", "")
+	t.Setenv("PROXY_PORT", "")
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "YAML file",
+			file: "config.yaml",
+			content: `port: "3128"
+users:
+  - username: alice
+    password: secret1
+    tag: tier=premium
+  - username: bob
+    password: secret2
+timeouts:
+  request: 10s
+  dial: 5s
+  tunnel_idle: 2m
+  tunnel_max: 1h
+allowed_cidrs:
+  - 10.0.0.0/8
+upstream_pools:
+  api.internal:
+    - addr: 10.0.0.1:8080
+      weight: 3
+    - addr: 10.0.0.2:8080
+      weight: 1
+`,
+		},
+		{
+			name: "JSON file",
+			file: "config.json",
+			content: `{
+  "port": "3128",
+  "users": [
+    {"username": "alice", "password": "secret1", "tag": "tier=premium"},
+    {"username": "bob", "password": "secret2"}
+  ],
+  "timeouts": {"request": "10s", "dial": "5s", "tunnel_idle": "2m", "tunnel_max": "1h"},
+  "allowed_cidrs": ["10.0.0.0/8"],
+  "upstream_pools": {"api.internal": [
+    {"addr": "10.0.0.1:8080", "weight": 3},
+    {"addr": "10.0.0.2:8080", "weight": 1}
+  ]}
+}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfig(writeConfigFile(t, tt.file, tt.content))
+			if err != nil {
+				t.Fatalf("LoadConfig returned error: %v", err)
+			}
+
+			if cfg.Port != "3128" {
+				t.Errorf("Expected port 3128, got %s", cfg.Port)
+			}
+			if len(cfg.Users) != 2 {
+				t.Fatalf("Expected 2 users, got %d", len(cfg.Users))
+			}
+			if cfg.Users[0].Username != "alice" || cfg.Users[0].Password != "secret1" || cfg.Users[0].Tag != "tier=premium" {
+				t.Errorf("Unexpected first user %+v", cfg.Users[0])
+			}
+			if time.Duration(cfg.Timeouts.Request) != 10*time.Second {
+				t.Errorf("Expected request timeout 10s, got %v", time.Duration(cfg.Timeouts.Request))
+			}
+			if time.Duration(cfg.Timeouts.Dial) != 5*time.Second {
+				t.Errorf("Expected dial timeout 5s, got %v", time.Duration(cfg.Timeouts.Dial))
+			}
+			if len(cfg.AllowedCIDRs) != 1 || cfg.AllowedCIDRs[0] != "10.0.0.0/8" {
+				t.Errorf("Unexpected allowed CIDRs %v", cfg.AllowedCIDRs)
+			}
+
+			proxy, err := NewProxyServerFromConfig(cfg)
+			if err != nil {
+				t.Fatalf("NewProxyServerFromConfig returned error: %v", err)
+			}
+			if !proxy.authenticateRequest(authRequest("bob", "secret2")) {
+				t.Error("Second user should be accepted")
+			}
+			if proxy.PolicyTags["alice"] != "tier=premium" {
+				t.Errorf("Expected alice to carry tag tier=premium, got %q", proxy.PolicyTags["alice"])
+			}
+			if len(proxy.AllowedCIDRs) != 1 || proxy.AllowedCIDRs[0].String() != "10.0.0.0/8" {
+				t.Errorf("Unexpected parsed allowed CIDRs %v", proxy.AllowedCIDRs)
+			}
+			if proxy.RequestTimeout != 10*time.Second {
+				t.Errorf("Expected request timeout 10s, got %v", proxy.RequestTimeout)
+			}
+			if proxy.TunnelIdleTimeout != 2*time.Minute {
+				t.Errorf("Expected tunnel idle timeout 2m, got %v", proxy.TunnelIdleTimeout)
+			}
+			if proxy.MaxTunnelDuration != time.Hour {
+				t.Errorf("Expected max tunnel duration 1h, got %v", proxy.MaxTunnelDuration)
+			}
+			pool := proxy.UpstreamPools["api.internal"]
+			if len(pool) != 2 || pool[0] != (Upstream{Addr: "10.0.0.1:8080", Weight: 3}) {
+				t.Errorf("Unexpected upstream pool %+v", pool)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_EnvOverrides(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `port: "3128"
+users:
+  - username: alice
+    password: filepass
+`)
+
+	t.Setenv("PROXY_PORT", "9090")
+	t.Setenv("PROXY_USERNAME", "alice")
+	t.Setenv("PROXY_PASSWORD", "envpass")
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig returned error: %v", err)
+	}
+
+	if cfg.Port != "9090" {
+		t.Errorf("Expected port from environment 9090, got %s", cfg.Port)
+	}
+	if len(cfg.Users) != 1 || cfg.Users[0].Password != "envpass" {
+		t.Errorf("Expected password from environment, got %+v", cfg.Users)
+	}
+}
+
+func TestLoadConfig_ExpandsEnvironment(t *testing.T) {
+	t.Setenv("PROXY_USERNAME", "")
+	t.Setenv("PROXY_PORT", "")
+	t.Setenv("TEST_ALICE_PASSWORD", "s3cret")
+	t.Setenv("TEST_NO_PROXY_HOST", "internal.example.com")
+
+	path := writeConfigFile(t, "config.yaml", `port: "8080"
+users:
+  - username: alice
+    password: ${TEST_ALICE_PASSWORD}
+  - username: bob
+    password: $2a$10$abcdefghijklmnopqrstuv
+no_proxy_hosts:
+  - ${TEST_NO_PROXY_HOST}
+request_headers:
+  X-Secret: prefix-${TEST_ALICE_PASSWORD}
+`)
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig returned error: %v", err)
+	}
+
+	if cfg.Users[0].Password != "s3cret" {
+		t.Errorf("Expected expanded password s3cret, got %s", cfg.Users[0].Password)
+	}
+	if cfg.Users[1].Password != "$2a$10$abcdefghijklmnopqrstuv" {
+		t.Errorf("Expected bare $ references to be left alone, got %s", cfg.Users[1].Password)
+	}
+	if len(cfg.NoProxyHosts) != 1 || cfg.NoProxyHosts[0] != "internal.example.com" {
+		t.Errorf("Expected expanded no-proxy host, got %v", cfg.NoProxyHosts)
+	}
+	if got := cfg.RequestHeaders["X-Secret"]; got != "prefix-s3cret" {
+		t.Errorf("Expected expanded header value prefix-s3cret, got %s", got)
+	}
+}
+
+func TestLoadConfig_UndefinedEnvironment(t *testing.T) {
+	path := writeConfigFile(t, "config.json", `{
+  "port": "8080",
+  "users": [{"username": "alice", "password": "${TEST_UNSET_B}"}],
+  "request_headers": {"X-Token": "${TEST_UNSET_A}"}
+}`)
+
+	_, err := LoadConfig(path)
+	if err == nil {
+		t.Fatal("Expected an error for undefined environment variables")
+	}
+	if !strings.Contains(err.Error(), "undefined environment variable(s): TEST_UNSET_A, TEST_UNSET_B") {
+		t.Errorf("Expected error listing undefined variables, got %v", err)
+	}
+}
+
+func TestLoadConfig_DefaultMaxUsers(t *testing.T) {
+	t.Setenv("PROXY_USERNAME", "")
+	t.Setenv("PROXY_PORT", "")
+
+	users := func(n int) string {
+		entries := make([]string, n)
+		for i := range entries {
+			entries[i] = fmt.Sprintf(`{"username": "user%d", "password": "secret"}`, i)
+		}
+		return `{"port": "8080", "users": [` + strings.Join(entries, ",") + `]}`
+	}
+
+	if _, err := LoadConfig(writeConfigFile(t, "config.json", users(defaultMaxUsers))); err != nil {
+		t.Errorf("Expected %d users to load, got %v", defaultMaxUsers, err)
+	}
+	_, err := LoadConfig(writeConfigFile(t, "config.json", users(defaultMaxUsers+1)))
+	if err == nil || !strings.Contains(err.Error(), "more than max_users") {
+		t.Errorf("Expected an error past the default limit, got %v", err)
+	}
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	t.Setenv("PROXY_USERNAME", "")
+	t.Setenv("PROXY_PASSWORD", "")
+	t.Setenv("PROXY_PORT", "")
+
+	tests := []struct {
+		name        string
+		content     string
+		expectedErr string
+	}{
+		{"Missing port", "users:\n  - username: a\n    password: b\n", "port is required"},
+		{"Invalid port", "port: \"http\"\nusers:\n  - username: a\n    password: b\n", "invalid port \"http\""},
+		{"No users", "port: \"8080\"\n", "at least one user is required"},
+		{"Missing password", "port: \"8080\"\nusers:\n  - username: a\n", "password is required"},
+		{"Bad CIDR", "port: \"8080\"\nusers:\n  - username: a\n    password: b\nallowed_cidrs:\n  - 10.0.0.0/99\n", "allowed_cidrs"},
+		{"Bad duration", "port: \"8080\"\ntimeouts:\n  request: soon\n", "invalid duration"},
+		{"Too many users", "port: \"8080\"\nmax_users: 2\nusers:\n  - username: a\n    password: b\n  - username: c\n    password: d\n  - username: e\n    password: f\n", "3 users configured, more than max_users 2"},
+		{"Negative max_users", "port: \"8080\"\nmax_users: -1\nusers:\n  - username: a\n    password: b\n", "max_users must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfigFile(t, "config.yaml", tt.content))
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.expectedErr, err)
+			}
+		})
+	}
+
+	t.Run("Missing file", func(t *testing.T) {
+		if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+			t.Error("Expected an error for a missing file")
+		}
+	})
+}
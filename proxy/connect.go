@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// defaultConnectPort is assumed when a CONNECT target has no port
+const defaultConnectPort = 443
+
+// defaultAllowedConnectPorts are the ports CONNECT may target by default
+var defaultAllowedConnectPorts = []int{443, 80}
+
+// connectTarget normalizes a CONNECT target into a dialable host:port,
+// defaulting to port 443 when none is given. IPv6 literals may be given
+// with or without brackets when there is no port.
+func connectTarget(target string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		// No port present, so fall back to the default
+		host, portStr = target, strconv.Itoa(defaultConnectPort)
+		if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+			host = host[1 : len(host)-1]
+		}
+	}
+	if host == "" {
+		return "", 0, fmt.Errorf("missing host in CONNECT target %q", target)
+	}
+	if strings.HasPrefix(target, "[") && !isIPv6Literal(host) {
+		return "", 0, fmt.Errorf("invalid IPv6 address in CONNECT target %q", target)
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port in CONNECT target %q", target)
+	}
+	return net.JoinHostPort(host, portStr), port, nil
+}
+
+// isIPv6Literal reports whether host is an IPv6 address, optionally with a
+// zone
+func isIPv6Literal(host string) bool {
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.To4() == nil
+}
+
+// connectPortAllowed reports whether CONNECT may target the given port
+func (ps *Server) connectPortAllowed(port int) bool {
+	for _, allowed := range ps.AllowedConnectPorts {
+		if port == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectPortRestriction(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	tests := []struct {
+		name           string
+		target         string
+		expectedStatus int
+	}{
+		{"Allowed port 443", "invalid-host-that-does-not-exist.local:443", http.StatusBadGateway},
+		{"Disallowed port 22", "example.com:22", http.StatusForbidden},
+		{"Host with no port defaults to 443", "invalid-host-that-does-not-exist.local", http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("CONNECT", tt.target, nil)
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+			w := httptest.NewRecorder()
+
+			proxy.handleHTTPS(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestConnectTarget(t *testing.T) {
+	tests := []struct {
+		target       string
+		expectedAddr string
+		expectedPort int
+	}{
+		{"example.com:443", "example.com:443", 443},
+		{"example.com", "example.com:443", 443},
+		{"example.com:8443", "example.com:8443", 8443},
+		{"192.0.2.1:8080", "192.0.2.1:8080", 8080},
+		{"[::1]:443", "[::1]:443", 443},
+		{"[2001:db8::1]:8443", "[2001:db8::1]:8443", 8443},
+		{"[2001:db8::1]", "[2001:db8::1]:443", 443},
+		{"2001:db8::1", "[2001:db8::1]:443", 443},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			addr, port, err := connectTarget(tt.target)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if addr != tt.expectedAddr || port != tt.expectedPort {
+				t.Errorf("Expected %s (%d), got %s (%d)", tt.expectedAddr, tt.expectedPort, addr, port)
+			}
+		})
+	}
+
+	for _, target := range []string{"example.com:abc", "example.com:70000", ":443", "[]:443", "[example.com]:443", "[192.0.2.1]:443"} {
+		if _, _, err := connectTarget(target); err == nil {
+			t.Errorf("Expected error for target %q", target)
+		}
+	}
+}
+
+func TestConnectIPv6Tunnel(t *testing.T) {
+	ln, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	allowConnectPort(t, proxy, ln.Addr().String())
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	conn, reader, resp := openTunnel(t, server.Listener.Addr().String(), ln.Addr().String())
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprint(conn, "ping")
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(reader, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("Expected echo %q over IPv6, got %q (%v)", "ping", buf, err)
+	}
+}
+
+func TestHandleHTTPS_NotHijackable(t *testing.T) {
+	logs := captureLog(t)
+	echoAddr := startEchoServer(t)
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	allowConnectPort(t, proxy, echoAddr)
+
+	// A ResponseRecorder stands in for a writer that cannot be hijacked
+	req := httptest.NewRequest("CONNECT", echoAddr, nil)
+	req.Host = echoAddr
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	w := httptest.NewRecorder()
+	proxy.handleHTTPS(w, req)
+
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status %d, got %d", http.StatusNotImplemented, w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "CONNECT tunnels are not supported over HTTP/1.1") {
+		t.Errorf("Expected explanation in body, got %q", body)
+	}
+	waitForLog(t, logs, "cannot be hijacked")
+}
+
+func TestServeHTTP_ConnectNotHijackable(t *testing.T) {
+	logs := captureLog(t)
+	echoAddr := startEchoServer(t)
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	allowConnectPort(t, proxy, echoAddr)
+
+	// ServeHTTP wraps the writer, which must not make it look hijackable
+	req := httptest.NewRequest("CONNECT", echoAddr, nil)
+	req.Host = echoAddr
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status %d, got %d", http.StatusNotImplemented, w.Code)
+	}
+	waitForLog(t, logs, "cannot be hijacked")
+}
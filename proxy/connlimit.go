@@ -0,0 +1,32 @@
+package proxy
+
+import "net/http"
+
+// acquireConnection takes one of the MaxConcurrentConnections slots and
+// reports whether one was free
+func (ps *Server) acquireConnection() bool {
+	active := ps.metrics.activeConnections.Add(1)
+	if ps.MaxConcurrentConnections > 0 && active > int64(ps.MaxConcurrentConnections) {
+		ps.metrics.activeConnections.Add(-1)
+		return false
+	}
+	return true
+}
+
+// releaseConnection frees a slot taken by acquireConnection
+func (ps *Server) releaseConnection() {
+	ps.metrics.activeConnections.Add(-1)
+}
+
+// serveLimited runs serve unless MaxConcurrentConnections requests and
+// tunnels are already active. Tunnels hold their slot until they close.
+func (ps *Server) serveLimited(w http.ResponseWriter, r *http.Request) {
+	if !ps.acquireConnection() {
+		w.Header().Set("Retry-After", "1")
+		ps.writeError(w, r, "Service Unavailable: too many connections", http.StatusServiceUnavailable)
+		return
+	}
+	defer ps.releaseConnection()
+
+	ps.serve(w, r)
+}
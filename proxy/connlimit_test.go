@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMaxConcurrentConnections(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.MaxConcurrentConnections = 2
+	allowConnectPort(t, proxy, echoAddr)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+	proxyAddr := server.Listener.Addr().String()
+
+	// Saturate the limit with tunnels that stay open
+	var tunnels []func()
+	for i := 0; i < 2; i++ {
+		conn, _, resp := openTunnel(t, proxyAddr, echoAddr)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected tunnel %d to open, got status %d", i, resp.StatusCode)
+		}
+		tunnels = append(tunnels, func() { conn.Close() })
+	}
+
+	_, _, resp := openTunnel(t, proxyAddr, echoAddr)
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d at the limit, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	// A plain request is refused too while the tunnels hold their slots
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d at the limit, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	// Closing a tunnel releases its slot
+	tunnels[0]()
+	deadline := time.Now().Add(5 * time.Second)
+	for proxy.metrics.activeConnections.Load() > 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected slot to be released, %d still active", proxy.metrics.activeConnections.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conn, reader, resp := openTunnel(t, proxyAddr, echoAddr)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected tunnel after release, got status %d", resp.StatusCode)
+	}
+	fmt.Fprint(conn, "freed\n")
+	if line, _ := reader.ReadString('\n'); line != "freed\n" {
+		t.Errorf("Expected echo %q, got %q", "freed\n", line)
+	}
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Debug headers reporting how the upstream connection of a forwarded
+// request was obtained
+const (
+	upstreamReusedHeader  = "X-Proxy-Upstream-Reused"
+	upstreamDNSHeader     = "X-Proxy-Upstream-DNS"
+	upstreamConnectHeader = "X-Proxy-Upstream-Connect"
+)
+
+// connTrace records connection reuse and setup timings of one upstream
+// request. Dials may race for the same request, so fields are guarded by mu.
+type connTrace struct {
+	mu           sync.Mutex
+	gotConn      bool
+	reused       bool
+	dnsStart     time.Time
+	dns          time.Duration
+	connectStart time.Time
+	connect      time.Duration
+}
+
+// clientTrace returns the httptrace hooks filling in ct
+func (ct *connTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			ct.mu.Lock()
+			ct.dnsStart = time.Now()
+			ct.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			ct.mu.Lock()
+			ct.dns = time.Since(ct.dnsStart)
+			ct.mu.Unlock()
+		},
+		ConnectStart: func(network, addr string) {
+			ct.mu.Lock()
+			if ct.connectStart.IsZero() {
+				ct.connectStart = time.Now()
+			}
+			ct.mu.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			ct.mu.Lock()
+			if err == nil && ct.connect == 0 {
+				ct.connect = time.Since(ct.connectStart)
+			}
+			ct.mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			ct.mu.Lock()
+			ct.gotConn = true
+			ct.reused = info.Reused
+			ct.mu.Unlock()
+		},
+	}
+}
+
+// setHeaders adds the debug headers describing the traced connection
+func (ct *connTrace) setHeaders(h http.Header) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	h.Set(upstreamReusedHeader, strconv.FormatBool(ct.reused))
+	if !ct.reused {
+		h.Set(upstreamDNSHeader, ct.dns.String())
+		h.Set(upstreamConnectHeader, ct.connect.String())
+	}
+}
+
+// connState reports whether a connection was obtained and whether it was
+// reused
+func (ct *connTrace) connState() (gotConn, reused bool) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.gotConn, ct.reused
+}
+
+// record counts the traced connection in m
+func (ct *connTrace) record(m *Metrics) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	if ct.gotConn {
+		m.recordUpstreamConn(ct.reused)
+	}
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugConnectionHeaders(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.DebugConnectionHeaders = true
+
+	expected := []string{"false", "true"}
+	for i, reused := range expected {
+		req := httptest.NewRequest("GET", backendServer.URL, nil)
+		req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected status %d, got %d", i, http.StatusOK, w.Code)
+		}
+		if result := w.Header().Get(upstreamReusedHeader); result != reused {
+			t.Errorf("Request %d: expected %s %q, got %q", i, upstreamReusedHeader, reused, result)
+		}
+		hasConnect := w.Header().Get(upstreamConnectHeader) != ""
+		if hasConnect != (reused == "false") {
+			t.Errorf("Request %d: expected connect timing only for new connections, got %q", i, w.Header().Get(upstreamConnectHeader))
+		}
+	}
+
+	metrics := scrapeMetrics(t, proxy)
+	for _, line := range []string{
+		`proxy_upstream_connections_total{reused="false"} 1` + "\n",
+		`proxy_upstream_connections_total{reused="true"} 1` + "\n",
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, metrics)
+		}
+	}
+}
+
+func TestDebugConnectionHeaders_Disabled(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	req := httptest.NewRequest("GET", backendServer.URL, nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if result := w.Header().Get(upstreamReusedHeader); result != "" {
+		t.Errorf("Expected no debug headers by default, got %s %q", upstreamReusedHeader, result)
+	}
+}
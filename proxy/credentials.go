@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// credential is a user's password plus, while a rotation is in progress, the
+// previous password and the time it stops being accepted
+type credential struct {
+	password      string
+	gracePassword string
+	graceExpires  time.Time
+	// allowedDomains restricts the destinations the user may reach,
+	// including subdomains. Nil means unrestricted.
+	allowedDomains map[string]struct{}
+}
+
+// AddUser registers an additional set of credentials accepted by the proxy.
+// Adding an existing user replaces its password immediately.
+func (ps *Server) AddUser(username, password string) {
+	ps.usersMu.Lock()
+	defer ps.usersMu.Unlock()
+	ps.users[username] = &credential{password: password}
+}
+
+// RotatePassword replaces the password of an existing user. The old password
+// keeps working for window so clients can switch over without downtime.
+func (ps *Server) RotatePassword(username, newPassword string, window time.Duration) error {
+	ps.usersMu.Lock()
+	defer ps.usersMu.Unlock()
+
+	cred, found := ps.users[username]
+	if !found {
+		return fmt.Errorf("unknown user %q", username)
+	}
+
+	ps.users[username] = &credential{
+		password:       newPassword,
+		gracePassword:  cred.password,
+		graceExpires:   ps.now().Add(window),
+		allowedDomains: cred.allowedDomains,
+	}
+	return nil
+}
+
+// SetAllowedDomains restricts an existing user to the given destination
+// domains and their subdomains. An empty list lifts the restriction.
+func (ps *Server) SetAllowedDomains(username string, domains []string) error {
+	ps.usersMu.Lock()
+	defer ps.usersMu.Unlock()
+
+	cred, found := ps.users[username]
+	if !found {
+		return fmt.Errorf("unknown user %q", username)
+	}
+
+	updated := *cred
+	updated.allowedDomains = domainSet(domains)
+	ps.users[username] = &updated
+	return nil
+}
+
+// domainSet indexes normalized domains, returning nil for an empty list
+func domainSet(domains []string) map[string]struct{} {
+	if len(domains) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(domains))
+	for _, domain := range domains {
+		set[normalizeDomain(domain)] = struct{}{}
+	}
+	return set
+}
+
+// userMayReach reports whether username is allowed to reach host. Clients
+// without a user entry, such as bearer tokens, are unrestricted.
+func (ps *Server) userMayReach(username, host string) bool {
+	ps.usersMu.RLock()
+	cred, found := ps.users[username]
+	ps.usersMu.RUnlock()
+	if !found || cred.allowedDomains == nil {
+		return true
+	}
+	return domainListed(cred.allowedDomains, host)
+}
+
+// unknownUser stands in for a missing user so rejecting an unknown username
+// does the same work as rejecting a wrong password. Its password is a bcrypt
+// hash so this holds with HashedCredentials too.
+var unknownUser = &credential{password: "$2a$10$pnn1ndUHtXUL5ya8j.wuPefGSWvPt68PP8AsRV/kE3PZb3S.eQgDe"}
+
+// checkCredentials reports whether username and password match a known
+// user's current password, or its previous one during a rotation window.
+// Passwords are compared in constant time, and both candidates are always
+// compared, so timing reveals neither which field was wrong nor how much of
+// a password matched.
+func (ps *Server) checkCredentials(username, password string) bool {
+	ps.usersMu.RLock()
+	cred, found := ps.users[username]
+	ps.usersMu.RUnlock()
+	if !found {
+		cred = unknownUser
+	}
+
+	match := secureCompare
+	if ps.HashedCredentials {
+		match = ps.matchHash
+	}
+	current := match(password, cred.password)
+	grace := cred.gracePassword != "" && match(password, cred.gracePassword) &&
+		ps.now().Before(cred.graceExpires)
+	return found && (current || grace)
+}
+
+// matchHash reports whether password matches a bcrypt hash
+func (ps *Server) matchHash(password, hash string) bool {
+	key := sha256.Sum256([]byte(hash + "\x00" + password))
+	if ps.verifiedHashes.contains(key) {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+	ps.verifiedHashes.add(key)
+	return true
+}
+
+// maxVerifiedHashes bounds the cache of successful bcrypt checks
+const maxVerifiedHashes = 1024
+
+// hashCache is a set of digests of password and hash pairs known to match.
+// Only successes are cached, so guessing always pays the full bcrypt cost.
+type hashCache struct {
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]struct{}
+}
+
+func (hc *hashCache) contains(key [sha256.Size]byte) bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	_, ok := hc.entries[key]
+	return ok
+}
+
+func (hc *hashCache) add(key [sha256.Size]byte) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if hc.entries == nil || len(hc.entries) >= maxVerifiedHashes {
+		hc.entries = make(map[[sha256.Size]byte]struct{})
+	}
+	hc.entries[key] = struct{}{}
+}
+
+// validateHashes checks that every password is a bcrypt hash when
+// HashedCredentials is set
+func (ps *Server) validateHashes() error {
+	if !ps.HashedCredentials {
+		return nil
+	}
+	ps.usersMu.RLock()
+	defer ps.usersMu.RUnlock()
+
+	usernames := make([]string, 0, len(ps.users))
+	for username := range ps.users {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	for _, username := range usernames {
+		if _, err := bcrypt.Cost([]byte(ps.users[username].password)); err != nil {
+			return fmt.Errorf("password of user %q is not a bcrypt hash: %w", username, err)
+		}
+	}
+	return nil
+}
+
+// secureCompare reports whether a and b are equal in time independent of
+// their contents and lengths
+func secureCompare(a, b string) bool {
+	ha, hb := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// checkBearerToken returns the client name of token if it is one of the
+// BearerTokens. Every configured token is compared in constant time so the
+// response time does not reveal how close a guess was.
+func (ps *Server) checkBearerToken(token string) (string, bool) {
+	var name string
+	found := 0
+	for valid, client := range ps.BearerTokens {
+		if secureCompare(token, valid) {
+			name, found = client, 1
+		}
+	}
+	return name, found == 1 && token != ""
+}
@@ -0,0 +1,270 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRotatePassword(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	proxy := NewProxyServer("admin", "old-secret", "8080")
+	proxy.now = func() time.Time { return now }
+
+	if err := proxy.RotatePassword("admin", "new-secret", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		elapsed  time.Duration
+		password string
+		expected bool
+	}{
+		{"new password during window", 30 * time.Minute, "new-secret", true},
+		{"old password during window", 30 * time.Minute, "old-secret", true},
+		{"wrong password during window", 30 * time.Minute, "wrong", false},
+		{"new password after expiry", time.Hour, "new-secret", true},
+		{"old password after expiry", time.Hour, "old-secret", false},
+	}
+
+	start := now
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = start.Add(tt.elapsed)
+			result := proxy.authenticateRequest(authRequest("admin", tt.password))
+			if result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestRotatePassword_UnknownUser(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	if err := proxy.RotatePassword("nobody", "secret", time.Hour); err == nil {
+		t.Error("Expected error rotating an unknown user")
+	}
+}
+
+func TestAddUser_ClearsRotation(t *testing.T) {
+	proxy := NewProxyServer("admin", "old-secret", "8080")
+	proxy.RotatePassword("admin", "new-secret", time.Hour)
+	proxy.AddUser("admin", "reset-secret")
+
+	if proxy.authenticateRequest(authRequest("admin", "old-secret")) {
+		t.Error("Expected grace password to be dropped when the user is replaced")
+	}
+	if !proxy.authenticateRequest(authRequest("admin", "reset-secret")) {
+		t.Error("Expected replaced password to be accepted")
+	}
+}
+
+func TestBearerTokens(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.BearerTokens = map[string]string{"s3cr3t-token": "ci-runner"}
+
+	tests := []struct {
+		name         string
+		auth         string
+		expected     bool
+		expectedUser string
+	}{
+		{"Valid token", "Bearer s3cr3t-token", true, "ci-runner"},
+		{"Invalid token", "Bearer wrong-token", false, ""},
+		{"Token prefix", "Bearer s3cr3t", false, ""},
+		{"Empty token", "Bearer ", false, ""},
+		{"Basic still works", CreateBasicAuth("admin", "password123"), true, "admin"},
+		{"Invalid Basic", CreateBasicAuth("admin", "wrong"), false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com", nil)
+			req.Header.Set("Proxy-Authorization", tt.auth)
+			user, ok := proxy.authenticatedUser(req)
+			if ok != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, ok)
+			}
+			if user != tt.expectedUser {
+				t.Errorf("Expected user %q, got %q", tt.expectedUser, user)
+			}
+		})
+	}
+}
+
+func TestBearerTokens_NotConfigured(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Proxy-Authorization", "Bearer ")
+	if proxy.authenticateRequest(req) {
+		t.Error("Expected bearer auth to fail without configured tokens")
+	}
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusProxyAuthRequired {
+		t.Errorf("Expected status %d, got %d", http.StatusProxyAuthRequired, w.Code)
+	}
+	if challenges := w.Header().Values("Proxy-Authenticate"); len(challenges) != 1 {
+		t.Errorf("Expected only the Basic challenge, got %v", challenges)
+	}
+}
+
+func TestCheckCredentials(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.AddUser("bob", "hunter2")
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		expected bool
+	}{
+		{"Valid primary user", "admin", "password123", true},
+		{"Valid added user", "bob", "hunter2", true},
+		{"Wrong password", "admin", "password124", false},
+		{"Password prefix", "admin", "password", false},
+		{"Other user's password", "bob", "password123", false},
+		{"Empty password", "admin", "", false},
+		{"Unknown user", "mallory", "password123", false},
+		{"Unknown user with placeholder password", "mallory", unknownUser.password, false},
+		{"Username case differs", "Admin", "password123", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := proxy.checkCredentials(tt.username, tt.password); result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+// testBcryptHash is a cost 4 bcrypt hash of "correct horse battery staple"
+const testBcryptHash = "$2a$04$6flECeQfWhpc7Ii1hftp/.yR/nyarZWQpYXIhLn3ajQa2vLfPOmpe"
+
+func TestHashedCredentials(t *testing.T) {
+	proxy := NewProxyServer("admin", testBcryptHash, "8080")
+	proxy.HashedCredentials = true
+	if err := proxy.Validate(); err != nil {
+		t.Fatalf("Expected bcrypt hash to validate, got %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		expected bool
+	}{
+		{"Correct password", "admin", "correct horse battery staple", true},
+		{"Correct password again from cache", "admin", "correct horse battery staple", true},
+		{"Incorrect password", "admin", "Tr0ub4dor&3", false},
+		{"Hash itself is not the password", "admin", testBcryptHash, false},
+		{"Unknown user", "mallory", "correct horse battery staple", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := proxy.checkCredentials(tt.username, tt.password); result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestHashedCredentials_PlaintextMode(t *testing.T) {
+	// Without the flag the stored value is compared as a plain password
+	proxy := NewProxyServer("admin", testBcryptHash, "8080")
+	if proxy.checkCredentials("admin", "correct horse battery staple") {
+		t.Error("Expected plaintext mode not to treat the password as a hash")
+	}
+	if !proxy.checkCredentials("admin", testBcryptHash) {
+		t.Error("Expected plaintext mode to compare the stored value directly")
+	}
+}
+
+func TestHashedCredentials_ValidateRejectsPlaintext(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.HashedCredentials = true
+	if err := proxy.Validate(); err == nil {
+		t.Error("Expected Validate to reject a plaintext password in hashed mode")
+	}
+}
+
+func TestAllowedDomainsPerUser(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	defer backendServer.Close()
+	_, backendPort, _ := net.SplitHostPort(backendServer.Listener.Addr().String())
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.AddUser("alice", "alice-secret")
+	if err := proxy.SetAllowedDomains("alice", []string{"Example.com."}); err != nil {
+		t.Fatal(err)
+	}
+	// Every name resolves to the test backend
+	proxy.Resolver = startMockDNS(t, "")
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		host     string
+		expected int
+	}{
+		{"Allowed domain", "alice", "alice-secret", "example.com", http.StatusOK},
+		{"Allowed subdomain", "alice", "alice-secret", "api.example.com", http.StatusOK},
+		{"Other domain", "alice", "alice-secret", "other.com", http.StatusForbidden},
+		{"Suffix lookalike", "alice", "alice-secret", "badexample.com", http.StatusForbidden},
+		{"Unrestricted user", "admin", "password123", "other.com", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://"+net.JoinHostPort(tt.host, backendPort)+"/", nil)
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth(tt.username, tt.password))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+
+	// CONNECT is checked against the same list before dialing
+	req := httptest.NewRequest("CONNECT", "other.com:443", nil)
+	req.Host = "other.com:443"
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("alice", "alice-secret"))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected CONNECT status %d, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestSetAllowedDomains(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	if err := proxy.SetAllowedDomains("nobody", []string{"example.com"}); err == nil {
+		t.Error("Expected error for unknown user")
+	}
+
+	proxy.SetAllowedDomains("admin", []string{"example.com"})
+	if proxy.userMayReach("admin", "other.com:443") {
+		t.Error("Expected other.com to be blocked")
+	}
+	if err := proxy.RotatePassword("admin", "new-secret", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if proxy.userMayReach("admin", "other.com:443") {
+		t.Error("Expected allowlist to survive a password rotation")
+	}
+
+	proxy.SetAllowedDomains("admin", nil)
+	if !proxy.userMayReach("admin", "other.com:443") {
+		t.Error("Expected an empty list to lift the restriction")
+	}
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+)
+
+// revocationList is a parsed CRL with its revoked serial numbers indexed
+type revocationList struct {
+	crl     *x509.RevocationList
+	revoked map[string]struct{}
+}
+
+// ReloadCRL reads ClientCRLFile again so newly revoked client certificates
+// are rejected without restarting the server. The previous list stays in
+// use if the file cannot be loaded.
+func (ps *Server) ReloadCRL() error {
+	list, err := loadRevocationList(ps.ClientCRLFile)
+	if err != nil {
+		return fmt.Errorf("loading CRL %s: %w", ps.ClientCRLFile, err)
+	}
+	ps.crl.Store(list)
+	return nil
+}
+
+// loadRevocationList parses a PEM or DER encoded CRL file
+func loadRevocationList(path string) (*revocationList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "X509 CRL" {
+			return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+		}
+		data = block.Bytes
+	}
+
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, err
+	}
+	list := &revocationList{crl: crl, revoked: make(map[string]struct{})}
+	for _, entry := range crl.RevokedCertificateEntries {
+		list.revoked[entry.SerialNumber.String()] = struct{}{}
+	}
+	return list, nil
+}
+
+// clientCRLTLSConfig returns a copy of TLSConfig that also checks client
+// certificates against the CRL
+func (ps *Server) clientCRLTLSConfig() *tls.Config {
+	config := &tls.Config{}
+	if ps.TLSConfig != nil {
+		config = ps.TLSConfig.Clone()
+	}
+
+	verify := config.VerifyPeerCertificate
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if verify != nil {
+			if err := verify(rawCerts, verifiedChains); err != nil {
+				return err
+			}
+		}
+		return ps.verifyClientCertificate(rawCerts, verifiedChains)
+	}
+	return config
+}
+
+// verifyClientCertificate rejects client certificates revoked by the loaded
+// CRL. It runs as the TLS VerifyPeerCertificate callback.
+func (ps *Server) verifyClientCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	list := ps.crl.Load()
+	if list == nil || len(rawCerts) == 0 {
+		return nil
+	}
+
+	var leaf, issuer *x509.Certificate
+	if len(verifiedChains) > 0 && len(verifiedChains[0]) > 0 {
+		leaf = verifiedChains[0][0]
+		if len(verifiedChains[0]) > 1 {
+			issuer = verifiedChains[0][1]
+		}
+	} else {
+		var err error
+		if leaf, err = x509.ParseCertificate(rawCerts[0]); err != nil {
+			return err
+		}
+	}
+
+	// The CRL only speaks for certificates from its own issuer
+	if !bytes.Equal(leaf.RawIssuer, list.crl.RawIssuer) {
+		return nil
+	}
+	if issuer != nil {
+		if err := list.crl.CheckSignatureFrom(issuer); err != nil {
+			return fmt.Errorf("client certificate CRL is not signed by its issuer: %w", err)
+		}
+	}
+
+	if _, revoked := list.revoked[leaf.SerialNumber.String()]; revoked {
+		log.Printf("Rejected revoked client certificate %q (serial %s)", leaf.Subject.CommonName, leaf.SerialNumber)
+		return fmt.Errorf("client certificate %s has been revoked", leaf.SerialNumber)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA is a certificate authority that issues client certificates and CRLs
+type testCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Client CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// issueClientCert issues a client certificate with the given serial number
+func (ca *testCA) issueClientCert(t *testing.T, serial int64) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writeCRL writes a PEM CRL revoking the given serial numbers
+func (ca *testCA) writeCRL(t *testing.T, path string, number int64, revoked ...int64) {
+	t.Helper()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(number),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+	for _, serial := range revoked {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   big.NewInt(serial),
+			RevocationTime: time.Now().Add(-time.Minute),
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientCertificateRevocation(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backendServer.Close()
+
+	ca := newTestCA(t)
+	validCert := ca.issueClientCert(t, 2)
+	revokedCert := ca.issueClientCert(t, 3)
+	crlFile := filepath.Join(t.TempDir(), "clients.crl")
+	ca.writeCRL(t, crlFile, 1, 3)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	proxy := NewProxyServer("admin", "password123", freePort(t))
+	proxy.TLSConfig = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	proxy.ClientCRLFile = crlFile
+	logs := captureLog(t)
+	client := startTLSProxy(t, proxy, "admin", "password123")
+	transport := client.Transport.(*http.Transport)
+
+	get := func(cert tls.Certificate) error {
+		transport.CloseIdleConnections()
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+		resp, err := client.Get(backendServer.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if err := get(validCert); err != nil {
+		t.Errorf("Expected valid client certificate to be accepted, got %v", err)
+	}
+	if err := get(revokedCert); err == nil {
+		t.Error("Expected revoked client certificate to be rejected")
+	}
+	waitForLog(t, logs, "Rejected revoked client certificate")
+
+	// Revoking the valid certificate takes effect after a reload
+	ca.writeCRL(t, crlFile, 2, 2, 3)
+	if err := proxy.ReloadCRL(); err != nil {
+		t.Fatal(err)
+	}
+	if err := get(validCert); err == nil {
+		t.Error("Expected certificate revoked by the reloaded CRL to be rejected")
+	}
+}
+
+func TestReloadCRL_InvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.crl")
+	os.WriteFile(path, []byte("not a crl"), 0600)
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.ClientCRLFile = path
+	err := proxy.ReloadCRL()
+	if err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("Expected error naming %s, got %v", path, err)
+	}
+}
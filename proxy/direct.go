@@ -0,0 +1,34 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// defaultRobotsTxt asks crawlers not to index anything on the proxy itself
+const defaultRobotsTxt = "User-agent: *\nDisallow: /\n"
+
+// defaultHealthPath is where load balancers probe the proxy's liveness
+const defaultHealthPath = "/healthz"
+
+// handleDirect serves the proxy's own endpoints for origin-form requests.
+// These are answered without authentication.
+func (ps *Server) handleDirect(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/robots.txt" && ps.RobotsTxt != "":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, ps.RobotsTxt)
+	case r.URL.Path == ps.HealthPath && ps.HealthPath != "":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "OK\nuptime: %s\n", ps.now().Sub(ps.started).Truncate(time.Second))
+	case r.URL.Path == ps.PACPath && ps.PACPath != "":
+		ps.servePAC(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRobotsTxt(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	// Fetch robots.txt directly from the proxy without credentials
+	req := httptest.NewRequest("GET", "/robots.txt", nil)
+	w := httptest.NewRecorder()
+
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if w.Body.String() != "User-agent: *\nDisallow: /\n" {
+		t.Errorf("Expected deny-all robots.txt, got %q", w.Body.String())
+	}
+}
+
+func TestRobotsTxtCustom(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.RobotsTxt = "User-agent: *\nDisallow: /admin\n"
+
+	req := httptest.NewRequest("GET", "/robots.txt", nil)
+	w := httptest.NewRecorder()
+
+	proxy.ServeHTTP(w, req)
+
+	if w.Body.String() != proxy.RobotsTxt {
+		t.Errorf("Expected custom robots.txt, got %q", w.Body.String())
+	}
+}
+
+func TestRobotsTxtProxiedRequest(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("backend robots"))
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	// An absolute-form request for robots.txt must still be proxied
+	req := httptest.NewRequest("GET", backendServer.URL+"/robots.txt", nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	w := httptest.NewRecorder()
+
+	proxy.ServeHTTP(w, req)
+
+	if w.Body.String() != "backend robots" {
+		t.Errorf("Expected backend response, got %q", w.Body.String())
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.now = func() time.Time { return proxy.started.Add(90 * time.Second) }
+
+	// Probes hit the proxy directly without credentials
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	w := httptest.NewRecorder()
+
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if w.Body.String() != "OK\nuptime: 1m30s\n" {
+		t.Errorf("Expected OK with uptime, got %q", w.Body.String())
+	}
+}
+
+func TestHealthzCustomPath(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.HealthPath = "/livez"
+
+	tests := []struct {
+		path     string
+		expected int
+	}{
+		{"/livez", http.StatusOK},
+		{"/healthz", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestHealthzProxiedRequest(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("backend health"))
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	// An absolute-form request for /healthz must still be proxied
+	req := httptest.NewRequest("GET", backendServer.URL+"/healthz", nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	w := httptest.NewRecorder()
+
+	proxy.ServeHTTP(w, req)
+
+	if w.Body.String() != "backend health" {
+		t.Errorf("Expected backend response, got %q", w.Body.String())
+	}
+}
@@ -0,0 +1,50 @@
+package proxy_test
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"go-proxy-server/proxy"
+)
+
+// The Server is an http.Handler, so it can be served by an http.Server the
+// application already manages.
+func Example_embedding() {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello from the backend")
+	}))
+	defer backend.Close()
+
+	handler := proxy.New(proxy.Options{Username: "alice", Password: "s3cret"})
+	handler.SampleRate = 0
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(ln)
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		Proxy: http.ProxyURL(&url.URL{
+			Scheme: "http",
+			User:   url.UserPassword("alice", "s3cret"),
+			Host:   ln.Addr().String(),
+		}),
+	}}
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	fmt.Println(resp.StatusCode, string(body))
+	// Output: 200 hello from the backend
+}
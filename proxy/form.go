@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// requestForm identifies how a request target was written (RFC 7230 5.3)
+type requestForm int
+
+const (
+	// invalidForm is a target the proxy cannot act on unambiguously
+	invalidForm requestForm = iota
+	// originForm targets the proxy itself, e.g. "GET /robots.txt"
+	originForm
+	// absoluteForm targets a remote server, e.g. "GET http://host/path"
+	absoluteForm
+	// authorityForm is the "host:port" target of a CONNECT request
+	authorityForm
+)
+
+// classifyRequest determines the form of the request target
+func classifyRequest(r *http.Request) requestForm {
+	if r.Method == "CONNECT" {
+		if r.URL.Host != "" && r.URL.Path == "" {
+			return authorityForm
+		}
+		return invalidForm
+	}
+
+	if r.URL.IsAbs() {
+		scheme := strings.ToLower(r.URL.Scheme)
+		if (scheme == "http" || scheme == "https") && r.URL.Host != "" {
+			return absoluteForm
+		}
+		return invalidForm
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/") && r.URL.Host == "" {
+		// Proxy credentials on an origin-form request mean the client
+		// meant to proxy but did not say where to
+		if r.Header.Get("Proxy-Authorization") != "" {
+			return invalidForm
+		}
+		return originForm
+	}
+
+	return invalidForm
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClassifyRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		target    string
+		proxyAuth bool
+		expected  requestForm
+	}{
+		{"Absolute-form GET", "GET", "http://example.com/path", false, absoluteForm},
+		{"Absolute-form HTTPS", "GET", "https://example.com/", false, absoluteForm},
+		{"Origin-form GET", "GET", "/robots.txt", false, originForm},
+		{"Authority-form CONNECT", "CONNECT", "example.com:443", false, authorityForm},
+		{"Origin-form with proxy credentials", "GET", "/path", true, invalidForm},
+		{"Unsupported scheme", "GET", "ftp://example.com/file", false, invalidForm},
+		{"CONNECT with origin-form", "CONNECT", "/path", false, invalidForm},
+		{"Asterisk-form", "OPTIONS", "*", false, invalidForm},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.proxyAuth {
+				req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+			}
+
+			if form := classifyRequest(req); form != tt.expected {
+				t.Errorf("Expected form %d, got %d", tt.expected, form)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_RequestForms(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxied"))
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	// Absolute-form is forwarded upstream
+	t.Run("Absolute-form is proxied", func(t *testing.T) {
+		req := httptest.NewRequest("GET", backendServer.URL+"/page", nil)
+		req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+		w := httptest.NewRecorder()
+
+		proxy.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK || w.Body.String() != "proxied" {
+			t.Errorf("Expected proxied response, got %d %q", w.Code, w.Body.String())
+		}
+	})
+
+	// Origin-form is answered by the proxy itself
+	t.Run("Origin-form is direct", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/page", nil)
+		w := httptest.NewRecorder()
+
+		proxy.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+		}
+	})
+
+	// Origin-form carrying proxy credentials is ambiguous
+	t.Run("Ambiguous request is rejected", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/page", nil)
+		req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+		w := httptest.NewRecorder()
+
+		proxy.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+}
@@ -0,0 +1,248 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// defaultMaxDecompressedBytes caps how far an inspected gzip body may expand
+const defaultMaxDecompressedBytes = 100 << 20
+
+// errDecompressedTooLarge is returned when a gzip body expands past the
+// decompression cap, which is the signature of a gzip bomb
+var errDecompressedTooLarge = errors.New("decompressed body exceeds size limit")
+
+// cappedGzipReader decompresses a gzip stream and fails once more than
+// limit bytes have been produced
+type cappedGzipReader struct {
+	zr        *gzip.Reader
+	remaining int64
+}
+
+// newCappedGzipReader returns a reader of the decompressed contents of r
+// that fails with errDecompressedTooLarge past limit bytes. A limit of zero
+// or less disables the cap.
+func newCappedGzipReader(r io.Reader, limit int64) (io.Reader, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		return zr, nil
+	}
+	return &cappedGzipReader{zr: zr, remaining: limit}, nil
+}
+
+func (cr *cappedGzipReader) Read(b []byte) (int, error) {
+	if cr.remaining < 0 {
+		return 0, errDecompressedTooLarge
+	}
+	// Decompress one byte past the cap to tell an exact fit from a bomb
+	if int64(len(b)) > cr.remaining+1 {
+		b = b[:cr.remaining+1]
+	}
+	n, err := cr.zr.Read(b)
+	if int64(n) > cr.remaining {
+		cr.remaining = -1
+		return 0, errDecompressedTooLarge
+	}
+	cr.remaining -= int64(n)
+	return n, err
+}
+
+// gunzippedSize returns the decompressed size of the gzip data in r without
+// keeping the decompressed bytes
+func gunzippedSize(r io.Reader, limit int64) (int64, error) {
+	zr, err := newCappedGzipReader(r, limit)
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(io.Discard, zr)
+}
+
+// inspectPrefixSize bounds the first read of an inspected body, which is
+// decompressed before the response status is sent
+const inspectPrefixSize = 32 << 10
+
+// gzipInspector measures the decompressed size of a gzip body written to it
+// while the compressed bytes are relayed unchanged. Each write returns once
+// it has been decompressed, and fails with errDecompressedTooLarge once the
+// body expands past the cap. Corrupt bodies never fail writes, so they reach
+// the client as they are.
+type gzipInspector struct {
+	chunks chan []byte
+	acks   chan struct{}
+	done   chan struct{}
+	size   int64
+	err    error
+
+	closeOnce sync.Once
+}
+
+// newGzipInspector starts decompressing everything written to the inspector,
+// giving up past limit bytes
+func newGzipInspector(limit int64) *gzipInspector {
+	gi := &gzipInspector{
+		chunks: make(chan []byte),
+		acks:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(gi.done)
+		gi.size, gi.err = gunzippedSize(&inspectorInput{gi: gi}, limit)
+	}()
+	return gi
+}
+
+func (gi *gzipInspector) Write(p []byte) (int, error) {
+	select {
+	case gi.chunks <- p:
+		select {
+		case <-gi.acks:
+			return len(p), nil
+		case <-gi.done:
+		}
+	case <-gi.done:
+	}
+	if gi.err == errDecompressedTooLarge {
+		return 0, gi.err
+	}
+	return len(p), nil
+}
+
+// finish ends the body and returns its decompressed size
+func (gi *gzipInspector) finish() (int64, error) {
+	gi.closeOnce.Do(func() { close(gi.chunks) })
+	<-gi.done
+	return gi.size, gi.err
+}
+
+// inspectorInput feeds the chunks written to a gzipInspector to its
+// decompressor, acknowledging each once it has been used up
+type inspectorInput struct {
+	gi      *gzipInspector
+	buf     []byte
+	pending bool
+}
+
+func (in *inspectorInput) Read(b []byte) (int, error) {
+	if len(in.buf) == 0 {
+		if in.pending {
+			in.gi.acks <- struct{}{}
+			in.pending = false
+		}
+		chunk, ok := <-in.gi.chunks
+		if !ok {
+			return 0, io.EOF
+		}
+		in.buf, in.pending = chunk, true
+	}
+	n := copy(b, in.buf)
+	in.buf = in.buf[n:]
+	return n, nil
+}
+
+// recordInspection logs the compressed and decompressed sizes of an
+// inspected response and adds the latter to the access log entry
+func (ps *Server) recordInspection(r *http.Request, inspector *gzipInspector, compressed int64) {
+	size, err := inspector.finish()
+	if err != nil {
+		log.Printf("Could not inspect gzip response from %s: %v", r.URL.Host, err)
+		return
+	}
+	requestInfoFromContext(r.Context()).unzipped = size
+	if isSampled(r.Context()) {
+		log.Printf("Response from %s: %d bytes gzip, %d bytes uncompressed", r.URL.Host, compressed, size)
+	}
+}
+
+// minEncodedRequestBody is the smallest request body of known length that
+// EncodeRequestBodies compresses. Smaller bodies gain less than the gzip
+// framing costs.
+const minEncodedRequestBody = 1 << 10
+
+// compressedMediaTypes are request body types already compressed, so
+// gzipping them again only adds overhead
+var compressedMediaTypes = []string{
+	"application/gzip", "application/x-gzip", "application/zip",
+	"application/zstd", "image/", "video/", "audio/",
+}
+
+// shouldEncodeRequestBody reports whether a request body of contentLength
+// bytes, -1 when unknown, is worth compressing and not already encoded
+func shouldEncodeRequestBody(contentLength int64, h http.Header) bool {
+	if contentLength == 0 || (contentLength > 0 && contentLength < minEncodedRequestBody) {
+		return false
+	}
+	if encoding := h.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return false
+	}
+	contentType := strings.ToLower(h.Get("Content-Type"))
+	for _, prefix := range compressedMediaTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeRequestBody gzips the body of req as it is sent, including bodies
+// resent on retries. The compressed length is unknown up front, so the
+// body is sent chunked.
+func encodeRequestBody(req *http.Request) {
+	req.Body = gzipBody(req.Body)
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return gzipBody(body), nil
+		}
+	}
+	req.ContentLength = -1
+	req.Header.Del("Content-Length")
+	req.Header.Set("Content-Encoding", "gzip")
+}
+
+// gzipBody returns a reader of the gzip-compressed contents of body
+func gzipBody(body io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	return &gzipBodyReader{body: body, pr: pr, pw: pw}
+}
+
+// gzipBodyReader compresses its body on demand. Nothing is read from the
+// body before the first Read, so a client expecting 100 Continue is not
+// asked for its body before the upstream is.
+type gzipBodyReader struct {
+	body  io.ReadCloser
+	pr    *io.PipeReader
+	pw    *io.PipeWriter
+	start sync.Once
+}
+
+func (gr *gzipBodyReader) Read(p []byte) (int, error) {
+	gr.start.Do(func() {
+		go func() {
+			defer gr.body.Close()
+			zw := gzip.NewWriter(gr.pw)
+			_, err := io.Copy(zw, gr.body)
+			if err == nil {
+				err = zw.Close()
+			}
+			gr.pw.CloseWithError(err)
+		}()
+	})
+	return gr.pr.Read(p)
+}
+
+// Close stops the compression, closing the body if it was never read
+func (gr *gzipBodyReader) Close() error {
+	gr.start.Do(func() { gr.body.Close() })
+	return gr.pr.Close()
+}
@@ -0,0 +1,276 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// gzipBytes compresses data with gzip
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGunzippedSize(t *testing.T) {
+	// 8MB of zeros compresses to a few kilobytes
+	bomb := gzipBytes(t, make([]byte, 8<<20))
+	if len(bomb) > 64<<10 {
+		t.Fatalf("Expected a highly compressible payload, got %d compressed bytes", len(bomb))
+	}
+
+	tests := []struct {
+		name         string
+		data         []byte
+		limit        int64
+		expectedSize int64
+		expectedErr  error
+	}{
+		{"Bomb over cap", bomb, 1 << 20, 0, errDecompressedTooLarge},
+		{"Bomb without cap", bomb, 0, 8 << 20, nil},
+		{"Exact fit", gzipBytes(t, []byte("hello")), 5, 5, nil},
+		{"Under cap", gzipBytes(t, []byte("hello")), 1024, 5, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size, err := gunzippedSize(bytes.NewReader(tt.data), tt.limit)
+			if err != tt.expectedErr {
+				t.Fatalf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+			if tt.expectedErr == nil && size != tt.expectedSize {
+				t.Errorf("Expected size %d, got %d", tt.expectedSize, size)
+			}
+			if tt.expectedErr != nil && size > tt.limit {
+				t.Errorf("Expected at most %d bytes before rejecting, got %d", tt.limit, size)
+			}
+		})
+	}
+}
+
+func TestGunzippedSize_InvalidGzip(t *testing.T) {
+	if _, err := gunzippedSize(bytes.NewReader([]byte("not gzip")), 1024); err == nil {
+		t.Error("Expected error for invalid gzip data")
+	}
+}
+
+func TestGzipInspector(t *testing.T) {
+	bomb := gzipBytes(t, make([]byte, 8<<20))
+
+	tests := []struct {
+		name        string
+		data        []byte
+		expectedErr error
+	}{
+		{"Bomb over cap", bomb, errDecompressedTooLarge},
+		{"Under cap", gzipBytes(t, []byte("hello")), nil},
+		{"Corrupt gzip", []byte("not gzip at all"), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inspector := newGzipInspector(1 << 20)
+			_, err := io.Copy(inspector, bytes.NewReader(tt.data))
+			inspector.finish()
+			if err != tt.expectedErr {
+				t.Errorf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestInspectBodies(t *testing.T) {
+	plain := bytes.Repeat([]byte("inspect me "), 1000)
+	compressed := gzipBytes(t, plain)
+
+	tests := []struct {
+		name             string
+		inspect          bool
+		data             []byte
+		expectedUnzipped float64
+	}{
+		{"Inspected", true, compressed, float64(len(plain))},
+		{"Disabled", false, compressed, 0},
+		{"Corrupt gzip", true, []byte("not gzip at all"), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "gzip")
+				w.Write(tt.data)
+			}))
+			defer backendServer.Close()
+
+			var output bytes.Buffer
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.InspectBodies = tt.inspect
+			proxy.AccessLog = NewJSONLogger(&output)
+
+			req := httptest.NewRequest("GET", backendServer.URL, nil)
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+			req.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			// The client gets the upstream bytes untouched
+			if !bytes.Equal(w.Body.Bytes(), tt.data) {
+				t.Errorf("Expected the original %d bytes, got %d", len(tt.data), w.Body.Len())
+			}
+			if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+				t.Errorf("Expected Content-Encoding gzip, got %q", got)
+			}
+
+			var entry map[string]interface{}
+			if err := json.Unmarshal(output.Bytes(), &entry); err != nil {
+				t.Fatalf("Access log is not valid JSON: %v (%q)", err, output.String())
+			}
+			if entry["bytes"] != float64(len(tt.data)) {
+				t.Errorf("Expected bytes=%d, got %v", len(tt.data), entry["bytes"])
+			}
+			unzipped, _ := entry["unzipped_bytes"].(float64)
+			if unzipped != tt.expectedUnzipped {
+				t.Errorf("Expected unzipped_bytes=%v, got %v", tt.expectedUnzipped, unzipped)
+			}
+		})
+	}
+}
+
+func TestInspectBodies_RefusesBomb(t *testing.T) {
+	// Random bytes barely compress, so the zeros after them expand past
+	// the cap only once the response has started
+	random := make([]byte, 256<<10)
+	rand.Read(random)
+
+	tests := []struct {
+		name           string
+		data           []byte
+		expectedStatus int
+		cutOff         bool
+	}{
+		{"Before headers", gzipBytes(t, make([]byte, 8<<20)), http.StatusBadGateway, false},
+		{"Mid-body", gzipBytes(t, append(random, make([]byte, 8<<20)...)), http.StatusOK, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "gzip")
+				w.Write(tt.data)
+			}))
+			defer backendServer.Close()
+
+			captureLog(t)
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.InspectBodies = true
+			proxy.MaxDecompressedBytes = 1 << 20
+			proxyServer := httptest.NewServer(proxy)
+			defer proxyServer.Close()
+
+			proxyURL, _ := url.Parse(proxyServer.URL)
+			proxyURL.User = url.UserPassword("admin", "password123")
+			client := &http.Client{Transport: &http.Transport{
+				Proxy:              http.ProxyURL(proxyURL),
+				DisableCompression: true,
+			}}
+			req, _ := http.NewRequest("GET", backendServer.URL, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, readErr := io.ReadAll(resp.Body)
+
+			if bytes.Equal(body, tt.data) {
+				t.Fatal("Expected the gzip bomb not to be delivered")
+			}
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+			if tt.cutOff && readErr == nil {
+				t.Error("Expected the response to be cut off")
+			}
+		})
+	}
+}
+
+func TestEncodeRequestBodies(t *testing.T) {
+	large := bytes.Repeat([]byte("compress me "), 1000)
+	precompressed := gzipBytes(t, large)
+
+	tests := []struct {
+		name             string
+		method           string
+		body             []byte
+		header           http.Header
+		expectedEncoding string
+	}{
+		{"Large body", "POST", large, nil, "gzip"},
+		{"Large body with retries", "PUT", large, nil, "gzip"},
+		{"Small body", "POST", []byte("tiny"), nil, ""},
+		{"Already gzip", "POST", precompressed, http.Header{"Content-Encoding": {"gzip"}}, "gzip"},
+		{"Compressed media type", "POST", large, http.Header{"Content-Type": {"image/png"}}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var encoding string
+			var received []byte
+			backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				encoding = r.Header.Get("Content-Encoding")
+				var body io.Reader = r.Body
+				if encoding == "gzip" {
+					zr, err := gzip.NewReader(r.Body)
+					if err != nil {
+						t.Errorf("Expected a gzip body, got %v", err)
+						return
+					}
+					body = zr
+				}
+				received, _ = io.ReadAll(body)
+			}))
+			defer backendServer.Close()
+
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.EncodeRequestBodies = true
+			proxy.MaxRetries = 1
+
+			req := httptest.NewRequest(tt.method, backendServer.URL, bytes.NewReader(tt.body))
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if encoding != tt.expectedEncoding {
+				t.Errorf("Expected Content-Encoding %q, got %q", tt.expectedEncoding, encoding)
+			}
+			expected := tt.body
+			if tt.header.Get("Content-Encoding") == "gzip" {
+				expected = large
+			}
+			if !bytes.Equal(received, expected) {
+				t.Errorf("Expected upstream to decode %d bytes, got %d", len(expected), len(received))
+			}
+		})
+	}
+}
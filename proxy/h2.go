@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// normalizeProxyRequest turns an HTTP/2 or HTTP/3 proxy request into
+// absolute form. Neither has an absolute-form target; a proxied request
+// instead carries the destination in :authority with the credentials
+// alongside.
+func normalizeProxyRequest(r *http.Request) {
+	if r.ProtoMajor < 2 || r.Method == http.MethodConnect || r.URL.Host != "" {
+		return
+	}
+	if r.Header.Get("Proxy-Authorization") == "" || r.Host == "" {
+		return
+	}
+
+	// The HTTP/2 server only records TLS state for the https scheme. HTTP/3
+	// always runs over TLS and drops the scheme, so those requests are
+	// forwarded as plain HTTP.
+	r.URL.Scheme = "http"
+	if r.ProtoMajor == 2 && r.TLS != nil {
+		r.URL.Scheme = "https"
+	}
+	r.URL.Host = r.Host
+}
+
+// streamConn adapts an HTTP/2 CONNECT stream to a net.Conn. Reads come from
+// the request body and writes are flushed to the response as DATA frames.
+type streamConn struct {
+	body   io.ReadCloser
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	remote net.Addr
+
+	closeOnce sync.Once
+}
+
+// newStreamConn wraps the stream of an HTTP/2 CONNECT request
+func newStreamConn(w http.ResponseWriter, r *http.Request) *streamConn {
+	remote, _ := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	return &streamConn{
+		body:   r.Body,
+		w:      w,
+		rc:     http.NewResponseController(w),
+		remote: remote,
+	}
+}
+
+func (sc *streamConn) Read(b []byte) (int, error) {
+	return sc.body.Read(b)
+}
+
+func (sc *streamConn) Write(b []byte) (int, error) {
+	n, err := sc.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, sc.rc.Flush()
+}
+
+// Close ends the client side of the stream. The handler must still return
+// for the stream to be closed by the server.
+func (sc *streamConn) Close() error {
+	var err error
+	sc.closeOnce.Do(func() {
+		err = sc.body.Close()
+	})
+	return err
+}
+
+func (sc *streamConn) LocalAddr() net.Addr  { return nil }
+func (sc *streamConn) RemoteAddr() net.Addr { return sc.remote }
+
+func (sc *streamConn) SetDeadline(t time.Time) error {
+	if err := sc.rc.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return sc.rc.SetWriteDeadline(t)
+}
+
+func (sc *streamConn) SetReadDeadline(t time.Time) error  { return sc.rc.SetReadDeadline(t) }
+func (sc *streamConn) SetWriteDeadline(t time.Time) error { return sc.rc.SetWriteDeadline(t) }
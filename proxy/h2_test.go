@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// startH2Proxy starts a TLS proxy and returns an HTTP/2 client transport
+// whose connections all go to the proxy regardless of the request URL
+func startH2Proxy(t *testing.T, proxy *Server) *http2.Transport {
+	t.Helper()
+	certFile, keyFile, cert := writeTestCertificate(t)
+	go proxy.StartTLS(certFile, keyFile)
+	t.Cleanup(func() { proxy.Shutdown(context.Background()) })
+	proxyAddr := "127.0.0.1:" + proxy.port
+	waitForListener(t, proxyAddr)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			conn, err := tls.Dial(network, proxyAddr, &tls.Config{RootCAs: pool, NextProtos: []string{"h2"}})
+			if err != nil {
+				return nil, err
+			}
+			if proto := conn.ConnectionState().NegotiatedProtocol; proto != "h2" {
+				conn.Close()
+				return nil, fmt.Errorf("Expected ALPN h2, got %q", proto)
+			}
+			return conn, nil
+		},
+	}
+}
+
+func TestHTTP2_Get(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Backend over HTTP/2 proxy"))
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", freePort(t))
+	transport := startH2Proxy(t, proxy)
+
+	tests := []struct {
+		name     string
+		password string
+		expected int
+	}{
+		{"Valid credentials", "password123", http.StatusOK},
+		{"Invalid credentials", "wrong", http.StatusProxyAuthRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", backendServer.URL+"/", nil)
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", tt.password))
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("HTTP/2 request through proxy failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.ProtoMajor != 2 {
+				t.Errorf("Expected HTTP/2, got %s", resp.Proto)
+			}
+			if resp.StatusCode != tt.expected {
+				t.Fatalf("Expected status %d, got %d", tt.expected, resp.StatusCode)
+			}
+			if tt.expected == http.StatusOK {
+				body, _ := io.ReadAll(resp.Body)
+				if string(body) != "Backend over HTTP/2 proxy" {
+					t.Errorf("Unexpected body %q", body)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTP2_Connect(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	proxy := NewProxyServer("admin", "password123", freePort(t))
+	allowConnectPort(t, proxy, echoAddr)
+	transport := startH2Proxy(t, proxy)
+
+	clientSide, requestBody := io.Pipe()
+	defer requestBody.Close()
+	req, _ := http.NewRequest("CONNECT", "https://"+echoAddr, clientSide)
+	req.Host = echoAddr
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("HTTP/2 CONNECT failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	for _, line := range []string{"first\n", "second\n"} {
+		fmt.Fprint(requestBody, line)
+		done := make(chan string, 1)
+		go func() {
+			echoed, _ := reader.ReadString('\n')
+			done <- echoed
+		}()
+		select {
+		case echoed := <-done:
+			if echoed != line {
+				t.Errorf("Expected echo %q, got %q", line, echoed)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for echo over HTTP/2 tunnel")
+		}
+	}
+}
+
+func TestHTTP2_Disabled(t *testing.T) {
+	certFile, keyFile, _ := writeTestCertificate(t)
+	proxy := NewProxyServer("admin", "password123", freePort(t))
+	proxy.DisableHTTP2 = true
+	go proxy.StartTLS(certFile, keyFile)
+	defer proxy.Shutdown(context.Background())
+	waitForListener(t, "127.0.0.1:"+proxy.port)
+
+	conn, err := tls.Dial("tcp", "127.0.0.1:"+proxy.port, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2", "http/1.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if proto := conn.ConnectionState().NegotiatedProtocol; proto == "h2" {
+		t.Error("Expected h2 not to be offered when disabled")
+	}
+}
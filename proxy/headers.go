@@ -0,0 +1,15 @@
+package proxy
+
+import "net/http"
+
+// overrideHeaders sets each header in overrides on h, replacing any existing
+// values. Headers with an empty value are removed.
+func overrideHeaders(h http.Header, overrides map[string]string) {
+	for name, value := range overrides {
+		if value == "" {
+			h.Del(name)
+			continue
+		}
+		h.Set(name, value)
+	}
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHeaderOverrides(t *testing.T) {
+	var upstream http.Header
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header.Clone()
+		w.Header().Set("Server", "backend/1.0")
+		w.Header().Set("X-Powered-By", "php")
+		w.Header().Set("X-Backend", "kept")
+		w.Write([]byte("OK"))
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.RequestHeaders = map[string]string{
+		"X-Proxy-Source": "edge-1",
+		"User-Agent":     "proxy-agent",
+		"Cookie":         "",
+	}
+	proxy.ResponseHeaders = map[string]string{
+		"X-Served-By":  "edge-1",
+		"Server":       "proxy",
+		"X-Powered-By": "",
+	}
+
+	req := httptest.NewRequest("GET", backendServer.URL, nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	req.Header.Set("User-Agent", "client-agent")
+	req.Header.Set("Cookie", "session=abc")
+	req.Header.Set("Accept", "text/plain")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	requestTests := []struct {
+		name     string
+		expected string
+	}{
+		{"X-Proxy-Source", "edge-1"},
+		{"User-Agent", "proxy-agent"},
+		{"Cookie", ""},
+		{"Accept", "text/plain"},
+	}
+	for _, tt := range requestTests {
+		if result := strings.Join(upstream.Values(tt.name), ", "); result != tt.expected {
+			t.Errorf("Expected request header %s %q, got %q", tt.name, tt.expected, result)
+		}
+	}
+
+	responseTests := []struct {
+		name     string
+		expected string
+	}{
+		{"X-Served-By", "edge-1"},
+		{"Server", "proxy"},
+		{"X-Powered-By", ""},
+		{"X-Backend", "kept"},
+	}
+	for _, tt := range responseTests {
+		if result := strings.Join(w.Header().Values(tt.name), ", "); result != tt.expected {
+			t.Errorf("Expected response header %s %q, got %q", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestResponseModifier(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Set-Cookie", "session=abc")
+		w.Write([]byte("<html></html>"))
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.ResponseModifier = func(resp *http.Response) error {
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+			resp.Header.Del("Set-Cookie")
+			resp.Header.Set("Access-Control-Allow-Origin", "*")
+		}
+		return nil
+	}
+
+	req := httptest.NewRequest("GET", backendServer.URL, nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if got := w.Header().Get("Set-Cookie"); got != "" {
+		t.Errorf("Expected Set-Cookie to be removed, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Expected injected CORS header, got %q", got)
+	}
+	if w.Body.String() != "<html></html>" {
+		t.Errorf("Expected body to be relayed, got %q", w.Body.String())
+	}
+}
+
+func TestResponseModifier_Error(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.ResponseModifier = func(resp *http.Response) error {
+		return errors.New("refusing response")
+	}
+
+	req := httptest.NewRequest("GET", backendServer.URL, nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Expected status %d, got %d", http.StatusBadGateway, w.Code)
+	}
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("Expected the upstream body to be withheld, got %q", w.Body.String())
+	}
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"net/http"
+	"sort"
+)
+
+// headerSize returns the number of bytes h occupies on the wire
+func headerSize(h http.Header) int {
+	size := 0
+	for name, values := range h {
+		for _, value := range values {
+			size += len(name) + len(": ") + len(value) + len("\r\n")
+		}
+	}
+	return size
+}
+
+// harmonizeHeaderSize drops trimmable headers, largest first, until h fits
+// within max bytes. Other headers are essential and never removed. It
+// returns false if h still does not fit once every trimmable header is gone.
+func harmonizeHeaderSize(h http.Header, max int, trimmable []string) bool {
+	size := headerSize(h)
+	if size <= max {
+		return true
+	}
+
+	type candidate struct {
+		name string
+		size int
+	}
+	var candidates []candidate
+	for _, name := range trimmable {
+		name = http.CanonicalHeaderKey(name)
+		if values, ok := h[name]; ok {
+			candidates = append(candidates, candidate{name, headerSize(http.Header{name: values})})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].size > candidates[j].size
+	})
+
+	for _, c := range candidates {
+		if size <= max {
+			break
+		}
+		h.Del(c.name)
+		size -= c.size
+	}
+	return size <= max
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestOutboundHeaderSize(t *testing.T) {
+	// Create a test server that echoes whether it received a cookie
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Echo-Cookie-Length", strconv.Itoa(len(r.Header.Get("Cookie"))))
+		w.Header().Set("Echo-Custom", r.Header.Get("X-Custom"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer targetServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.MaxOutboundHeaderBytes = 1024
+
+	// A bloated cookie is dropped so the request fits
+	t.Run("Trim eligible header", func(t *testing.T) {
+		req := httptest.NewRequest("GET", targetServer.URL, nil)
+		req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+		req.Header.Set("Cookie", "session="+strings.Repeat("a", 4000))
+		req.Header.Set("X-Custom", "kept")
+		w := httptest.NewRecorder()
+
+		proxy.handleHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		if w.Header().Get("Echo-Cookie-Length") != "0" {
+			t.Error("Oversized cookie should have been trimmed")
+		}
+		if w.Header().Get("Echo-Custom") != "kept" {
+			t.Error("Essential header should be kept")
+		}
+	})
+
+	// Essential headers alone exceed the limit
+	t.Run("Reject when essential headers exceed limit", func(t *testing.T) {
+		req := httptest.NewRequest("GET", targetServer.URL, nil)
+		req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+		req.Header.Set("X-Custom", strings.Repeat("b", 2000))
+		w := httptest.NewRecorder()
+
+		proxy.handleHTTP(w, req)
+
+		if w.Code != http.StatusRequestHeaderFieldsTooLarge {
+			t.Errorf("Expected status %d, got %d", http.StatusRequestHeaderFieldsTooLarge, w.Code)
+		}
+	})
+}
+
+func TestHarmonizeHeaderSize(t *testing.T) {
+	h := http.Header{}
+	h.Set("Cookie", strings.Repeat("c", 300))
+	h.Set("X-Tracking", strings.Repeat("t", 100))
+	h.Set("Accept", "text/html")
+
+	// Only the largest trimmable header needs to go
+	if !harmonizeHeaderSize(h, 200, []string{"x-tracking", "cookie"}) {
+		t.Fatal("Headers should fit after trimming")
+	}
+	if h.Get("Cookie") != "" {
+		t.Error("Largest trimmable header should be removed first")
+	}
+	if h.Get("X-Tracking") == "" {
+		t.Error("Smaller trimmable header should be kept when the rest fits")
+	}
+	if h.Get("Accept") == "" {
+		t.Error("Essential header should never be removed")
+	}
+}
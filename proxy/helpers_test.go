@@ -1,19 +1,34 @@
-package main
+package proxy
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
 // TestHelper provides utilities for testing the proxy server
 type TestHelper struct {
-	ProxyServer   *ProxyServer
+	Server        *Server
 	BackendServer *httptest.Server
 	ProxyHandler  http.Handler
 }
@@ -30,7 +45,7 @@ func NewTestHelper(username, password string) *TestHelper {
 	proxy := NewProxyServer(username, password, "8080")
 
 	return &TestHelper{
-		ProxyServer:   proxy,
+		Server:        proxy,
 		BackendServer: backendServer,
 		ProxyHandler:  proxy,
 	}
@@ -57,7 +72,7 @@ func (th *TestHelper) CreateUnauthenticatedRequest(method, url string) *http.Req
 
 // GetBasicAuth returns the basic auth header value for the proxy
 func (th *TestHelper) GetBasicAuth() string {
-	return CreateBasicAuth(th.ProxyServer.username, th.ProxyServer.password)
+	return CreateBasicAuth(th.Server.username, th.Server.password)
 }
 
 // CreateBasicAuth creates a basic auth header value
@@ -65,17 +80,207 @@ func CreateBasicAuth(username, password string) string {
 	return "Basic " + base64Encode(username+":"+password)
 }
 
+// authRequest creates a proxied GET request carrying the given credentials
+func authRequest(username, password string) *http.Request {
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth(username, password))
+	return req
+}
+
+// allowConnectPort permits CONNECT tunnels to the port of addr, which is
+// usually a randomly assigned test server port
+func allowConnectPort(t *testing.T, ps *Server, addr string) {
+	t.Helper()
+	_, port, err := connectTarget(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.AllowedConnectPorts = append(ps.AllowedConnectPorts, port)
+}
+
 // base64Encode encodes a string to base64
 func base64Encode(s string) string {
 	return base64.StdEncoding.EncodeToString([]byte(s))
 }
 
+// freePort returns a TCP port that is currently free on the loopback interface
+func freePort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port
+}
+
+// waitForListener blocks until addr accepts TCP connections
+func waitForListener(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Listener %s did not come up", addr)
+}
+
+// waitForAddr waits until ps is listening and returns its bound address
+func waitForAddr(t *testing.T, ps *Server) net.Addr {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if addr := ps.Addr(); addr != nil {
+			return addr
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Proxy did not start listening")
+	return nil
+}
+
+// startEchoServer starts a TCP server that echoes back everything it
+// receives and returns its address
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// openTunnel sends an authenticated CONNECT for target through the proxy at
+// proxyAddr and returns the connection once the proxy answers
+func openTunnel(t *testing.T, proxyAddr, target string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\nProxy-Authorization: %s\r\n\r\n",
+		target, target, CreateBasicAuth("admin", "password123"))
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, reader, resp
+}
+
+// logBuffer is a bytes.Buffer safe for concurrent logging and reading
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (lb *logBuffer) Write(p []byte) (int, error) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.buf.Write(p)
+}
+
+func (lb *logBuffer) String() string {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.buf.String()
+}
+
+// captureLog redirects the standard logger for the duration of the test
+func captureLog(t *testing.T) *logBuffer {
+	t.Helper()
+	logs := &logBuffer{}
+	previous := log.Writer()
+	log.SetOutput(logs)
+	t.Cleanup(func() { log.SetOutput(previous) })
+	return logs
+}
+
+// waitForLog waits until the captured log contains substr
+func waitForLog(t *testing.T, logs *logBuffer, substr string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if strings.Contains(logs.String(), substr) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Expected log to contain %q, got %q", substr, logs.String())
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and
+// returns the paths of the PEM cert and key files together with the cert
+func writeTestCertificate(t *testing.T) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
 func TestTestHelper(t *testing.T) {
 	helper := NewTestHelper("testuser", "testpass")
 	defer helper.Close()
 
-	if helper.ProxyServer == nil {
-		t.Error("ProxyServer should not be nil")
+	if helper.Server == nil {
+		t.Error("Server should not be nil")
 	}
 
 	if helper.BackendServer == nil {
@@ -102,8 +307,13 @@ func TestTestHelper(t *testing.T) {
 func TestServerTimeout(t *testing.T) {
 	// Create a slow backend server
 	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Sleep longer than the proxy timeout (30 seconds)
-		time.Sleep(35 * time.Second)
+		// Take longer than the proxy timeout (30 seconds) unless the proxy
+		// gives up on the request first
+		select {
+		case <-time.After(35 * time.Second):
+		case <-r.Context().Done():
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Slow response"))
 	}))
@@ -207,6 +417,7 @@ func TestLargeRequestBody(t *testing.T) {
 	defer echoServer.Close()
 
 	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.MaxRequestBody = 2 * 1024 * 1024
 
 	// Create a large request body (1MB)
 	largeBody := make([]byte, 1024*1024)
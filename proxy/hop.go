@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// hopSecretHeader carries the shared secret from a proxy to its parent
+const hopSecretHeader = "X-Proxy-Hop-Secret"
+
+// hopProofHeader carries the parent's proof that it knows the shared secret
+// too, so both hops authenticate each other
+const hopProofHeader = "X-Proxy-Hop-Proof"
+
+// hopProof derives the proof a parent returns for a CONNECT to target
+func hopProof(secret, target string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(target))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hopAuthenticated reports whether r comes from a child proxy presenting
+// the shared HopSecret
+func (ps *Server) hopAuthenticated(r *http.Request) bool {
+	if ps.HopSecret == "" {
+		return false
+	}
+	secret := r.Header.Get(hopSecretHeader)
+	return secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(ps.HopSecret)) == 1
+}
+
+// parentAddr returns the host:port of the parent proxy
+func parentAddr(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// dialParent opens a tunnel to target through ParentProxy, presenting the
+// shared secret and checking the parent's proof of it
+func (ps *Server) dialParent(target string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: ps.DialTimeout, Resolver: ps.resolver()}
+	conn, err := dialer.Dial("tcp", parentAddr(ps.ParentProxy))
+	if err != nil {
+		return nil, err
+	}
+	if ps.ParentProxy.Scheme == "https" {
+		// Verify the parent like the upstream transport does
+		tlsConfig := ps.upstreamTransport().TLSClientConfig.Clone()
+		tlsConfig.ServerName = ps.ParentProxy.Hostname()
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	conn.SetDeadline(time.Now().Add(ps.DialTimeout))
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
+	}
+	ps.setHopHeaders(req.Header)
+	setParentAuth(req.Header, ps.ParentProxy)
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The body of a successful CONNECT response is the tunnel itself, so it
+	// is never read or closed here
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("parent proxy refused CONNECT to %s: %s", target, resp.Status)
+	}
+	if err := ps.checkHopProof(resp, target); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// setHopHeaders adds the shared secret to a request bound for the parent
+func (ps *Server) setHopHeaders(h http.Header) {
+	if ps.HopSecret != "" {
+		h.Set(hopSecretHeader, ps.HopSecret)
+	}
+}
+
+// setParentAuth sets Proxy-Authorization from the userinfo of the parent URL,
+// as the upstream transport does for the requests and CONNECTs it sends.
+// url.Parse has already decoded escapes such as %40 in the password.
+func setParentAuth(h http.Header, parent *url.URL) {
+	if parent.User == nil {
+		return
+	}
+	password, _ := parent.User.Password()
+	credentials := parent.User.Username() + ":" + password
+	h.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+}
+
+// checkHopProof verifies that the parent's CONNECT response proves it knows
+// the shared secret
+func (ps *Server) checkHopProof(resp *http.Response, target string) error {
+	if ps.HopSecret == "" {
+		return nil
+	}
+	if !hmac.Equal([]byte(resp.Header.Get(hopProofHeader)), []byte(hopProof(ps.HopSecret, target))) {
+		return fmt.Errorf("parent proxy failed hop authentication for %s", target)
+	}
+	return nil
+}
+
+// onParentConnectResponse checks the hop proof on CONNECTs made by the
+// upstream transport
+func (ps *Server) onParentConnectResponse(ctx context.Context, proxyURL *url.URL, connectReq *http.Request, connectRes *http.Response) error {
+	if connectRes.StatusCode != http.StatusOK {
+		return nil
+	}
+	return ps.checkHopProof(connectRes, connectReq.Host)
+}
+
+// bufferedConn is a net.Conn whose first reads drain a bufio.Reader
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (bc *bufferedConn) Read(b []byte) (int, error) {
+	return bc.reader.Read(b)
+}
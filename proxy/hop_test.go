@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// startProxyChain starts a parent proxy with parentSecret and a child proxy
+// chained through it with childSecret, returning the child's address
+func startProxyChain(t *testing.T, parentSecret, childSecret string, connectAddrs ...string) (child *Server, childAddr string) {
+	t.Helper()
+	parent := NewProxyServer("parent-only", "unguessable", "8080")
+	parent.HopSecret = parentSecret
+	parentServer := httptest.NewServer(parent)
+	t.Cleanup(parentServer.Close)
+
+	child = NewProxyServer("admin", "password123", "8080")
+	child.ParentProxy, _ = url.Parse(parentServer.URL)
+	child.HopSecret = childSecret
+	for _, addr := range connectAddrs {
+		allowConnectPort(t, parent, addr)
+		allowConnectPort(t, child, addr)
+	}
+	childServer := httptest.NewServer(child)
+	t.Cleanup(childServer.Close)
+
+	return child, childServer.Listener.Addr().String()
+}
+
+func TestProxyChain_Connect(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	tests := []struct {
+		name         string
+		childSecret  string
+		expectedCode int
+	}{
+		{"Shared secret", "hop-secret", http.StatusOK},
+		{"Wrong secret", "not-the-secret", http.StatusBadGateway},
+		{"Missing secret", "", http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, childAddr := startProxyChain(t, "hop-secret", tt.childSecret, echoAddr)
+
+			conn, reader, resp := openTunnel(t, childAddr, echoAddr)
+			if resp.StatusCode != tt.expectedCode {
+				t.Fatalf("Expected status %d, got %d", tt.expectedCode, resp.StatusCode)
+			}
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+
+			fmt.Fprint(conn, "across two hops\n")
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line != "across two hops\n" {
+				t.Errorf("Expected echo %q, got %q", "across two hops\n", line)
+			}
+		})
+	}
+}
+
+func TestProxyChain_HTTP(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(hopSecretHeader) != "" {
+			t.Error("Hop secret leaked to the backend")
+		}
+		w.Write([]byte("Backend behind two proxies"))
+	}))
+	defer backendServer.Close()
+
+	_, childAddr := startProxyChain(t, "hop-secret", "hop-secret")
+
+	proxyURL := &url.URL{Scheme: "http", User: url.UserPassword("admin", "password123"), Host: childAddr}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get(backendServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "Backend behind two proxies" {
+		t.Errorf("Unexpected body %q", body)
+	}
+}
+
+func TestProxyChain_ParentRejectsWithoutSecret(t *testing.T) {
+	parent := NewProxyServer("parent-only", "unguessable", "8080")
+	parent.HopSecret = "hop-secret"
+
+	tests := []struct {
+		name     string
+		secret   string
+		expected int
+	}{
+		{"Valid secret", "hop-secret", http.StatusOK},
+		{"Invalid secret", "guess", http.StatusProxyAuthRequired},
+		{"No secret", "", http.StatusProxyAuthRequired},
+	}
+
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backendServer.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", backendServer.URL, nil)
+			if tt.secret != "" {
+				req.Header.Set(hopSecretHeader, tt.secret)
+			}
+			w := httptest.NewRecorder()
+			parent.ServeHTTP(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestProxyChain_ParentCredentialsFromURL(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Backend behind an authenticating parent"))
+	}))
+	defer backendServer.Close()
+	echoAddr := startEchoServer(t)
+
+	parent := NewProxyServer("parent-user", "p@ss:w/rd", "8080")
+	allowConnectPort(t, parent, echoAddr)
+	parentServer := httptest.NewServer(parent)
+	defer parentServer.Close()
+
+	tests := []struct {
+		name         string
+		userinfo     string
+		expectedCode int
+	}{
+		{"Encoded credentials", "parent-user:p%40ss%3Aw%2Frd@", http.StatusOK},
+		{"Wrong password", "parent-user:wrong@", http.StatusBadGateway},
+		{"No credentials", "", http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			child := NewProxyServer("admin", "password123", "8080")
+			child.ParentProxy, _ = url.Parse("http://" + tt.userinfo + parentServer.Listener.Addr().String())
+			allowConnectPort(t, child, echoAddr)
+			childServer := httptest.NewServer(child)
+			defer childServer.Close()
+			childAddr := childServer.Listener.Addr().String()
+
+			conn, reader, resp := openTunnel(t, childAddr, echoAddr)
+			if resp.StatusCode != tt.expectedCode {
+				t.Fatalf("Expected CONNECT status %d, got %d", tt.expectedCode, resp.StatusCode)
+			}
+			if tt.expectedCode == http.StatusOK {
+				fmt.Fprint(conn, "through the parent\n")
+				if line, _ := reader.ReadString('\n'); line != "through the parent\n" {
+					t.Errorf("Expected echo %q, got %q", "through the parent\n", line)
+				}
+			}
+
+			proxyURL := &url.URL{Scheme: "http", User: url.UserPassword("admin", "password123"), Host: childAddr}
+			client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+			httpResp, err := client.Get(backendServer.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer httpResp.Body.Close()
+			if tt.expectedCode == http.StatusOK && httpResp.StatusCode != http.StatusOK {
+				t.Errorf("Expected HTTP status %d, got %d", http.StatusOK, httpResp.StatusCode)
+			}
+			if tt.expectedCode != http.StatusOK && httpResp.StatusCode != http.StatusProxyAuthRequired {
+				t.Errorf("Expected the parent's %d to be relayed, got %d", http.StatusProxyAuthRequired, httpResp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestProxyChain_ParentDial(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	tests := []struct {
+		name  string
+		start func(handler http.Handler) *httptest.Server
+		setup func(child *Server, parentURL *url.URL, parent *httptest.Server)
+	}{
+		{
+			name:  "HTTPS parent with private CA",
+			start: httptest.NewTLSServer,
+			setup: func(child *Server, parentURL *url.URL, parent *httptest.Server) {
+				child.RootCAs = x509.NewCertPool()
+				child.RootCAs.AddCert(parent.Certificate())
+			},
+		},
+		{
+			name:  "Parent name from custom resolver",
+			start: httptest.NewServer,
+			setup: func(child *Server, parentURL *url.URL, parent *httptest.Server) {
+				child.Resolver = startMockDNS(t, "parent.proxy-test")
+				parentURL.Host = net.JoinHostPort("parent.proxy-test", parentURL.Port())
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := NewProxyServer("parent-only", "unguessable", "8080")
+			parent.HopSecret = "hop-secret"
+			allowConnectPort(t, parent, echoAddr)
+			parentServer := tt.start(parent)
+			defer parentServer.Close()
+
+			child := NewProxyServer("admin", "password123", "8080")
+			child.ParentProxy, _ = url.Parse(parentServer.URL)
+			child.HopSecret = "hop-secret"
+			allowConnectPort(t, child, echoAddr)
+			tt.setup(child, child.ParentProxy, parentServer)
+			childServer := httptest.NewServer(child)
+			defer childServer.Close()
+
+			conn, reader, resp := openTunnel(t, childServer.Listener.Addr().String(), echoAddr)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+			}
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			fmt.Fprint(conn, "via parent\n")
+			if line, err := reader.ReadString('\n'); err != nil || line != "via parent\n" {
+				t.Errorf("Expected echo %q, got %q (%v)", "via parent\n", line, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// prepareHTTP10Response adapts a response for an HTTP/1.0 client, which does
+// not understand chunked encoding. Bodies of unknown length are buffered
+// within the request's memory budget so a Content-Length can be sent. Bodies
+// too large to buffer are streamed and delimited by closing the connection.
+// body is read in place of resp.Body so its size limit still applies.
+func prepareHTTP10Response(w http.ResponseWriter, r *http.Request, resp *http.Response, body io.Reader, budget *memoryBudget) (io.Reader, error) {
+	keepAlive := strings.EqualFold(r.Header.Get("Connection"), "keep-alive")
+
+	if resp.ContentLength >= 0 {
+		if !keepAlive {
+			w.Header().Set("Connection", "close")
+		}
+		return body, nil
+	}
+
+	buffered, err := bufferBody(body, budget)
+	if err != nil {
+		return nil, err
+	}
+	if !buffered.complete {
+		w.Header().Set("Connection", "close")
+		return buffered.Reader(), nil
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(buffered.data)))
+	if !keepAlive {
+		w.Header().Set("Connection", "close")
+	}
+	return buffered.Reader(), nil
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTP10Client(t *testing.T) {
+	// A backend that streams its response without a Content-Length
+	chunk := strings.Repeat("x", 4096)
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		for i := 0; i < 3; i++ {
+			io.WriteString(w, chunk)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer backendServer.Close()
+
+	tests := []struct {
+		name           string
+		maxMemory      int64
+		keepAlive      bool
+		expectedLength string
+	}{
+		{"Buffered response gets Content-Length", 1 << 20, false, "12288"},
+		{"Keep-alive client keeps the connection", 1 << 20, true, "12288"},
+		{"Response over budget is streamed", 1024, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.MaxRequestMemory = tt.maxMemory
+			proxyServer := httptest.NewServer(proxy)
+			defer proxyServer.Close()
+
+			conn, err := net.Dial("tcp", proxyServer.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			request := fmt.Sprintf("GET %s/ HTTP/1.0\r\nProxy-Authorization: %s\r\n",
+				backendServer.URL, CreateBasicAuth("admin", "password123"))
+			if tt.keepAlive {
+				request += "Connection: keep-alive\r\n"
+			}
+			fmt.Fprint(conn, request+"\r\n")
+
+			reader := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(reader, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if len(resp.TransferEncoding) != 0 {
+				t.Errorf("HTTP/1.0 response must not be chunked, got %v", resp.TransferEncoding)
+			}
+			if got := resp.Header.Get("Content-Length"); got != tt.expectedLength {
+				t.Errorf("Expected Content-Length %q, got %q", tt.expectedLength, got)
+			}
+			if string(body) != strings.Repeat(chunk, 3) {
+				t.Errorf("Unexpected body of %d bytes", len(body))
+			}
+
+			closed := strings.EqualFold(resp.Header.Get("Connection"), "close")
+			if closed == tt.keepAlive {
+				t.Errorf("Expected Connection close=%v, got header %q", !tt.keepAlive, resp.Header.Get("Connection"))
+			}
+			if !tt.keepAlive {
+				// The server should close the connection after the response
+				if _, err := reader.ReadByte(); err != io.EOF {
+					t.Errorf("Expected connection to be closed, got %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+//go:build http3
+
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// http3AltSvcMaxAge is how many seconds clients may remember the HTTP/3
+// listener advertised in Alt-Svc
+const http3AltSvcMaxAge = 24 * 60 * 60
+
+// StartHTTP3 starts an HTTP/3 listener on the UDP side of the proxy port
+// and advertises it with Alt-Svc on the proxy's own responses. It can run
+// alongside Start or StartTLS. CONNECT tunnels are not supported over
+// HTTP/3.
+func (ps *Server) StartHTTP3(certFile, keyFile string) error {
+	if err := ps.Validate(); err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	tlsConfig := ps.TLSConfig.Clone()
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+
+	conn, err := net.ListenPacket("udp", net.JoinHostPort(ps.BindAddress, ps.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	server := &http3.Server{
+		Handler:   ps,
+		TLSConfig: tlsConfig,
+		// 0-RTT requests can be replayed, which a proxy must not allow
+		QUICConfig:     &quic.Config{},
+		MaxHeaderBytes: ps.MaxHeaderBytes,
+	}
+	_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+
+	ps.mu.Lock()
+	if ps.shutdown {
+		ps.mu.Unlock()
+		return net.ErrClosed
+	}
+	ps.http3Server = server
+	ps.altSvc = fmt.Sprintf("h3=\":%s\"; ma=%d", port, http3AltSvcMaxAge)
+	ps.mu.Unlock()
+
+	log.Printf("Starting HTTP/3 Proxy Server on port %s", port)
+	return server.Serve(conn)
+}
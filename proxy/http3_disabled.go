@@ -0,0 +1,14 @@
+//go:build !http3
+
+package proxy
+
+import "errors"
+
+// errHTTP3Unsupported is returned by StartHTTP3 in builds without HTTP/3
+var errHTTP3Unsupported = errors.New("HTTP/3 support requires building with -tags http3")
+
+// StartHTTP3 serves HTTP/3 when built with the http3 tag and otherwise
+// returns an error.
+func (ps *Server) StartHTTP3(certFile, keyFile string) error {
+	return errHTTP3Unsupported
+}
@@ -0,0 +1,99 @@
+//go:build http3
+
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// startHTTP3Proxy runs the TCP and HTTP/3 listeners of a proxy on a free
+// port and returns a client trusting its certificate
+func startHTTP3Proxy(t *testing.T) (*Server, *http.Client) {
+	t.Helper()
+	certFile, keyFile, cert := writeTestCertificate(t)
+
+	port := freePort(t)
+	proxy := New(WithPort(port), WithCredentials("admin", "password123"))
+	go proxy.Start()
+	go proxy.StartHTTP3(certFile, keyFile)
+	t.Cleanup(func() { proxy.Shutdown(context.Background()) })
+	waitForListener(t, "127.0.0.1:"+port)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		proxy.mu.Lock()
+		started := proxy.http3Server != nil
+		proxy.mu.Unlock()
+		if started {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("HTTP/3 listener did not come up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	transport := &http3.RoundTripper{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	t.Cleanup(func() { transport.Close() })
+	return proxy, &http.Client{Timeout: 5 * time.Second, Transport: transport}
+}
+
+func TestStartHTTP3_ProxiesGet(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello over h3"))
+	}))
+	defer backendServer.Close()
+	proxy, client := startHTTP3Proxy(t)
+
+	// Like HTTP/2, HTTP/3 sends the destination in :authority
+	req, _ := http.NewRequest(http.MethodGet, "https://127.0.0.1:"+proxy.Port()+"/", nil)
+	req.Host = strings.TrimPrefix(backendServer.URL, "http://")
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Request over HTTP/3 failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.ProtoMajor != 3 {
+		t.Errorf("Expected HTTP/3 response, got %s", resp.Proto)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if string(body) != "hello over h3" {
+		t.Errorf("Expected backend body, got %q", body)
+	}
+	if altSvc := resp.Header.Get("Alt-Svc"); altSvc != "" {
+		t.Errorf("Expected no Alt-Svc on proxied response, got %q", altSvc)
+	}
+}
+
+func TestStartHTTP3_AdvertisesAltSvc(t *testing.T) {
+	proxy, _ := startHTTP3Proxy(t)
+
+	resp, err := http.Get("http://127.0.0.1:" + proxy.Port() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	expected := `h3=":` + proxy.Port() + `"; ma=86400`
+	if altSvc := resp.Header.Get("Alt-Svc"); altSvc != expected {
+		t.Errorf("Expected Alt-Svc %q, got %q", expected, altSvc)
+	}
+}
@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"net/http"
+	"net/textproto"
+)
+
+// relayInformational returns a Got1xxResponse hook passing interim
+// responses such as 103 Early Hints from the upstream on to the client
+// while the transport keeps reading for the final response. 100 Continue
+// is left out because the client-facing server sends its own once the
+// request body is read, and HTTP/1.0 clients get no interim responses.
+func relayInformational(w http.ResponseWriter, r *http.Request) func(int, textproto.MIMEHeader) error {
+	return func(code int, header textproto.MIMEHeader) error {
+		if code == http.StatusContinue || !r.ProtoAtLeast(1, 1) {
+			return nil
+		}
+
+		// The 1xx is sent with whatever w.Header holds, so swap in the
+		// upstream's headers and restore the final response's afterwards
+		h := w.Header()
+		saved := h.Clone()
+		for name := range h {
+			delete(h, name)
+		}
+		for name, values := range header {
+			h[name] = append([]string(nil), values...)
+		}
+		for _, name := range hopByHopHeaders {
+			h.Del(name)
+		}
+		w.WriteHeader(code)
+
+		for name := range h {
+			delete(h, name)
+		}
+		for name, values := range saved {
+			h[name] = values
+		}
+		return nil
+	}
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// earlyHintsBackend sends 103 Early Hints with a Link header before its 200
+func earlyHintsBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Del("Link")
+		w.Header().Set("X-Final", "yes")
+		w.Write([]byte("final body"))
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestForward_RelaysEarlyHints(t *testing.T) {
+	backend := earlyHintsBackend(t)
+	proxy := NewProxyServer("admin", "password123", "8080")
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+	logs := captureLog(t)
+
+	var codes []int
+	var links []string
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			codes = append(codes, code)
+			links = append(links, header.Get("Link"))
+			return nil
+		},
+	}
+	req, _ := http.NewRequest("GET", backend.URL, nil)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	proxyURL := &url.URL{Scheme: "http", User: url.UserPassword("admin", "password123"), Host: server.Listener.Addr().String()}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if len(codes) != 1 || codes[0] != http.StatusEarlyHints {
+		t.Fatalf("Expected one 103 response, got %v", codes)
+	}
+	if links[0] != "</style.css>; rel=preload; as=style" {
+		t.Errorf("Expected the Link header on the 103, got %q", links[0])
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "final body" {
+		t.Errorf("Expected final 200 with body, got %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("X-Final") != "yes" {
+		t.Errorf("Expected final headers, got %v", resp.Header)
+	}
+	if resp.Header.Get("Link") != "" {
+		t.Errorf("Expected the 103 headers to stay off the final response, got Link %q", resp.Header.Get("Link"))
+	}
+	waitForLog(t, logs, backend.URL+"/ 200 ")
+}
+
+func TestForward_NoEarlyHintsForHTTP10(t *testing.T) {
+	backend := earlyHintsBackend(t)
+	proxy := NewProxyServer("admin", "password123", "8080")
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET %s/ HTTP/1.0\r\nProxy-Authorization: %s\r\n\r\n", backend.URL, CreateBasicAuth("admin", "password123"))
+
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(status, " 200 ") {
+		t.Errorf("Expected the HTTP/1.0 client to get the final status first, got %q", status)
+	}
+}
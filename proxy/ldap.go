@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// defaultLDAPCacheTTL is how long a successful LDAP bind is remembered
+const defaultLDAPCacheTTL = time.Minute
+
+// maxLDAPCacheEntries bounds the cache of successful LDAP binds
+const maxLDAPCacheEntries = 1024
+
+// LDAPAuthenticator validates Basic proxy credentials by binding to an LDAP
+// directory as the user. Successful binds are cached for CacheTTL so the
+// directory is not contacted on every request.
+type LDAPAuthenticator struct {
+	// URL is the directory address, such as ldap://ldap.example.com or
+	// ldaps://ldap.example.com:636
+	URL string
+	// BaseDN replaces {base} in BindTemplate
+	BaseDN string
+	// BindTemplate is the DN bound as, with {user} replaced by the escaped
+	// username, such as "uid={user},ou=people,{base}"
+	BindTemplate string
+	// Timeout bounds connecting to and binding with the directory
+	Timeout time.Duration
+	// CacheTTL is how long a successful bind is cached. Zero disables the
+	// cache.
+	CacheTTL time.Duration
+	// TLSConfig is used for ldaps:// URLs
+	TLSConfig *tls.Config
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]time.Time
+	now   func() time.Time
+}
+
+// NewLDAPAuthenticator creates an authenticator for the directory at url
+func NewLDAPAuthenticator(url, baseDN, bindTemplate string) *LDAPAuthenticator {
+	return &LDAPAuthenticator{
+		URL:          url,
+		BaseDN:       baseDN,
+		BindTemplate: bindTemplate,
+		Timeout:      defaultTimeout,
+		CacheTTL:     defaultLDAPCacheTTL,
+		now:          time.Now,
+	}
+}
+
+// Authenticate implements Authenticator. Unreachable directories are logged
+// and treated as failed logins.
+func (la *LDAPAuthenticator) Authenticate(r *http.Request) (string, bool) {
+	username, password, ok := proxyBasicAuth(r)
+	// An empty password would be an unauthenticated bind, which directories
+	// accept for any DN
+	if !ok || username == "" || password == "" {
+		return "", false
+	}
+
+	key := sha256.Sum256([]byte(username + "\x00" + password))
+	if la.cached(key) {
+		return username, true
+	}
+	if !la.bind(username, password) {
+		return "", false
+	}
+	la.remember(key)
+	return username, true
+}
+
+// bindDN returns the DN that username binds as
+func (la *LDAPAuthenticator) bindDN(username string) string {
+	return strings.NewReplacer("{user}", ldap.EscapeDN(username), "{base}", la.BaseDN).Replace(la.BindTemplate)
+}
+
+// bind reports whether the directory accepts password for username
+func (la *LDAPAuthenticator) bind(username, password string) bool {
+	conn, err := ldap.DialURL(la.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: la.Timeout}),
+		ldap.DialWithTLSConfig(la.TLSConfig))
+	if err != nil {
+		log.Printf("Error connecting to LDAP server %s: %v", la.URL, err)
+		return false
+	}
+	defer conn.Close()
+	conn.SetTimeout(la.Timeout)
+
+	err = conn.Bind(la.bindDN(username), password)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		log.Printf("Error binding to LDAP server %s as %q: %v", la.URL, username, err)
+	}
+	return err == nil
+}
+
+func (la *LDAPAuthenticator) cached(key [sha256.Size]byte) bool {
+	la.mu.Lock()
+	defer la.mu.Unlock()
+	expires, ok := la.cache[key]
+	return ok && la.now().Before(expires)
+}
+
+func (la *LDAPAuthenticator) remember(key [sha256.Size]byte) {
+	if la.CacheTTL <= 0 {
+		return
+	}
+	la.mu.Lock()
+	defer la.mu.Unlock()
+	if la.cache == nil || len(la.cache) >= maxLDAPCacheEntries {
+		la.cache = make(map[[sha256.Size]byte]time.Time)
+	}
+	la.cache[key] = la.now().Add(la.CacheTTL)
+}
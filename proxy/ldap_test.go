@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// startMockLDAP starts a directory that accepts simple binds for the given
+// DNs and passwords, counting every bind it receives
+func startMockLDAP(t *testing.T, passwords map[string]string) (url string, binds *atomic.Int32) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	binds = &atomic.Int32{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					packet, err := ber.ReadPacket(conn)
+					if err != nil || len(packet.Children) < 2 {
+						return
+					}
+					op := packet.Children[1]
+					// Only bind requests are answered
+					if op.Tag != 0 || len(op.Children) < 3 {
+						return
+					}
+					binds.Add(1)
+
+					dn, _ := op.Children[1].Value.(string)
+					code := int64(49) // invalidCredentials
+					if want, ok := passwords[dn]; ok && want == op.Children[2].Data.String() {
+						code = 0
+					}
+
+					resp := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+					resp.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, packet.Children[0].Value, "MessageID"))
+					bindResp := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 1, nil, "Bind Response")
+					bindResp.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "resultCode"))
+					bindResp.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+					bindResp.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+					resp.AppendChild(bindResp)
+					if _, err := conn.Write(resp.Bytes()); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return "ldap://" + ln.Addr().String(), binds
+}
+
+func TestLDAPAuthenticator(t *testing.T) {
+	url, binds := startMockLDAP(t, map[string]string{
+		"uid=alice,ou=people,dc=example,dc=com": "wonderland",
+		`uid=a\,b,ou=people,dc=example,dc=com`:  "escaped",
+	})
+	auth := NewLDAPAuthenticator(url, "dc=example,dc=com", "uid={user},ou=people,{base}")
+	auth.CacheTTL = 0
+
+	tests := []struct {
+		name          string
+		username      string
+		password      string
+		expected      bool
+		expectedBinds int32
+	}{
+		{"Valid credentials", "alice", "wonderland", true, 1},
+		{"Wrong password", "alice", "looking-glass", false, 1},
+		{"Unknown user", "mallory", "wonderland", false, 1},
+		{"Escaped username", "a,b", "escaped", true, 1},
+		// Never sent, as an empty password would be an anonymous bind
+		{"Empty password", "alice", "", false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := binds.Load()
+			user, ok := auth.Authenticate(authRequest(tt.username, tt.password))
+			if ok != tt.expected {
+				t.Errorf("Expected authenticated=%v, got %v", tt.expected, ok)
+			}
+			if ok && user != tt.username {
+				t.Errorf("Expected user %q, got %q", tt.username, user)
+			}
+			if got := binds.Load() - before; got != tt.expectedBinds {
+				t.Errorf("Expected %d binds, got %d", tt.expectedBinds, got)
+			}
+		})
+	}
+}
+
+func TestLDAPAuthenticator_Cache(t *testing.T) {
+	url, binds := startMockLDAP(t, map[string]string{"uid=alice,dc=example,dc=com": "wonderland"})
+	auth := NewLDAPAuthenticator(url, "dc=example,dc=com", "uid={user},{base}")
+	now := time.Now()
+	auth.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, ok := auth.Authenticate(authRequest("alice", "wonderland")); !ok {
+			t.Fatal("Expected valid credentials to be accepted")
+		}
+	}
+	if got := binds.Load(); got != 1 {
+		t.Errorf("Expected 1 bind while cached, got %d", got)
+	}
+
+	// Failures are never cached
+	auth.Authenticate(authRequest("alice", "wrong"))
+	auth.Authenticate(authRequest("alice", "wrong"))
+	if got := binds.Load(); got != 3 {
+		t.Errorf("Expected failed logins to bind every time, got %d binds", got)
+	}
+
+	now = now.Add(defaultLDAPCacheTTL + time.Second)
+	if _, ok := auth.Authenticate(authRequest("alice", "wonderland")); !ok {
+		t.Fatal("Expected valid credentials to be accepted")
+	}
+	if got := binds.Load(); got != 4 {
+		t.Errorf("Expected the cache entry to expire, got %d binds", got)
+	}
+}
+
+func TestLDAPAuthenticator_Unreachable(t *testing.T) {
+	logs := captureLog(t)
+	auth := NewLDAPAuthenticator("ldap://127.0.0.1:"+freePort(t), "dc=example,dc=com", "uid={user},{base}")
+	auth.Timeout = time.Second
+
+	if _, ok := auth.Authenticate(authRequest("alice", "wonderland")); ok {
+		t.Error("Expected authentication to fail when the directory is unreachable")
+	}
+	waitForLog(t, logs, "Error connecting to LDAP server")
+}
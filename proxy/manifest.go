@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Version is the release version reported in the manifest. The command sets
+// it from its own build-time version.
+var Version = "dev"
+
+// Manifest describes a running proxy for deployment tooling
+type Manifest struct {
+	Version   string            `json:"version"`
+	PID       int               `json:"pid"`
+	StartedAt time.Time         `json:"started_at"`
+	Addresses ManifestAddresses `json:"addresses"`
+	Config    ManifestConfig    `json:"config"`
+}
+
+// ManifestAddresses are the addresses the proxy's listeners are bound to,
+// with any port 0 resolved to the port actually chosen
+type ManifestAddresses struct {
+	Proxy  string `json:"proxy"`
+	TLS    bool   `json:"tls"`
+	Admin  string `json:"admin,omitempty"`
+	SOCKS5 string `json:"socks5,omitempty"`
+}
+
+// ManifestConfig is the effective configuration, without secrets
+type ManifestConfig struct {
+	Users                    []string `json:"users"`
+	RequestTimeout           string   `json:"request_timeout"`
+	DialTimeout              string   `json:"dial_timeout"`
+	AllowedCIDRs             []string `json:"allowed_cidrs,omitempty"`
+	DeniedCIDRs              []string `json:"denied_cidrs,omitempty"`
+	AllowedConnectPorts      []int    `json:"allowed_connect_ports"`
+	MaxConcurrentConnections int      `json:"max_concurrent_connections,omitempty"`
+	ParentProxy              string   `json:"parent_proxy,omitempty"`
+}
+
+// manifest describes the server's current state
+func (ps *Server) manifest() Manifest {
+	ps.mu.Lock()
+	addresses := ManifestAddresses{
+		Proxy: addrString(ps.addr),
+		TLS:   ps.useTLS,
+		Admin: addrString(ps.adminAddr),
+	}
+	if ps.socksListener != nil {
+		addresses.SOCKS5 = ps.socksListener.Addr().String()
+	}
+	ps.mu.Unlock()
+
+	ps.usersMu.RLock()
+	users := make([]string, 0, len(ps.users))
+	for username := range ps.users {
+		users = append(users, username)
+	}
+	allowed, denied := cidrStrings(ps.AllowedCIDRs), cidrStrings(ps.DeniedCIDRs)
+	ps.usersMu.RUnlock()
+	sort.Strings(users)
+
+	config := ManifestConfig{
+		Users:                    users,
+		RequestTimeout:           ps.RequestTimeout.String(),
+		DialTimeout:              ps.DialTimeout.String(),
+		AllowedCIDRs:             allowed,
+		DeniedCIDRs:              denied,
+		AllowedConnectPorts:      ps.AllowedConnectPorts,
+		MaxConcurrentConnections: ps.MaxConcurrentConnections,
+	}
+	if ps.ParentProxy != nil {
+		config.ParentProxy = ps.ParentProxy.Redacted()
+	}
+
+	return Manifest{
+		Version:   Version,
+		PID:       os.Getpid(),
+		StartedAt: ps.started,
+		Addresses: addresses,
+		Config:    config,
+	}
+}
+
+// writeManifest writes the manifest to ManifestPath, if set. The file is
+// replaced atomically so readers never see a partial manifest.
+func (ps *Server) writeManifest() error {
+	if ps.ManifestPath == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(ps.manifest(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(ps.ManifestPath), ".manifest-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), ps.ManifestPath)
+}
+
+// removeManifest deletes the manifest written by writeManifest
+func (ps *Server) removeManifest() {
+	if ps.ManifestPath != "" {
+		os.Remove(ps.ManifestPath)
+	}
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+func cidrStrings(networks []*net.IPNet) []string {
+	var s []string
+	for _, network := range networks {
+		s = append(s, network.String())
+	}
+	return s
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForFile blocks until path exists
+func waitForFile(t *testing.T, path string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s was not written", path)
+}
+
+func TestManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	proxy := NewProxyServer("admin", "password123", "0")
+	proxy.RandomPort = true
+	proxy.AddUser("bob", "secret")
+	proxy.AdminPort = "0"
+	proxy.ManifestPath = path
+
+	done := make(chan error, 1)
+	go func() { done <- proxy.Start() }()
+	waitForFile(t, path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("Manifest is not valid JSON: %v", err)
+	}
+
+	if manifest.PID != os.Getpid() {
+		t.Errorf("Expected PID %d, got %d", os.Getpid(), manifest.PID)
+	}
+	if manifest.Version != Version {
+		t.Errorf("Expected version %q, got %q", Version, manifest.Version)
+	}
+
+	// The manifest reports the port actually bound for port 0
+	for name, addr := range map[string]string{"proxy": manifest.Addresses.Proxy, "admin": manifest.Addresses.Admin} {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil || port == "0" {
+			t.Fatalf("Expected resolved %s address, got %q", name, addr)
+		}
+		conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+		if err != nil {
+			t.Errorf("Expected %s listener at %s: %v", name, addr, err)
+			continue
+		}
+		conn.Close()
+	}
+
+	if len(manifest.Config.Users) != 2 || manifest.Config.Users[0] != "admin" || manifest.Config.Users[1] != "bob" {
+		t.Errorf("Expected users [admin bob], got %v", manifest.Config.Users)
+	}
+	if manifest.Config.RequestTimeout != "30s" {
+		t.Errorf("Expected request timeout 30s, got %s", manifest.Config.RequestTimeout)
+	}
+	if strings.Contains(string(data), "password123") || strings.Contains(string(data), "secret") {
+		t.Error("Manifest must not contain passwords")
+	}
+
+	if err := proxy.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected manifest to be removed on shutdown, got %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"sync/atomic"
+)
+
+// defaultMaxRequestMemory caps the bytes a single request may buffer
+const defaultMaxRequestMemory = 10 << 20
+
+// memoryBudget tracks the bytes one request holds in memory across all
+// buffering features, such as retry buffers, caching and body inspection
+type memoryBudget struct {
+	limit int64
+	used  atomic.Int64
+}
+
+// newMemoryBudget creates a budget of limit bytes. Zero means unlimited.
+func newMemoryBudget(limit int64) *memoryBudget {
+	return &memoryBudget{limit: limit}
+}
+
+// reserve claims n bytes from the budget, returning false if that would
+// exceed the limit. A nil budget is unlimited.
+func (b *memoryBudget) reserve(n int64) bool {
+	if b == nil {
+		return true
+	}
+	if b.used.Add(n) > b.limit && b.limit > 0 {
+		b.used.Add(-n)
+		return false
+	}
+	return true
+}
+
+// release returns n previously reserved bytes to the budget
+func (b *memoryBudget) release(n int64) {
+	if b == nil {
+		return
+	}
+	b.used.Add(-n)
+}
+
+// bufferedBody is a body read into memory under a memory budget
+type bufferedBody struct {
+	data []byte
+	// complete is false when the budget ran out before the end of the body
+	complete bool
+	// rest is the unread remainder of an incomplete body
+	rest io.Reader
+}
+
+// Reader returns a reader over the whole body. For an incomplete body the
+// buffered prefix is followed by the streamed remainder, so it can only be
+// read once.
+func (b *bufferedBody) Reader() io.Reader {
+	if b.complete {
+		return bytes.NewReader(b.data)
+	}
+	return io.MultiReader(bytes.NewReader(b.data), b.rest)
+}
+
+// bufferBody reads body into memory while the budget allows. When the
+// budget is exhausted it stops and returns an incomplete buffer so the
+// caller can fall back to streaming the body.
+func bufferBody(body io.Reader, budget *memoryBudget) (*bufferedBody, error) {
+	var buf bytes.Buffer
+	chunk := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(chunk)
+		if n > 0 {
+			if !budget.reserve(int64(n)) {
+				// Keep the chunk we already read so nothing is lost
+				rest := io.MultiReader(bytes.NewReader(chunk[:n:n]), body)
+				return &bufferedBody{data: buf.Bytes(), rest: rest}, nil
+			}
+			buf.Write(chunk[:n])
+		}
+		if err == io.EOF {
+			return &bufferedBody{data: buf.Bytes(), complete: true}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
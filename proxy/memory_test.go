@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	budget := newMemoryBudget(100)
+
+	if !budget.reserve(60) {
+		t.Fatal("First reservation should fit")
+	}
+	if budget.reserve(60) {
+		t.Error("Reservation over the cap should fail")
+	}
+	budget.release(60)
+	if !budget.reserve(100) {
+		t.Error("Released bytes should be available again")
+	}
+
+	var unlimited *memoryBudget
+	if !unlimited.reserve(1 << 40) {
+		t.Error("A nil budget should be unlimited")
+	}
+	if !newMemoryBudget(0).reserve(1 << 40) {
+		t.Error("A zero limit should be unlimited")
+	}
+}
+
+func TestBufferBody(t *testing.T) {
+	payload := strings.Repeat("x", 100*1024)
+
+	// The whole body fits within the budget
+	t.Run("Within budget", func(t *testing.T) {
+		budget := newMemoryBudget(1 << 20)
+		body, err := bufferBody(strings.NewReader(payload), budget)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !body.complete {
+			t.Error("Body should be fully buffered")
+		}
+		if string(body.data) != payload {
+			t.Error("Buffered data does not match payload")
+		}
+	})
+
+	// Buffering exceeds the cap and falls back to streaming
+	t.Run("Over budget falls back to streaming", func(t *testing.T) {
+		budget := newMemoryBudget(40 * 1024)
+		body, err := bufferBody(strings.NewReader(payload), budget)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body.complete {
+			t.Error("Body should not be fully buffered")
+		}
+		if int64(len(body.data)) > 40*1024 {
+			t.Errorf("Buffered %d bytes, more than the cap", len(body.data))
+		}
+
+		streamed, _ := io.ReadAll(body.Reader())
+		if !bytes.Equal(streamed, []byte(payload)) {
+			t.Errorf("Streamed body has %d bytes, expected %d", len(streamed), len(payload))
+		}
+	})
+}
@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// isTraceMethod reports whether method echoes the request back, which can
+// leak credentials and cookies through cross-site tracing
+func isTraceMethod(method string) bool {
+	return strings.EqualFold(method, http.MethodTrace) || strings.EqualFold(method, "TRACK")
+}
+
+// methodAllowed reports whether the proxy accepts requests with method
+func (ps *Server) methodAllowed(method string) bool {
+	return ps.AllowTrace || !isTraceMethod(method)
+}
+
+// forwardMethodAllowed reports whether AllowedMethods permits forwarding a
+// request with method
+func (ps *Server) forwardMethodAllowed(method string) bool {
+	if len(ps.AllowedMethods) == 0 {
+		return true
+	}
+	for _, allowed := range ps.AllowedMethods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTraceMethods(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backendServer.Close()
+
+	tests := []struct {
+		name       string
+		method     string
+		allowTrace bool
+		expected   int
+	}{
+		{"TRACE rejected by default", "TRACE", false, http.StatusMethodNotAllowed},
+		{"TRACK rejected by default", "TRACK", false, http.StatusMethodNotAllowed},
+		{"GET unaffected", "GET", false, http.StatusOK},
+		{"TRACE allowed when configured", "TRACE", true, http.StatusOK},
+		{"TRACK allowed when configured", "TRACK", true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.AllowTrace = tt.allowTrace
+
+			req := httptest.NewRequest(tt.method, backendServer.URL, nil)
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+			w := httptest.NewRecorder()
+
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestTraceMethods_Config(t *testing.T) {
+	cfg, err := LoadConfig(writeConfigFile(t, "config.yaml", "port: \"8080\"\nusers:\n  - username: a\n    password: b\nallow_trace: true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := NewProxyServerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proxy.AllowTrace {
+		t.Error("Expected allow_trace to enable TRACE")
+	}
+}
+
+func TestAllowedMethods(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backendServer.Close()
+
+	tests := []struct {
+		name     string
+		method   string
+		allowed  []string
+		expected int
+	}{
+		{"all methods allowed by default", "DELETE", nil, http.StatusOK},
+		{"allowed method", "POST", []string{"GET", "POST"}, http.StatusOK},
+		{"allowed method case-insensitive", "GET", []string{"get"}, http.StatusOK},
+		{"disallowed method", "DELETE", []string{"GET", "POST"}, http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.AllowedMethods = tt.allowed
+
+			req := httptest.NewRequest(tt.method, backendServer.URL, nil)
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+			w := httptest.NewRecorder()
+
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+			if tt.expected == http.StatusMethodNotAllowed && w.Header().Get("Allow") != "GET, POST" {
+				t.Errorf("Expected Allow header %q, got %q", "GET, POST", w.Header().Get("Allow"))
+			}
+		})
+	}
+}
+
+func TestAllowedMethods_ConnectUnaffected(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.AllowedMethods = []string{"GET"}
+	allowConnectPort(t, proxy, echoAddr)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	_, _, resp := openTunnel(t, server.Listener.Addr().String(), echoAddr)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the upstream request
+// duration histogram
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// knownMethods bounds the method label so clients cannot create series
+var knownMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "DELETE": true,
+	"CONNECT": true, "OPTIONS": true, "TRACE": true, "PATCH": true,
+}
+
+// Metrics holds the proxy's counters and serves them in the Prometheus text
+// exposition format
+type Metrics struct {
+	requests        atomic.Int64
+	authFailures    atomic.Int64
+	bytesUpstream   atomic.Int64
+	bytesDownstream atomic.Int64
+
+	// upstreamConnsNew and upstreamConnsReused count how forwarded requests
+	// obtained their upstream connection
+	upstreamConnsNew    atomic.Int64
+	upstreamConnsReused atomic.Int64
+
+	// activeConnections counts requests and tunnels in progress
+	activeConnections atomic.Int64
+
+	mu       sync.Mutex
+	byMethod map[string]int64
+	duration histogram
+}
+
+// histogram is a cumulative Prometheus histogram
+type histogram struct {
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+// newMetrics creates an empty set of metrics
+func newMetrics() *Metrics {
+	return &Metrics{
+		byMethod: make(map[string]int64),
+		duration: histogram{counts: make([]int64, len(durationBuckets))},
+	}
+}
+
+// recordRequest counts a request and its method
+func (m *Metrics) recordRequest(method string) {
+	m.requests.Add(1)
+	if !knownMethods[method] {
+		method = "OTHER"
+	}
+	m.mu.Lock()
+	m.byMethod[method]++
+	m.mu.Unlock()
+}
+
+// recordAuthFailure counts a failed authentication
+func (m *Metrics) recordAuthFailure() {
+	m.authFailures.Add(1)
+}
+
+// recordBytes counts bytes sent to upstreams and back to clients
+func (m *Metrics) recordBytes(upstream, downstream int64) {
+	m.bytesUpstream.Add(upstream)
+	m.bytesDownstream.Add(downstream)
+}
+
+// recordUpstreamConn counts an upstream connection taken by a forwarded
+// request, either freshly dialed or reused from the pool
+func (m *Metrics) recordUpstreamConn(reused bool) {
+	if reused {
+		m.upstreamConnsReused.Add(1)
+	} else {
+		m.upstreamConnsNew.Add(1)
+	}
+}
+
+// observeUpstreamDuration adds an upstream request duration to the histogram
+func (m *Metrics) observeUpstreamDuration(d time.Duration) {
+	seconds := d.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			m.duration.counts[i]++
+		}
+	}
+	m.duration.sum += seconds
+	m.duration.count++
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+
+	writeCounter(cw, "proxy_requests_total", "Total number of requests received.", m.requests.Load())
+	writeCounter(cw, "proxy_auth_failures_total", "Total number of failed proxy authentications.", m.authFailures.Load())
+
+	fmt.Fprintf(cw, "# HELP proxy_active_connections Requests and tunnels in progress.\n")
+	fmt.Fprintf(cw, "# TYPE proxy_active_connections gauge\n")
+	fmt.Fprintf(cw, "proxy_active_connections %d\n", m.activeConnections.Load())
+
+	fmt.Fprintf(cw, "# HELP proxy_bytes_transferred_total Total bytes proxied by direction.\n")
+	fmt.Fprintf(cw, "# TYPE proxy_bytes_transferred_total counter\n")
+	fmt.Fprintf(cw, "proxy_bytes_transferred_total{direction=\"upstream\"} %d\n", m.bytesUpstream.Load())
+	fmt.Fprintf(cw, "proxy_bytes_transferred_total{direction=\"downstream\"} %d\n", m.bytesDownstream.Load())
+
+	fmt.Fprintf(cw, "# HELP proxy_upstream_connections_total Upstream connections used by forwarded requests.\n")
+	fmt.Fprintf(cw, "# TYPE proxy_upstream_connections_total counter\n")
+	fmt.Fprintf(cw, "proxy_upstream_connections_total{reused=\"false\"} %d\n", m.upstreamConnsNew.Load())
+	fmt.Fprintf(cw, "proxy_upstream_connections_total{reused=\"true\"} %d\n", m.upstreamConnsReused.Load())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(cw, "# HELP proxy_requests_by_method_total Total number of requests by HTTP method.\n")
+	fmt.Fprintf(cw, "# TYPE proxy_requests_by_method_total counter\n")
+	methods := make([]string, 0, len(m.byMethod))
+	for method := range m.byMethod {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		fmt.Fprintf(cw, "proxy_requests_by_method_total{method=%q} %d\n", method, m.byMethod[method])
+	}
+
+	fmt.Fprintf(cw, "# HELP proxy_upstream_request_duration_seconds Duration of upstream HTTP requests.\n")
+	fmt.Fprintf(cw, "# TYPE proxy_upstream_request_duration_seconds histogram\n")
+	for i, bound := range durationBuckets {
+		fmt.Fprintf(cw, "proxy_upstream_request_duration_seconds_bucket{le=\"%s\"} %d\n", formatFloat(bound), m.duration.counts[i])
+	}
+	fmt.Fprintf(cw, "proxy_upstream_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.duration.count)
+	fmt.Fprintf(cw, "proxy_upstream_request_duration_seconds_sum %s\n", formatFloat(m.duration.sum))
+	fmt.Fprintf(cw, "proxy_upstream_request_duration_seconds_count %d\n", m.duration.count)
+
+	return cw.n, cw.err
+}
+
+func writeCounter(w io.Writer, name, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// countingWriter tracks the bytes and first error of a series of writes
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
+
+// countingReader counts the bytes read through it and stops with
+// errByteQuotaExceeded once its optional quota runs out. Reads are also
+// added to the optional total as they happen.
+type countingReader struct {
+	r     io.Reader
+	n     atomic.Int64
+	quota *byteQuota
+	total *atomic.Int64
+}
+
+func (cr *countingReader) Read(b []byte) (int, error) {
+	n, err := cr.r.Read(b)
+	if allowed := cr.quota.take(n); allowed < n {
+		n, err = allowed, errByteQuotaExceeded
+	}
+	cr.n.Add(int64(n))
+	if cr.total != nil {
+		cr.total.Add(int64(n))
+	}
+	return n, err
+}
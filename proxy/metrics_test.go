@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrapeMetrics(t *testing.T, ps *Server) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	ps.adminHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	return w.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("0123456789"))
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	before := scrapeMetrics(t, proxy)
+	if !strings.Contains(before, "proxy_requests_total 0\n") {
+		t.Errorf("Expected zero requests before proxying, got:\n%s", before)
+	}
+
+	req := httptest.NewRequest("POST", backendServer.URL, strings.NewReader("hello"))
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("BREW", backendServer.URL, nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "wrong"))
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	after := scrapeMetrics(t, proxy)
+	expected := []string{
+		"proxy_requests_total 2\n",
+		"proxy_auth_failures_total 1\n",
+		`proxy_requests_by_method_total{method="POST"} 1` + "\n",
+		`proxy_requests_by_method_total{method="OTHER"} 1` + "\n",
+		`proxy_bytes_transferred_total{direction="upstream"} 5` + "\n",
+		`proxy_bytes_transferred_total{direction="downstream"} 10` + "\n",
+		`proxy_upstream_request_duration_seconds_bucket{le="+Inf"} 1` + "\n",
+		"proxy_upstream_request_duration_seconds_count 1\n",
+	}
+	for _, line := range expected {
+		if !strings.Contains(after, line) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, after)
+		}
+	}
+}
+
+func TestMetrics_DurationSkippedWhenUnsampled(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.SampleRate = 0
+
+	req := httptest.NewRequest("GET", backendServer.URL, nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	output := scrapeMetrics(t, proxy)
+	if !strings.Contains(output, "proxy_requests_total 1\n") {
+		t.Errorf("Expected request counter to be exact regardless of sampling, got:\n%s", output)
+	}
+	if !strings.Contains(output, "proxy_upstream_request_duration_seconds_count 0\n") {
+		t.Errorf("Expected no duration observations for unsampled requests, got:\n%s", output)
+	}
+}
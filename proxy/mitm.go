@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxInterceptCerts bounds the cache of generated leaf certificates
+const maxInterceptCerts = 1024
+
+// interceptCertLifetime is how long a generated leaf certificate is valid
+const interceptCertLifetime = 24 * time.Hour
+
+// LoadInterceptCA loads the CA certificate and key that sign the leaf
+// certificates presented to clients when InterceptHTTPS is set
+func LoadInterceptCA(certFile, keyFile string) (*tls.Certificate, error) {
+	ca, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if ca.Leaf, err = x509.ParseCertificate(ca.Certificate[0]); err != nil {
+		return nil, err
+	}
+	if !ca.Leaf.IsCA {
+		return nil, fmt.Errorf("%s is not a CA certificate", certFile)
+	}
+	return &ca, nil
+}
+
+// certCache holds generated leaf certificates by host name
+type certCache struct {
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate
+}
+
+// get returns a cached certificate for host that is not about to expire,
+// or nil
+func (cc *certCache) get(host string, now time.Time) *tls.Certificate {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cert := cc.certs[host]
+	if cert == nil || now.After(cert.Leaf.NotAfter.Add(-time.Minute)) {
+		return nil
+	}
+	return cert
+}
+
+func (cc *certCache) put(host string, cert *tls.Certificate) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.certs == nil || len(cc.certs) >= maxInterceptCerts {
+		cc.certs = make(map[string]*tls.Certificate)
+	}
+	cc.certs[host] = cert
+}
+
+// interceptCert returns the certificate presented to a client connecting to
+// host, generating and caching one signed by InterceptCA on first use
+func (ps *Server) interceptCert(host string) (*tls.Certificate, error) {
+	now := ps.now()
+	if cert := ps.interceptCerts.get(host, now); cert != nil {
+		return cert, nil
+	}
+
+	ca := ps.InterceptCA
+	if ca == nil || ca.Leaf == nil {
+		return nil, errors.New("no intercept CA configured")
+	}
+	signer, ok := ca.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("intercept CA key cannot sign")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(interceptCertLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if template.NotAfter.After(ca.Leaf.NotAfter) {
+		template.NotAfter = ca.Leaf.NotAfter
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Leaf, &key.PublicKey, signer)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	cert := &tls.Certificate{
+		Certificate: [][]byte{der, ca.Leaf.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	ps.interceptCerts.put(host, cert)
+	return cert, nil
+}
+
+// intercept terminates the client's TLS on an established CONNECT tunnel
+// with a certificate for target and forwards each decrypted request to the
+// upstream as a proxied HTTPS request. r is the CONNECT request, whose
+// context carries the authenticated user. It returns once the client
+// connection is closed, including after an upgraded request hijacked it.
+func (ps *Server) intercept(clientConn net.Conn, r *http.Request, target string) {
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		return
+	}
+	tlsConn := tls.Server(clientConn, &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := hello.ServerName
+			if name == "" {
+				name = host
+			}
+			return ps.interceptCert(name)
+		},
+	})
+
+	ln := newConnListener(tlsConn)
+	var hijacked atomic.Bool
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			// Upgraded requests own the connection until the handler returns
+			defer func() {
+				if hijacked.Load() {
+					ln.Close()
+				}
+			}()
+			req = req.WithContext(interceptContext(req.Context(), r.Context()))
+			req.URL.Scheme = "https"
+			req.URL.Host = target
+			req.RemoteAddr = r.RemoteAddr
+			requested := *req.URL
+			requested.Host = r.Host
+			requestInfoFromContext(req.Context()).requestedURL = requested.String()
+			if isSampled(req.Context()) {
+				log.Printf("%s %s %s (intercepted)", req.RemoteAddr, req.Method, req.URL.String())
+			}
+			if !ps.methodAllowed(req.Method) {
+				ps.writeError(w, req, "Method Not Allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if !ps.forwardMethodAllowed(req.Method) {
+				w.Header().Set("Allow", strings.Join(ps.AllowedMethods, ", "))
+				ps.writeError(w, req, "Method Not Allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			ps.forward(w, req)
+		}),
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			switch state {
+			case http.StateHijacked:
+				hijacked.Store(true)
+			case http.StateClosed:
+				ln.Close()
+			}
+		},
+		ReadHeaderTimeout: ps.requestTimeoutFor(target),
+		MaxHeaderBytes:    ps.MaxHeaderBytes,
+	}
+	server.Serve(ln)
+}
+
+// interceptContext returns ctx, the context of a request decrypted from an
+// intercepted tunnel, with the user, policy tag and sampling decision of
+// the CONNECT request's context connect
+func interceptContext(ctx, connect context.Context) context.Context {
+	info := requestInfoFromContext(connect)
+	ctx = withRequestInfo(ctx, &requestInfo{user: info.user, tag: info.tag})
+	ctx = withPolicyTag(ctx, policyTagFromContext(connect))
+	return withSampled(ctx, isSampled(connect))
+}
+
+// connListener is a net.Listener that accepts a single connection and then
+// blocks until it is closed
+type connListener struct {
+	conn      net.Conn
+	addr      net.Addr
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	return &connListener{conn: conn, addr: conn.LocalAddr(), closed: make(chan struct{})}
+}
+
+// Accept is only called from the serving goroutine
+func (cl *connListener) Accept() (net.Conn, error) {
+	if conn := cl.conn; conn != nil {
+		cl.conn = nil
+		return conn, nil
+	}
+	<-cl.closed
+	return nil, net.ErrClosed
+}
+
+func (cl *connListener) Close() error {
+	cl.closeOnce.Do(func() { close(cl.closed) })
+	return nil
+}
+
+func (cl *connListener) Addr() net.Addr {
+	return cl.addr
+}
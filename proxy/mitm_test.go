@@ -0,0 +1,319 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInterceptHTTPS(t *testing.T) {
+	logs := captureLog(t)
+	backendServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("decrypted " + r.URL.Path))
+	}))
+	defer backendServer.Close()
+
+	caFile, keyFile, caCert := writeTestCertificate(t)
+	ca, err := LoadInterceptCA(caFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.InterceptHTTPS = true
+	proxy.InterceptCA = ca
+	proxy.RootCAs = x509.NewCertPool()
+	proxy.RootCAs.AddCert(backendServer.Certificate())
+	allowConnectPort(t, proxy, backendServer.Listener.Addr().String())
+	if err := proxy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	// The client only trusts the local CA, not the backend's certificate
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(caCert)
+	proxyURL := &url.URL{Scheme: "http", User: url.UserPassword("admin", "password123"), Host: server.Listener.Addr().String()}
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{RootCAs: clientCAs},
+		},
+	}
+
+	resp, err := client.Get(backendServer.URL + "/secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if string(body) != "decrypted /secret" {
+		t.Errorf("Unexpected body %q", body)
+	}
+	if err := resp.TLS.PeerCertificates[0].CheckSignatureFrom(caCert); err != nil {
+		t.Errorf("Expected the presented certificate to be signed by the local CA, got %v", err)
+	}
+	waitForLog(t, logs, "GET "+backendServer.URL+"/secret (intercepted)")
+}
+
+func TestInterceptHTTPS_MethodChecks(t *testing.T) {
+	var reached atomic.Int32
+	backendServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached.Add(1)
+	}))
+	defer backendServer.Close()
+
+	caFile, keyFile, caCert := writeTestCertificate(t)
+	ca, err := LoadInterceptCA(caFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.InterceptHTTPS = true
+	proxy.InterceptCA = ca
+	proxy.RootCAs = x509.NewCertPool()
+	proxy.RootCAs.AddCert(backendServer.Certificate())
+	proxy.AllowedMethods = []string{"GET", "HEAD"}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error, status int) {
+		w.Header().Set("X-Error-Handler", "called")
+		DefaultErrorHandler(w, r, err, status)
+	}
+	allowConnectPort(t, proxy, backendServer.Listener.Addr().String())
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(caCert)
+	proxyURL := &url.URL{Scheme: "http", User: url.UserPassword("admin", "password123"), Host: server.Listener.Addr().String()}
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{RootCAs: clientCAs},
+		},
+	}
+
+	tests := []struct {
+		method         string
+		expectedStatus int
+		expectedAllow  string
+		forwarded      bool
+	}{
+		{method: http.MethodGet, expectedStatus: http.StatusOK, forwarded: true},
+		{method: http.MethodTrace, expectedStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodDelete, expectedStatus: http.StatusMethodNotAllowed, expectedAllow: "GET, HEAD"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			before := reached.Load()
+			req, _ := http.NewRequest(tt.method, backendServer.URL, nil)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+			if allow := resp.Header.Get("Allow"); allow != tt.expectedAllow {
+				t.Errorf("Expected Allow %q, got %q", tt.expectedAllow, allow)
+			}
+			if called := resp.Header.Get("X-Error-Handler") != ""; called == tt.forwarded {
+				t.Errorf("Expected the error handler to be called %v, got %v", !tt.forwarded, called)
+			}
+			if forwarded := reached.Load() > before; forwarded != tt.forwarded {
+				t.Errorf("Expected forwarded %v, got %v", tt.forwarded, forwarded)
+			}
+		})
+	}
+}
+
+func TestInterceptCert_Cached(t *testing.T) {
+	caFile, keyFile, _ := writeTestCertificate(t)
+	ca, err := LoadInterceptCA(caFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.InterceptCA = ca
+
+	first, err := proxy.interceptCert("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := proxy.interceptCert("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Error("Expected the certificate for a host to be reused")
+	}
+	if err := first.Leaf.VerifyHostname("example.com"); err != nil {
+		t.Errorf("Expected certificate valid for example.com, got %v", err)
+	}
+
+	other, err := proxy.interceptCert("example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == first {
+		t.Error("Expected a separate certificate per host")
+	}
+}
+
+func TestInterceptHTTPS_RequiresCA(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.InterceptHTTPS = true
+	if err := proxy.Validate(); err == nil {
+		t.Error("Expected an error without an intercept CA")
+	}
+}
+
+// startInterceptProxy serves an intercepting proxy that trusts backend's
+// certificate. It returns the proxy address and the CA clients must trust.
+func startInterceptProxy(t *testing.T, backend *httptest.Server) (string, *x509.CertPool) {
+	t.Helper()
+	caFile, keyFile, caCert := writeTestCertificate(t)
+	ca, err := LoadInterceptCA(caFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.InterceptHTTPS = true
+	proxy.InterceptCA = ca
+	proxy.RootCAs = x509.NewCertPool()
+	proxy.RootCAs.AddCert(backend.Certificate())
+	allowConnectPort(t, proxy, backend.Listener.Addr().String())
+	server := httptest.NewServer(proxy)
+	t.Cleanup(server.Close)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(caCert)
+	return server.Listener.Addr().String(), clientCAs
+}
+
+func TestInterceptHTTPS_Upgrade(t *testing.T) {
+	backendServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			http.Error(w, "upgrade required", http.StatusBadRequest)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+			websocketAccept(r.Header.Get("Sec-WebSocket-Key")))
+		rw.Flush()
+
+		for {
+			header := make([]byte, 6)
+			if _, err := io.ReadFull(rw, header); err != nil {
+				return
+			}
+			payload := make([]byte, header[1]&0x7f)
+			if _, err := io.ReadFull(rw, payload); err != nil {
+				return
+			}
+			for i := range payload {
+				payload[i] ^= header[2+i%4]
+			}
+			conn.Write(append([]byte{0x81, byte(len(payload))}, payload...))
+		}
+	}))
+	defer backendServer.Close()
+
+	proxyAddr, clientCAs := startInterceptProxy(t, backendServer)
+	target := backendServer.Listener.Addr().String()
+	conn, _, resp := openTunnel(t, proxyAddr, target)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	tlsConn := tls.Client(conn, &tls.Config{RootCAs: clientCAs, ServerName: "127.0.0.1"})
+
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	fmt.Fprintf(tlsConn, "GET /chat HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", target, key)
+	reader := bufio.NewReader(tlsConn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected status %d, got %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+
+	// Give a prematurely closed tunnel time to go away
+	time.Sleep(50 * time.Millisecond)
+	for _, message := range []string{"hello", "inside the tunnel"} {
+		mask := []byte{1, 2, 3, 4}
+		frame := append([]byte{0x81, 0x80 | byte(len(message))}, mask...)
+		for i := range message {
+			frame = append(frame, message[i]^mask[i%4])
+		}
+		tlsConn.Write(frame)
+
+		echoed := make([]byte, 2+len(message))
+		if _, err := io.ReadFull(reader, echoed); err != nil {
+			t.Fatalf("Expected echoed frame, got %v", err)
+		}
+		if string(echoed[2:]) != message {
+			t.Errorf("Expected text frame %q, got %q", message, echoed)
+		}
+	}
+}
+
+func TestInterceptHTTPS_RequestCancel(t *testing.T) {
+	cancelled := make(chan struct{})
+	backendServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer backendServer.Close()
+
+	proxyAddr, clientCAs := startInterceptProxy(t, backendServer)
+	proxyURL := &url.URL{Scheme: "http", User: url.UserPassword("admin", "password123"), Host: proxyAddr}
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{RootCAs: clientCAs},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, backendServer.URL, nil)
+	if resp, err := client.Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatal("Expected the request to be cancelled")
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(3 * time.Second):
+		t.Error("Expected the upstream request to be cancelled with the client's")
+	}
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import "time"
+
+// Option configures a Server created by New
+type Option interface {
+	apply(*Server)
+}
+
+// optionFunc adapts a function to the Option interface
+type optionFunc func(*Server)
+
+func (f optionFunc) apply(ps *Server) { f(ps) }
+
+// Options holds the basic settings of a Server. It is an Option, so
+// New(Options{...}) is equivalent to passing WithPort and WithCredentials.
+// Empty fields keep their defaults.
+type Options struct {
+	// Port is the port Start listens on
+	Port string
+	// Username and Password are the credentials of the initial user
+	Username string
+	Password string
+}
+
+func (o Options) apply(ps *Server) {
+	if o.Port != "" {
+		WithPort(o.Port).apply(ps)
+	}
+	if o.Username != "" {
+		WithCredentials(o.Username, o.Password).apply(ps)
+	}
+}
+
+// WithPort sets the port Start listens on
+func WithPort(port string) Option {
+	return optionFunc(func(ps *Server) {
+		ps.port = port
+	})
+}
+
+// WithCredentials sets the initial user, replacing one set by an earlier
+// option. More users can be added with AddUser.
+func WithCredentials(username, password string) Option {
+	return optionFunc(func(ps *Server) {
+		delete(ps.users, ps.username)
+		ps.username, ps.password = username, password
+		ps.users[username] = &credential{password: password}
+	})
+}
+
+// WithTimeout sets the timeout for upstream requests and dials
+func WithTimeout(timeout time.Duration) Option {
+	return optionFunc(func(ps *Server) {
+		ps.RequestTimeout = timeout
+		ps.DialTimeout = timeout
+	})
+}
+
+// WithTLS makes Start serve TLS on the client-facing listener with the
+// given certificate and key files
+func WithTLS(certFile, keyFile string) Option {
+	return optionFunc(func(ps *Server) {
+		ps.certFile, ps.keyFile = certFile, keyFile
+	})
+}
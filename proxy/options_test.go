@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNew_Options(t *testing.T) {
+	proxy := New(
+		WithPort("3128"),
+		WithCredentials("alice", "s3cret"),
+		WithTimeout(5*time.Second),
+		WithTLS("cert.pem", "key.pem"),
+	)
+
+	if proxy.port != "3128" {
+		t.Errorf("Expected port 3128, got %s", proxy.port)
+	}
+	if !proxy.checkCredentials("alice", "s3cret") {
+		t.Error("Expected alice to authenticate")
+	}
+	if proxy.RequestTimeout != 5*time.Second || proxy.DialTimeout != 5*time.Second {
+		t.Errorf("Expected 5s timeouts, got request %v and dial %v", proxy.RequestTimeout, proxy.DialTimeout)
+	}
+	if proxy.certFile != "cert.pem" || proxy.keyFile != "key.pem" {
+		t.Errorf("Expected TLS files cert.pem and key.pem, got %q and %q", proxy.certFile, proxy.keyFile)
+	}
+	if proxy.MaxIdleConnsPerHost != defaultMaxIdleConnsPerHost {
+		t.Errorf("Expected other settings to keep their defaults, got MaxIdleConnsPerHost %d", proxy.MaxIdleConnsPerHost)
+	}
+}
+
+func TestNew_Defaults(t *testing.T) {
+	proxy := New()
+
+	if proxy.port != defaultPort {
+		t.Errorf("Expected default port %s, got %s", defaultPort, proxy.port)
+	}
+	if proxy.RequestTimeout != defaultTimeout {
+		t.Errorf("Expected default timeout %v, got %v", defaultTimeout, proxy.RequestTimeout)
+	}
+	if len(proxy.users) != 0 {
+		t.Errorf("Expected no users without WithCredentials, got %d", len(proxy.users))
+	}
+}
+
+func TestNew_CredentialsReplaced(t *testing.T) {
+	proxy := New(WithCredentials("alice", "first"), WithCredentials("bob", "second"))
+
+	if proxy.checkCredentials("alice", "first") {
+		t.Error("Expected the earlier user to be replaced")
+	}
+	if !proxy.checkCredentials("bob", "second") {
+		t.Error("Expected bob to authenticate")
+	}
+}
+
+func TestNew_OptionsStruct(t *testing.T) {
+	proxy := New(Options{Username: "alice", Password: "s3cret"}, WithTimeout(time.Second))
+
+	if proxy.port != defaultPort {
+		t.Errorf("Expected an empty Port to keep the default, got %s", proxy.port)
+	}
+	if !proxy.checkCredentials("alice", "s3cret") {
+		t.Error("Expected alice to authenticate")
+	}
+	if proxy.RequestTimeout != time.Second {
+		t.Errorf("Expected later options to apply, got timeout %v", proxy.RequestTimeout)
+	}
+}
+
+func TestNew_WithTLSStartServesTLS(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backendServer.Close()
+	certFile, keyFile, cert := writeTestCertificate(t)
+
+	port := freePort(t)
+	proxy := New(WithPort(port), WithCredentials("admin", "password123"), WithTLS(certFile, keyFile))
+	go proxy.Start()
+	waitForListener(t, "127.0.0.1:"+port)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			Proxy: http.ProxyURL(&url.URL{
+				Scheme: "https",
+				User:   url.UserPassword("admin", "password123"),
+				Host:   "127.0.0.1:" + port,
+			}),
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+
+	resp, err := client.Get(backendServer.URL)
+	if err != nil {
+		t.Fatalf("Request through TLS proxy failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// pacContentType is the MIME type browsers expect for proxy auto-config files
+const pacContentType = "application/x-ns-proxy-autoconfig"
+
+// pacProxyAddr returns the host and port clients should be configured with.
+// Without PACHost this is the address the client used to fetch the file.
+func (ps *Server) pacProxyAddr(r *http.Request) string {
+	if ps.PACHost != "" {
+		return ps.PACHost
+	}
+	if _, _, err := net.SplitHostPort(r.Host); err == nil {
+		return r.Host
+	}
+	return net.JoinHostPort(r.Host, ps.port)
+}
+
+// servePAC writes a proxy auto-config file sending all traffic through this
+// proxy, falling back to direct connections if it is unreachable
+func (ps *Server) servePAC(w http.ResponseWriter, r *http.Request) {
+	scheme := "PROXY"
+	if r.TLS != nil {
+		scheme = "HTTPS"
+	}
+	w.Header().Set("Content-Type", pacContentType)
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "function FindProxyForURL(url, host) {\n")
+	fmt.Fprintf(w, "\treturn %q;\n", scheme+" "+ps.pacProxyAddr(r)+"; DIRECT")
+	io.WriteString(w, "}\n")
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPACFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		pacHost  string
+		reqHost  string
+		expected string
+	}{
+		{"Request host", "", "proxy.internal:3128", `return "PROXY proxy.internal:3128; DIRECT";`},
+		{"Request host without port", "", "proxy.internal", `return "PROXY proxy.internal:8080; DIRECT";`},
+		{"Configured host", "gateway.example.com:8080", "10.0.0.5:8080", `return "PROXY gateway.example.com:8080; DIRECT";`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.PACPath = "/proxy.pac"
+			proxy.PACHost = tt.pacHost
+
+			// Fetched without credentials
+			req := httptest.NewRequest("GET", "/proxy.pac", nil)
+			req.Host = tt.reqHost
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != pacContentType {
+				t.Errorf("Expected Content-Type %q, got %q", pacContentType, ct)
+			}
+			body := w.Body.String()
+			if !strings.Contains(body, "function FindProxyForURL(url, host)") {
+				t.Errorf("Expected a FindProxyForURL function, got:\n%s", body)
+			}
+			if !strings.Contains(body, tt.expected) {
+				t.Errorf("Expected PAC file to contain %q, got:\n%s", tt.expected, body)
+			}
+		})
+	}
+}
+
+func TestPACFile_Disabled(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	req := httptest.NewRequest("GET", "/proxy.pac", nil)
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import "context"
+
+// Policy holds the limits applied to requests carrying a policy tag
+type Policy struct {
+	// RequestsPerSecond caps the request rate of each user with the tag.
+	// Zero means unlimited.
+	RequestsPerSecond float64
+	// Burst is the number of requests allowed at once. It defaults to the
+	// per-second rate when unset.
+	Burst int
+	// MaxConnectionBytes and BytesPerSecond replace the server's
+	// MaxConnectionBytes and RateLimitBytesPerSec for the tag when set
+	MaxConnectionBytes int64
+	BytesPerSecond     int
+}
+
+type policyTagKey struct{}
+
+// withPolicyTag returns a copy of ctx carrying the given policy tag
+func withPolicyTag(ctx context.Context, tag string) context.Context {
+	return context.WithValue(ctx, policyTagKey{}, tag)
+}
+
+// policyTagFromContext returns the policy tag stored in ctx, if any
+func policyTagFromContext(ctx context.Context) string {
+	tag, _ := ctx.Value(policyTagKey{}).(string)
+	return tag
+}
+
+// policyTag returns the policy tag of user
+func (ps *Server) policyTag(user string) string {
+	ps.usersMu.RLock()
+	defer ps.usersMu.RUnlock()
+	return ps.PolicyTags[user]
+}
+
+// policyFor returns the Policy of the tag carried by ctx
+func (ps *Server) policyFor(ctx context.Context) Policy {
+	return ps.Policies[policyTagFromContext(ctx)]
+}
+
+// connectionBytesFor returns the byte quota of a connection whose requests
+// carry ctx
+func (ps *Server) connectionBytesFor(ctx context.Context) int64 {
+	if limit := ps.policyFor(ctx).MaxConnectionBytes; limit > 0 {
+		return limit
+	}
+	return ps.MaxConnectionBytes
+}
+
+// bytesPerSecFor returns the throughput cap of a request carrying ctx
+func (ps *Server) bytesPerSecFor(ctx context.Context) int {
+	if limit := ps.policyFor(ctx).BytesPerSecond; limit > 0 {
+		return limit
+	}
+	return ps.RateLimitBytesPerSec
+}
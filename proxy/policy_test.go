@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestPolicyTagRateLimits(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("basic", "basicpass", "8080")
+	proxy.AddUser("premium", "premiumpass")
+	proxy.PolicyTags = map[string]string{
+		"basic":   "tier=basic",
+		"premium": "tier=premium",
+	}
+	proxy.Policies = map[string]Policy{
+		"tier=basic":   {RequestsPerSecond: 0.001, Burst: 2},
+		"tier=premium": {RequestsPerSecond: 0.001, Burst: 10},
+	}
+
+	tests := []struct {
+		name            string
+		username        string
+		password        string
+		expectedAllowed int
+	}{
+		{"Basic tier", "basic", "basicpass", 2},
+		{"Premium tier", "premium", "premiumpass", 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed := 0
+			for i := 0; i < 15; i++ {
+				req := httptest.NewRequest("GET", backendServer.URL, nil)
+				req.Header.Set("Proxy-Authorization", CreateBasicAuth(tt.username, tt.password))
+				w := httptest.NewRecorder()
+
+				proxy.ServeHTTP(w, req)
+
+				switch w.Code {
+				case http.StatusOK:
+					allowed++
+				case http.StatusTooManyRequests:
+				default:
+					t.Fatalf("Unexpected status %d", w.Code)
+				}
+			}
+
+			if allowed != tt.expectedAllowed {
+				t.Errorf("Expected %d allowed requests, got %d", tt.expectedAllowed, allowed)
+			}
+		})
+	}
+}
+
+func TestPolicyTagByteQuota(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("x"), 1000))
+	}))
+	defer backendServer.Close()
+
+	captureLog(t)
+	proxy := NewProxyServer("basic", "basicpass", "8080")
+	proxy.AddUser("premium", "premiumpass")
+	proxy.PolicyTags = map[string]string{
+		"basic":   "tier=basic",
+		"premium": "tier=premium",
+	}
+	proxy.Policies = map[string]Policy{
+		"tier=basic": {MaxConnectionBytes: 100},
+	}
+	proxyServer := httptest.NewServer(proxy)
+	defer proxyServer.Close()
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		complete bool
+	}{
+		{"Basic tier", "basic", "basicpass", false},
+		{"Premium tier", "premium", "premiumpass", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxyURL, _ := url.Parse(proxyServer.URL)
+			proxyURL.User = url.UserPassword(tt.username, tt.password)
+			client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+			// Over quota the connection may drop before or after the headers
+			var body []byte
+			resp, err := client.Get(backendServer.URL)
+			if err == nil {
+				body, err = io.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+
+			if complete := err == nil && len(body) == 1000; complete != tt.complete {
+				t.Errorf("Expected complete body %v, got %d bytes (%v)", tt.complete, len(body), err)
+			}
+		})
+	}
+}
+
+func TestPolicyTagContext(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.PolicyTags = map[string]string{"admin": "tier=premium"}
+
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	w := httptest.NewRecorder()
+
+	req, ok := proxy.authorize(w, req)
+	if !ok {
+		t.Fatalf("Expected request to be authorized, got status %d", w.Code)
+	}
+
+	if tag := policyTagFromContext(req.Context()); tag != "tier=premium" {
+		t.Errorf("Expected tag tier=premium, got %q", tag)
+	}
+}
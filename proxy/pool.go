@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+// errNoUpstream is returned for a pooled host when none of its backends can
+// be used
+var errNoUpstream = errors.New("no healthy upstream in pool")
+
+// Upstream is a backend of a pool in UpstreamPools
+type Upstream struct {
+	// Addr is the backend host. The request's port is kept unless Addr
+	// names one.
+	Addr string `json:"addr" yaml:"addr"`
+	// Weight is the backend's share of requests relative to the rest of its
+	// pool. Backends with a weight of 0 get none.
+	Weight int `json:"weight" yaml:"weight"`
+}
+
+// poolTracker keeps the smooth weighted round-robin state of each pool, so
+// backends are interleaved instead of picked in runs
+type poolTracker struct {
+	mu      sync.Mutex
+	current map[string][]int
+}
+
+func newPoolTracker() *poolTracker {
+	return &poolTracker{current: make(map[string][]int)}
+}
+
+// next returns the index of the backend of pool name to use, considering only
+// backends for which usable is true, or -1 if there are none
+func (pt *poolTracker) next(name string, backends []Upstream, usable func(int) bool) int {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	current := pt.current[name]
+	if len(current) != len(backends) {
+		current = make([]int, len(backends))
+		pt.current[name] = current
+	}
+
+	best, total := -1, 0
+	for i, backend := range backends {
+		if backend.Weight <= 0 || !usable(i) {
+			continue
+		}
+		current[i] += backend.Weight
+		total += backend.Weight
+		if best < 0 || current[i] > current[best] {
+			best = i
+		}
+	}
+	if best >= 0 {
+		current[best] -= total
+	}
+	return best
+}
+
+// pickUpstream chooses the backend for addr, a host with an optional port,
+// from its pool in UpstreamPools. Backends whose circuit breaker is open are
+// skipped; defaultPort completes their address for that lookup when neither
+// addr nor the backend names a port. It reports false if addr has no pool.
+func (ps *Server) pickUpstream(addr, defaultPort string) (string, bool, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, ""
+	}
+	for name, backends := range ps.UpstreamPools {
+		if !strings.EqualFold(name, host) {
+			continue
+		}
+		targets := make([]string, len(backends))
+		for i, backend := range backends {
+			targets[i] = withPort(backend.Addr, port)
+		}
+		i := ps.pools.next(name, backends, func(i int) bool {
+			return !ps.circuitOpen(withPort(targets[i], defaultPort))
+		})
+		if i < 0 {
+			return addr, true, errNoUpstream
+		}
+		return targets[i], true, nil
+	}
+	return addr, false, nil
+}
+
+// withPort adds port to host unless host already names one or port is empty
+func withPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil || port == "" {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPickUpstream_Weights(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.UpstreamPools = map[string][]Upstream{
+		"api.internal": {
+			{Addr: "10.0.0.1", Weight: 5},
+			{Addr: "10.0.0.2", Weight: 3},
+			{Addr: "10.0.0.3:9000", Weight: 2},
+			{Addr: "10.0.0.4", Weight: 0},
+		},
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		target, pooled, err := proxy.pickUpstream("api.internal:8080", "80")
+		if !pooled || err != nil {
+			t.Fatalf("Expected a pooled backend, got %v %v", pooled, err)
+		}
+		counts[target]++
+	}
+
+	expected := map[string]int{"10.0.0.1:8080": 500, "10.0.0.2:8080": 300, "10.0.0.3:9000": 200}
+	for target, want := range expected {
+		if got := counts[target]; got < want*95/100 || got > want*105/100 {
+			t.Errorf("Expected about %d requests to %s, got %d", want, target, got)
+		}
+	}
+	if counts["10.0.0.4:8080"] != 0 {
+		t.Errorf("Expected no requests to the zero weight backend, got %d", counts["10.0.0.4:8080"])
+	}
+}
+
+func TestPickUpstream_Unpooled(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.UpstreamPools = map[string][]Upstream{"api.internal": {{Addr: "10.0.0.1", Weight: 1}}}
+
+	target, pooled, err := proxy.pickUpstream("example.com:443", "")
+	if pooled || err != nil || target != "example.com:443" {
+		t.Errorf("Expected example.com:443 to pass through, got %s %v %v", target, pooled, err)
+	}
+}
+
+func TestPickUpstream_SkipsOpenCircuits(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.CircuitBreaker = &CircuitBreaker{Threshold: 1, Cooldown: time.Minute}
+	proxy.UpstreamPools = map[string][]Upstream{
+		"api.internal": {
+			{Addr: "10.0.0.1", Weight: 9},
+			{Addr: "10.0.0.2", Weight: 1},
+		},
+	}
+	proxy.recordCircuit("10.0.0.1:80", true)
+
+	for i := 0; i < 10; i++ {
+		target, _, err := proxy.pickUpstream("api.internal", "80")
+		if err != nil || target != "10.0.0.2" {
+			t.Fatalf("Expected the healthy backend 10.0.0.2, got %s %v", target, err)
+		}
+	}
+
+	proxy.recordCircuit("10.0.0.2:80", true)
+	if _, _, err := proxy.pickUpstream("api.internal", "80"); !errors.Is(err, errNoUpstream) {
+		t.Errorf("Expected errNoUpstream, got %v", err)
+	}
+}
+
+func TestHandleHTTP_UpstreamPools(t *testing.T) {
+	newBackend := func(name string) string {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name)
+		}))
+		t.Cleanup(backend.Close)
+		return strings.TrimPrefix(backend.URL, "http://")
+	}
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.UpstreamPools = map[string][]Upstream{
+		"api.internal": {
+			{Addr: newBackend("a"), Weight: 3},
+			{Addr: newBackend("b"), Weight: 1},
+		},
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 40; i++ {
+		req := httptest.NewRequest("GET", "http://api.internal/v1", nil)
+		req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		counts[w.Body.String()]++
+	}
+
+	if counts["a"] != 30 || counts["b"] != 10 {
+		t.Errorf("Expected 30 and 10 requests, got %v", counts)
+	}
+}
+
+func TestHandleHTTP_UpstreamPoolUnavailable(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.CircuitBreaker = &CircuitBreaker{Threshold: 1, Cooldown: time.Minute}
+	proxy.UpstreamPools = map[string][]Upstream{"api.internal": {{Addr: "10.0.0.1", Weight: 1}}}
+	proxy.recordCircuit("10.0.0.1:80", true)
+
+	req := httptest.NewRequest("GET", "http://api.internal/v1", nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
+
+func TestHandleHTTPS_UpstreamPools(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.UpstreamPools = map[string][]Upstream{"api.internal": {{Addr: echoAddr, Weight: 1}}}
+	allowConnectPort(t, proxy, echoAddr)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	conn, reader, resp := openTunnel(t, server.Listener.Addr().String(), "api.internal:443")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("Expected the pooled backend to echo ping, got %q", buf)
+	}
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// upstreamErrorStatus maps a failure reaching an upstream to the status and
+// short reason reported to the client
+func upstreamErrorStatus(err error) (int, string) {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errCircuitOpen):
+		return http.StatusServiceUnavailable, "Upstream temporarily unavailable"
+	case errors.Is(err, errNoUpstream):
+		return http.StatusServiceUnavailable, "No healthy upstream available"
+	case errors.As(err, &dnsErr) && dnsErr.IsTimeout:
+		return http.StatusGatewayTimeout, "DNS lookup for upstream timed out"
+	case errors.As(err, &dnsErr):
+		return http.StatusBadGateway, "DNS lookup for upstream failed"
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout, "Upstream timed out"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return http.StatusBadGateway, "Connection refused by upstream"
+	case errors.Is(err, syscall.ECONNRESET):
+		return http.StatusBadGateway, "Connection reset by upstream"
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return http.StatusBadGateway, "Upstream unreachable"
+	}
+	return http.StatusBadGateway, "Error connecting to upstream"
+}
+
+// clientError carries the message reported to the client for the failure
+// it wraps
+type clientError struct {
+	message string
+	err     error
+}
+
+func (e *clientError) Error() string { return e.message }
+func (e *clientError) Unwrap() error { return e.err }
+
+// writeProxyError reports a failure reaching an upstream to the client
+func (ps *Server) writeProxyError(w http.ResponseWriter, r *http.Request, err error) {
+	status, reason := upstreamErrorStatus(err)
+	ps.handleError(w, r, &clientError{message: reason, err: err}, status)
+}
+
+// writeError reports a failed request to the client with message
+func (ps *Server) writeError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	ps.handleError(w, r, errors.New(message), status)
+}
+
+// handleError passes a failed request to ErrorHandler, if set
+func (ps *Server) handleError(w http.ResponseWriter, r *http.Request, err error, status int) {
+	if ps.ErrorHandler != nil {
+		ps.ErrorHandler(w, r, err, status)
+		return
+	}
+	DefaultErrorHandler(w, r, err, status)
+}
+
+// DefaultErrorHandler reports err's message to the client, as JSON when the
+// client accepts application/json and as plain text otherwise
+func DefaultErrorHandler(w http.ResponseWriter, r *http.Request, err error, status int) {
+	if !acceptsJSON(r) {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+		Code  int    `json:"code"`
+	}{err.Error(), status})
+}
+
+// acceptsJSON reports whether the Accept header names application/json
+func acceptsJSON(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(value, ",") {
+			mediaType, _, _ := strings.Cut(mediaRange, ";")
+			if strings.EqualFold(strings.TrimSpace(mediaType), "application/json") {
+				return true
+			}
+		}
+	}
+	return false
+}
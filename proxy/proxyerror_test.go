@@ -0,0 +1,298 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func dialError(err error) error {
+	return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)}
+}
+
+func TestUpstreamErrorStatus(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedReason string
+	}{
+		{"Connection refused", dialError(syscall.ECONNREFUSED), http.StatusBadGateway, "Connection refused by upstream"},
+		{"Connection reset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, http.StatusBadGateway, "Connection reset by upstream"},
+		{"Host unreachable", dialError(syscall.EHOSTUNREACH), http.StatusBadGateway, "Upstream unreachable"},
+		{"Network unreachable", dialError(syscall.ENETUNREACH), http.StatusBadGateway, "Upstream unreachable"},
+		{"Dial timeout", &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}, http.StatusGatewayTimeout, "Upstream timed out"},
+		{"DNS not found", &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "missing.example", IsNotFound: true}}, http.StatusBadGateway, "DNS lookup for upstream failed"},
+		{"DNS timeout", &net.DNSError{Err: "timeout", Name: "slow.example", IsTimeout: true}, http.StatusGatewayTimeout, "DNS lookup for upstream timed out"},
+		{"Client timeout", &url.Error{Op: "Get", URL: "http://example.com", Err: context.DeadlineExceeded}, http.StatusGatewayTimeout, "Upstream timed out"},
+		{"Other", errors.New("unexpected EOF"), http.StatusBadGateway, "Error connecting to upstream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, reason := upstreamErrorStatus(tt.err)
+			if status != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, status)
+			}
+			if reason != tt.expectedReason {
+				t.Errorf("Expected reason %q, got %q", tt.expectedReason, reason)
+			}
+		})
+	}
+}
+
+func TestWriteProxyError(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewProxyServer("admin", "password123", "8080").writeProxyError(w, httptest.NewRequest("GET", "http://example.com", nil), dialError(syscall.ECONNREFUSED))
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Expected status %d, got %d", http.StatusBadGateway, w.Code)
+	}
+	if body := strings.TrimSpace(w.Body.String()); body != "Connection refused by upstream" {
+		t.Errorf("Expected body %q, got %q", "Connection refused by upstream", body)
+	}
+}
+
+func TestWriteError_ContentNegotiation(t *testing.T) {
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"no Accept", "", "text/plain; charset=utf-8", "Connection refused by upstream\n"},
+		{"HTML", "text/html,*/*;q=0.8", "text/plain; charset=utf-8", "Connection refused by upstream\n"},
+		{"JSON", "application/json", "application/json", `{"error":"Connection refused by upstream","code":502}` + "\n"},
+		{"JSON among others", "text/html, Application/JSON;q=0.9", "application/json", `{"error":"Connection refused by upstream","code":502}` + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			NewProxyServer("admin", "password123", "8080").writeProxyError(w, req, dialError(syscall.ECONNREFUSED))
+
+			if w.Code != http.StatusBadGateway {
+				t.Errorf("Expected status %d, got %d", http.StatusBadGateway, w.Code)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Expected Content-Type %q, got %q", tt.contentType, got)
+			}
+			if w.Body.String() != tt.body {
+				t.Errorf("Expected body %q, got %q", tt.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleHTTP_ConnectionRefused(t *testing.T) {
+	// Find a port with nothing listening on it
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	req := httptest.NewRequest("GET", "http://"+addr, nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	w := httptest.NewRecorder()
+	proxy.handleHTTP(w, req)
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Expected status %d, got %d", http.StatusBadGateway, w.Code)
+	}
+	if body := strings.TrimSpace(w.Body.String()); body != "Connection refused by upstream" {
+		t.Errorf("Expected body %q, got %q", "Connection refused by upstream", body)
+	}
+}
+
+func TestHandleHTTPS_JSONError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	allowConnectPort(t, proxy, addr)
+	req := httptest.NewRequest("CONNECT", addr, nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	proxy.handleHTTPS(w, req)
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Expected status %d, got %d", http.StatusBadGateway, w.Code)
+	}
+	var body struct {
+		Error string `json:"error"`
+		Code  int    `json:"code"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected a JSON body, got %q: %v", w.Body.String(), err)
+	}
+	if body.Error != "Connection refused by upstream" || body.Code != http.StatusBadGateway {
+		t.Errorf("Unexpected error body %+v", body)
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	var gotErr error
+	var gotStatus int
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error, status int) {
+		gotErr, gotStatus = err, status
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(status)
+		io.WriteString(w, "<h1>Example Corp proxy: "+err.Error()+"</h1>")
+	}
+
+	req := httptest.NewRequest("GET", "http://"+addr, nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if gotStatus != http.StatusBadGateway || w.Code != http.StatusBadGateway {
+		t.Errorf("Expected handler and response status %d, got %d and %d", http.StatusBadGateway, gotStatus, w.Code)
+	}
+	if !errors.Is(gotErr, syscall.ECONNREFUSED) {
+		t.Errorf("Expected the error to wrap the dial failure, got %v", gotErr)
+	}
+	if body := w.Body.String(); body != "<h1>Example Corp proxy: Connection refused by upstream</h1>" {
+		t.Errorf("Expected the custom page, got %q", body)
+	}
+}
+
+func TestErrorHandler_CONNECT(t *testing.T) {
+	var gotStatus int
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error, status int) {
+		gotStatus = status
+		DefaultErrorHandler(w, r, err, status)
+	}
+
+	req := httptest.NewRequest("CONNECT", "example.com:25", nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if gotStatus != http.StatusForbidden {
+		t.Errorf("Expected handler to see status %d, got %d", http.StatusForbidden, gotStatus)
+	}
+	if body := strings.TrimSpace(w.Body.String()); body != "CONNECT to this port is not allowed" {
+		t.Errorf("Expected the default body, got %q", body)
+	}
+}
+
+func TestErrorHandler_Rejections(t *testing.T) {
+	connect := func() *http.Request {
+		req := httptest.NewRequest("CONNECT", "example.com:25", nil)
+		req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+		return req
+	}
+
+	tests := []struct {
+		name           string
+		setup          func(proxy *Server)
+		request        func() *http.Request
+		expectedStatus int
+	}{
+		{
+			name: "denied client",
+			setup: func(proxy *Server) {
+				_, network, _ := net.ParseCIDR("192.0.2.0/24")
+				proxy.DeniedCIDRs = []*net.IPNet{network}
+			},
+			request:        connect,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "client rate limit",
+			setup: func(proxy *Server) {
+				proxy.RateLimit, proxy.RateLimitBurst = 1, 1
+				proxy.ServeHTTP(httptest.NewRecorder(), connect())
+			},
+			request:        connect,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name: "user rate limit",
+			setup: func(proxy *Server) {
+				proxy.PolicyTags = map[string]string{"admin": "limited"}
+				proxy.Policies = map[string]Policy{"limited": {RequestsPerSecond: 1, Burst: 1}}
+				proxy.ServeHTTP(httptest.NewRecorder(), connect())
+			},
+			request:        connect,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:  "TRACE",
+			setup: func(proxy *Server) {},
+			request: func() *http.Request {
+				req := httptest.NewRequest("TRACE", "http://example.com/", nil)
+				req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+				return req
+			},
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:  "ambiguous target",
+			setup: func(proxy *Server) {},
+			request: func() *http.Request {
+				req := httptest.NewRequest("GET", "/path", nil)
+				req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+				return req
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "too many connections",
+			setup: func(proxy *Server) {
+				proxy.MaxConcurrentConnections = 1
+				proxy.acquireConnection()
+			},
+			request:        connect,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := NewProxyServer("admin", "password123", "8080")
+			tt.setup(proxy)
+			var gotStatus int
+			proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error, status int) {
+				gotStatus = status
+				DefaultErrorHandler(w, r, err, status)
+			}
+
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, tt.request())
+			if gotStatus != tt.expectedStatus || w.Code != tt.expectedStatus {
+				t.Errorf("Expected handler and response status %d, got %d and %d", tt.expectedStatus, gotStatus, w.Code)
+			}
+		})
+	}
+}
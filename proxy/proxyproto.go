@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyProtoV2Signature starts every PROXY protocol v2 header
+var proxyProtoV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtoV1MaxLength is the longest v1 header line, including CRLF
+const proxyProtoV1MaxLength = 107
+
+// errNoProxyHeader is returned for connections that do not start with a
+// PROXY protocol header
+var errNoProxyHeader = errors.New("missing PROXY protocol header")
+
+// proxyProtoListener reads a PROXY protocol header from each accepted
+// connection. The header is read on first use of the connection rather than
+// in Accept, so a slow client cannot hold up the others.
+type proxyProtoListener struct {
+	net.Listener
+	timeout time.Duration
+}
+
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtoConn{Conn: conn, reader: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+// proxyProtoConn reports the client address from its PROXY protocol header
+// as its remote address
+type proxyProtoConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+// readHeader consumes the PROXY protocol header, closing the connection if
+// it is missing or malformed
+func (c *proxyProtoConn) readHeader() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		}
+		c.remote, c.err = readProxyHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			log.Printf("Rejecting connection from %s: %v", c.Conn.RemoteAddr(), c.err)
+			c.Conn.Close()
+		}
+		// LOCAL and UNKNOWN headers carry no client address
+		if c.remote == nil {
+			c.remote = c.Conn.RemoteAddr()
+		}
+	})
+}
+
+func (c *proxyProtoConn) Read(p []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	return c.remote
+}
+
+// readProxyHeader reads a v1 or v2 PROXY protocol header from r and returns
+// the client address it carries, or nil when it carries none
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	if sig, err := r.Peek(len(proxyProtoV2Signature)); err == nil && bytes.Equal(sig, proxyProtoV2Signature) {
+		return readProxyHeaderV2(r)
+	}
+	if prefix, err := r.Peek(len("PROXY ")); err != nil || string(prefix) != "PROXY " {
+		return nil, errNoProxyHeader
+	}
+	return readProxyHeaderV1(r)
+}
+
+// readProxyHeaderV1 parses a line such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil || len(line) > proxyProtoV1MaxLength || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("malformed PROXY protocol v1 header")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed PROXY protocol v1 header %q", strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || (fields[1] == "TCP4") != (ip.To4() != nil) || err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("malformed PROXY protocol v1 header %q", strings.TrimSpace(string(line)))
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyHeaderV2 parses the binary v2 header
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyProtoV2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errors.New("truncated PROXY protocol v2 header")
+	}
+	versionCommand, family := header[12], header[13]
+	body := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, errors.New("truncated PROXY protocol v2 header")
+	}
+	if versionCommand>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", versionCommand>>4)
+	}
+
+	// LOCAL connections, such as health checks from the load balancer
+	// itself, carry no client
+	command := versionCommand & 0x0f
+	if command == 0x0 {
+		return nil, nil
+	}
+	if command != 0x1 {
+		return nil, fmt.Errorf("unsupported PROXY protocol v2 command %d", command)
+	}
+
+	// Addresses are followed by optional TLVs, which are ignored
+	switch family >> 4 {
+	case 0x1:
+		if len(body) < 12 {
+			return nil, errors.New("truncated PROXY protocol v2 IPv4 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x2:
+		if len(body) < 36 {
+			return nil, errors.New("truncated PROXY protocol v2 IPv6 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+	// Unspecified and Unix socket addresses say nothing about the client
+	return nil, nil
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyHeaderV2 builds a v2 PROXY header for a TCP connection from src
+func proxyHeaderV2(command byte, src *net.TCPAddr) string {
+	var addrs []byte
+	family := byte(0x11)
+	if ip4 := src.IP.To4(); ip4 != nil {
+		addrs = append(append(addrs, ip4...), 127, 0, 0, 1)
+		addrs = binary.BigEndian.AppendUint16(addrs, uint16(src.Port))
+		addrs = binary.BigEndian.AppendUint16(addrs, 8080)
+	} else {
+		family = 0x21
+		addrs = append(append(addrs, src.IP.To16()...), net.IPv6loopback...)
+		addrs = binary.BigEndian.AppendUint16(addrs, uint16(src.Port))
+		addrs = binary.BigEndian.AppendUint16(addrs, 8080)
+	}
+	header := append([]byte(nil), proxyProtoV2Signature...)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addrs)))
+	return string(append(header, addrs...))
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		expected string
+		wantErr  bool
+	}{
+		{"v1 TCP4", "PROXY TCP4 203.0.113.7 10.0.0.1 56324 8080\r\n", "203.0.113.7:56324", false},
+		{"v1 TCP6", "PROXY TCP6 2001:db8::7 2001:db8::1 56324 8080\r\n", "[2001:db8::7]:56324", false},
+		{"v1 UNKNOWN", "PROXY UNKNOWN\r\n", "", false},
+		{"v1 family mismatch", "PROXY TCP4 2001:db8::7 10.0.0.1 56324 8080\r\n", "", true},
+		{"v1 bad port", "PROXY TCP4 203.0.113.7 10.0.0.1 99999 8080\r\n", "", true},
+		{"v1 missing CRLF", "PROXY TCP4 203.0.113.7 10.0.0.1 56324 8080\n", "", true},
+		{"v1 too long", "PROXY TCP4 " + strings.Repeat(" ", 100) + "203.0.113.7 10.0.0.1 56324 8080\r\n", "", true},
+		{"v2 TCP4", proxyHeaderV2(0x1, &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 56324}), "203.0.113.7:56324", false},
+		{"v2 TCP6", proxyHeaderV2(0x1, &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 56324}), "[2001:db8::7]:56324", false},
+		{"v2 LOCAL", proxyHeaderV2(0x0, &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 56324}), "", false},
+		{"v2 truncated", proxyHeaderV2(0x1, &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 56324})[:20], "", true},
+		{"no header", "GET http://example.com/ HTTP/1.1\r\n\r\n", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReader(strings.NewReader(tt.header + "rest"))
+			addr, err := readProxyHeader(reader)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != tt.expected {
+				t.Errorf("Expected address %q, got %q", tt.expected, got)
+			}
+			if rest, _ := io.ReadAll(reader); string(rest) != "rest" {
+				t.Errorf("Expected the header to be consumed exactly, left %q", rest)
+			}
+		})
+	}
+}
+
+func TestProxyProtocol_ClientAddress(t *testing.T) {
+	logs := captureLog(t)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	proxy := NewProxyServer("admin", "password123", "0")
+	proxy.RandomPort = true
+	proxy.BindAddress = "127.0.0.1"
+	proxy.ProxyProtocol = true
+	_, allowed, _ := net.ParseCIDR("203.0.113.0/24")
+	proxy.AllowedCIDRs = []*net.IPNet{allowed}
+	go proxy.Start()
+	defer proxy.Shutdown(context.Background())
+	proxyAddr := waitForAddr(t, proxy).String()
+
+	request := func(header string) (*http.Response, error) {
+		conn, err := net.Dial("tcp", proxyAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		fmt.Fprintf(conn, "%sGET %s/ HTTP/1.1\r\nHost: %s\r\nProxy-Authorization: %s\r\n\r\n",
+			header, backend.URL, backend.Listener.Addr(), CreateBasicAuth("admin", "password123"))
+		return http.ReadResponse(bufio.NewReader(conn), nil)
+	}
+
+	t.Run("v1 header from allowed client", func(t *testing.T) {
+		resp, err := request("PROXY TCP4 203.0.113.7 127.0.0.1 56324 8080\r\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		waitForLog(t, logs, "203.0.113.7:56324 GET "+backend.URL)
+	})
+
+	t.Run("v2 header from other client", func(t *testing.T) {
+		resp, err := request(proxyHeaderV2(0x1, &net.TCPAddr{IP: net.ParseIP("198.51.100.9"), Port: 40000}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected status %d, got %d", http.StatusForbidden, resp.StatusCode)
+		}
+	})
+
+	t.Run("missing header", func(t *testing.T) {
+		if _, err := request(""); err == nil {
+			t.Error("Expected the connection to be closed without a response")
+		}
+		waitForLog(t, logs, "missing PROXY protocol header")
+	})
+}
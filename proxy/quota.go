@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// errByteQuotaExceeded is returned by counting readers once a connection has
+// moved more than MaxConnectionBytes
+var errByteQuotaExceeded = errors.New("connection byte quota exceeded")
+
+// byteQuota caps the total bytes moved in both directions of one connection
+type byteQuota struct {
+	limit int64
+	used  atomic.Int64
+}
+
+// newByteQuota returns a quota of limit bytes, or nil for no limit
+func newByteQuota(limit int64) *byteQuota {
+	if limit <= 0 {
+		return nil
+	}
+	return &byteQuota{limit: limit}
+}
+
+// take records n bytes and returns how many of them fit in the quota. A nil
+// quota accepts everything.
+func (q *byteQuota) take(n int) int {
+	if q == nil {
+		return n
+	}
+	used := q.used.Add(int64(n))
+	if used <= q.limit {
+		return n
+	}
+	over := used - q.limit
+	if over >= int64(n) {
+		return 0
+	}
+	return n - int(over)
+}
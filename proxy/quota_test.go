@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestByteQuotaTake(t *testing.T) {
+	quota := newByteQuota(10)
+	tests := []struct {
+		n        int
+		expected int
+	}{
+		{4, 4},
+		{4, 4},
+		{4, 2},
+		{4, 0},
+	}
+	for _, tt := range tests {
+		if result := quota.take(tt.n); result != tt.expected {
+			t.Errorf("Expected %d, got %d", tt.expected, result)
+		}
+	}
+
+	if result := newByteQuota(0).take(1 << 20); result != 1<<20 {
+		t.Errorf("Expected unlimited quota to accept everything, got %d", result)
+	}
+}
+
+func TestConnectionQuota_Tunnel(t *testing.T) {
+	logs := captureLog(t)
+	echoAddr := startEchoServer(t)
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.MaxConnectionBytes = 100
+	allowConnectPort(t, proxy, echoAddr)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	conn, reader, resp := openTunnel(t, server.Listener.Addr().String(), echoAddr)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// 80 bytes up plus their echo exceeds the 100 byte quota
+	payload := strings.Repeat("x", 80)
+	conn.Write([]byte(payload))
+	echoed, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Expected tunnel to be closed, got %v", err)
+	}
+	if len(echoed) != 20 {
+		t.Errorf("Expected 20 echoed bytes before termination, got %d", len(echoed))
+	}
+
+	waitForLog(t, logs, "connection byte quota exceeded")
+}
+
+func TestConnectionQuota_HTTP(t *testing.T) {
+	logs := captureLog(t)
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("y"), 4096))
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.MaxConnectionBytes = 1024
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	proxyURL, _ := url.Parse(server.URL)
+	proxyURL.User = url.UserPassword("admin", "password123")
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	resp, err := client.Get(backendServer.URL)
+	if err == nil {
+		_, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if err == nil {
+		t.Error("Expected the response to be cut off after the quota")
+	}
+
+	waitForLog(t, logs, "connection byte quota exceeded")
+}
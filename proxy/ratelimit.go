@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiterIdleTimeout is how long an unused limiter is kept before removal
+const limiterIdleTimeout = 10 * time.Minute
+
+// limiterSet keeps a token bucket limiter per key. Limiters that have not
+// been used for limiterIdleTimeout are swept so the map stays bounded.
+type limiterSet struct {
+	mu        sync.Mutex
+	limiters  map[string]*limiterEntry
+	lastSweep time.Time
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newLimiterSet creates an empty limiter set
+func newLimiterSet() *limiterSet {
+	return &limiterSet{limiters: make(map[string]*limiterEntry)}
+}
+
+// allow reports whether a request for key at time now fits within the given
+// rate. When it does not, it also returns how long until a request would be
+// allowed. A rate of zero or less means unlimited.
+func (ls *limiterSet) allow(key string, perSecond float64, burst int, now time.Time) (bool, time.Duration) {
+	if perSecond <= 0 {
+		return true, 0
+	}
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(perSecond)))
+	}
+
+	ls.mu.Lock()
+	if now.Sub(ls.lastSweep) > limiterIdleTimeout {
+		ls.sweep(now)
+	}
+	entry, ok := ls.limiters[key]
+	if !ok {
+		entry = &limiterEntry{limiter: rate.NewLimiter(rate.Limit(perSecond), burst)}
+		ls.limiters[key] = entry
+	} else if entry.limiter.Limit() != rate.Limit(perSecond) || entry.limiter.Burst() != burst {
+		// The policy changed since the limiter was created
+		entry.limiter.SetLimitAt(now, rate.Limit(perSecond))
+		entry.limiter.SetBurstAt(now, burst)
+	}
+	entry.lastSeen = now
+	ls.mu.Unlock()
+
+	reservation := entry.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// sweep removes limiters idle since before limiterIdleTimeout. The caller
+// must hold ls.mu.
+func (ls *limiterSet) sweep(now time.Time) {
+	for key, entry := range ls.limiters {
+		if now.Sub(entry.lastSeen) > limiterIdleTimeout {
+			delete(ls.limiters, key)
+		}
+	}
+	ls.lastSweep = now
+}
+
+// len returns the number of tracked limiters
+func (ls *limiterSet) len() int {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return len(ls.limiters)
+}
+
+// writeTooManyRequests rejects a rate limited request with a Retry-After hint
+func (ps *Server) writeTooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	ps.writeError(w, r, "Too Many Requests", http.StatusTooManyRequests)
+}
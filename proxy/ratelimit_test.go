@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientRateLimit(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.RateLimit = 5
+	proxy.RateLimitBurst = 5
+
+	// Send requests rapidly from a single client
+	limited := 0
+	for i := 0; i < 20; i++ {
+		req := httptest.NewRequest("GET", backendServer.URL, nil)
+		req.RemoteAddr = "192.0.2.1:5000"
+		req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+		w := httptest.NewRecorder()
+
+		proxy.ServeHTTP(w, req)
+
+		if w.Code == http.StatusTooManyRequests {
+			limited++
+			if w.Header().Get("Retry-After") == "" {
+				t.Error("Expected Retry-After header on 429 response")
+			}
+		}
+	}
+
+	if limited == 0 {
+		t.Error("Expected some requests to be rate limited")
+	}
+	if limited > 15 {
+		t.Errorf("Expected the burst to be allowed, but %d of 20 were limited", limited)
+	}
+
+	// A different client has its own bucket
+	req := httptest.NewRequest("GET", backendServer.URL, nil)
+	req.RemoteAddr = "192.0.2.2:5000"
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected other client to be allowed, got status %d", w.Code)
+	}
+}
+
+func TestLimiterSetCleanup(t *testing.T) {
+	limiters := newLimiterSet()
+	start := time.Now()
+
+	limiters.allow("192.0.2.1", 1, 1, start)
+	limiters.allow("192.0.2.2", 1, 1, start)
+	if limiters.len() != 2 {
+		t.Fatalf("Expected 2 limiters, got %d", limiters.len())
+	}
+
+	// A request after the idle timeout sweeps the stale limiters
+	limiters.allow("192.0.2.3", 1, 1, start.Add(limiterIdleTimeout+time.Second))
+	if limiters.len() != 1 {
+		t.Errorf("Expected idle limiters to be removed, %d remain", limiters.len())
+	}
+}
+
+func TestLimiterSetRetryAfter(t *testing.T) {
+	limiters := newLimiterSet()
+	now := time.Now()
+
+	if ok, _ := limiters.allow("client", 1, 1, now); !ok {
+		t.Fatal("First request should be allowed")
+	}
+	ok, retryAfter := limiters.allow("client", 1, 1, now)
+	if ok {
+		t.Fatal("Second request should be limited")
+	}
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("Expected retry after within 1s, got %v", retryAfter)
+	}
+
+	// Once the token refills the request is allowed again
+	if ok, _ := limiters.allow("client", 1, 1, now.Add(time.Second)); !ok {
+		t.Error("Request should be allowed after the bucket refills")
+	}
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ReloadConfig reads the config file at path again and replaces the accepted
+// users, their domain allowlists and policy tags, and the allowed and denied
+// CIDRs in one step. Requests that already authenticated are unaffected, and
+// the current settings stay in place if the file is invalid. The CRL is
+// reloaded too when ClientCRLFile is set. Other settings only take effect on
+// restart.
+func (ps *Server) ReloadConfig(path string) error {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	allowed, err := ParseCIDRs(cfg.AllowedCIDRs)
+	if err != nil {
+		return err
+	}
+	denied, err := ParseCIDRs(cfg.DeniedCIDRs)
+	if err != nil {
+		return err
+	}
+
+	users := make(map[string]*credential, len(cfg.Users))
+	tags := make(map[string]string)
+	for _, user := range cfg.Users {
+		if ps.HashedCredentials {
+			if _, err := bcrypt.Cost([]byte(user.Password)); err != nil {
+				return fmt.Errorf("password of user %q is not a bcrypt hash: %w", user.Username, err)
+			}
+		}
+		users[user.Username] = &credential{
+			password:       user.Password,
+			allowedDomains: domainSet(user.AllowedDomains),
+		}
+		if user.Tag != "" {
+			tags[user.Username] = user.Tag
+		}
+	}
+
+	if ps.ClientCRLFile != "" {
+		if err := ps.ReloadCRL(); err != nil {
+			return err
+		}
+	}
+
+	ps.usersMu.Lock()
+	ps.users = users
+	ps.PolicyTags = tags
+	ps.AllowedCIDRs, ps.DeniedCIDRs = allowed, denied
+	ps.usersMu.Unlock()
+	return nil
+}
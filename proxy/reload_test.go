@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+// proxiedStatus sends a GET for rawURL through the proxy at proxyAddr and
+// returns the response status
+func proxiedStatus(t *testing.T, proxyAddr, rawURL, username, password string) int {
+	t.Helper()
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nProxy-Authorization: %s\r\nConnection: close\r\n\r\n",
+		rawURL, target.Host, CreateBasicAuth(username, password))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestReloadConfig_SwapsCredentials(t *testing.T) {
+	t.Setenv("PROXY_USERNAME", "")
+	t.Setenv("PROXY_PASSWORD", "")
+	t.Setenv("PROXY_PORT", "")
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(entered)
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backendServer.Close()
+
+	port := freePort(t)
+	path := writeConfigFile(t, "config.yaml", fmt.Sprintf(`port: %q
+users:
+  - username: alice
+    password: old-secret
+`, port))
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := NewProxyServerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go proxy.Start()
+	defer proxy.Shutdown(context.Background())
+	proxyAddr := waitForAddr(t, proxy).String()
+
+	// A request authenticated with the old password is in flight during the
+	// reload and must still complete
+	inFlight := make(chan int, 1)
+	go func() {
+		inFlight <- proxiedStatus(t, proxyAddr, backendServer.URL+"/slow", "alice", "old-secret")
+	}()
+	<-entered
+
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(`port: %q
+users:
+  - username: alice
+    password: new-secret
+`, port)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := proxy.ReloadConfig(path); err != nil {
+		t.Fatalf("ReloadConfig returned error: %v", err)
+	}
+
+	if status := proxiedStatus(t, proxyAddr, backendServer.URL, "alice", "old-secret"); status != http.StatusProxyAuthRequired {
+		t.Errorf("Expected old password to get %d, got %d", http.StatusProxyAuthRequired, status)
+	}
+	if status := proxiedStatus(t, proxyAddr, backendServer.URL, "alice", "new-secret"); status != http.StatusOK {
+		t.Errorf("Expected new password to get %d, got %d", http.StatusOK, status)
+	}
+
+	close(release)
+	if status := <-inFlight; status != http.StatusOK {
+		t.Errorf("Expected in-flight request to get %d, got %d", http.StatusOK, status)
+	}
+}
+
+func TestReloadConfig_KeepsUsersOnError(t *testing.T) {
+	t.Setenv("PROXY_USERNAME", "")
+	t.Setenv("PROXY_PASSWORD", "")
+	t.Setenv("PROXY_PORT", "")
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	path := writeConfigFile(t, "config.yaml", `port: "8080"
+users: []
+`)
+	if err := proxy.ReloadConfig(path); err == nil {
+		t.Error("Expected an error for a config without users")
+	}
+	if !proxy.checkCredentials("admin", "password123") {
+		t.Error("Expected existing credentials to keep working")
+	}
+}
+
+func TestReloadConfig_AllowedDomains(t *testing.T) {
+	t.Setenv("PROXY_USERNAME", "")
+	t.Setenv("PROXY_PASSWORD", "")
+	t.Setenv("PROXY_PORT", "")
+
+	proxy := NewProxyServer("alice", "secret", "8080")
+	path := writeConfigFile(t, "config.yaml", `port: "8080"
+users:
+  - username: alice
+    password: secret
+    allowed_domains: [example.com]
+`)
+	if err := proxy.ReloadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	if !proxy.userMayReach("alice", "api.example.com:443") {
+		t.Error("Expected alice to reach a subdomain of example.com")
+	}
+	if proxy.userMayReach("alice", "example.org:443") {
+		t.Error("Expected alice to be denied example.org")
+	}
+}
+
+func TestReloadConfig_PolicyTagsAndCIDRs(t *testing.T) {
+	t.Setenv("PROXY_USERNAME", "")
+	t.Setenv("PROXY_PASSWORD", "")
+	t.Setenv("PROXY_PORT", "")
+
+	proxy := NewProxyServer("alice", "secret", "8080")
+	proxy.PolicyTags = map[string]string{"alice": "free"}
+	path := writeConfigFile(t, "config.yaml", `port: "8080"
+users:
+  - username: alice
+    password: secret
+    tag: premium
+  - username: bob
+    password: secret
+allowed_cidrs: [10.0.0.0/8]
+denied_cidrs: [10.1.0.0/16]
+`)
+	if err := proxy.ReloadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+
+	tags := []struct {
+		user string
+		tag  string
+	}{
+		{user: "alice", tag: "premium"},
+		{user: "bob", tag: ""},
+	}
+	for _, tt := range tags {
+		if tag := proxy.policyTag(tt.user); tag != tt.tag {
+			t.Errorf("Expected tag %q for %s, got %q", tt.tag, tt.user, tag)
+		}
+	}
+
+	ips := []struct {
+		ip      string
+		allowed bool
+	}{
+		{ip: "10.2.3.4", allowed: true},
+		{ip: "10.1.2.3", allowed: false},
+		{ip: "192.168.1.1", allowed: false},
+	}
+	for _, tt := range ips {
+		if allowed := proxy.ipAllowed(net.ParseIP(tt.ip)); allowed != tt.allowed {
+			t.Errorf("Expected %s allowed %v, got %v", tt.ip, tt.allowed, allowed)
+		}
+	}
+}
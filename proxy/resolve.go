@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// resolveHost returns the addresses of host
+func (ps *Server) resolveHost(host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ps.DialTimeout)
+	defer cancel()
+	if ps.lookupHost != nil {
+		return ps.lookupHost(ctx, host)
+	}
+	return ps.resolver().LookupHost(ctx, host)
+}
+
+// resolver returns the configured Resolver or the system resolver
+func (ps *Server) resolver() *net.Resolver {
+	if ps.Resolver != nil {
+		return ps.Resolver
+	}
+	return net.DefaultResolver
+}
+
+// dialConnect opens the upstream connection of a CONNECT tunnel. The
+// circuit breaker check and resolution are shared between concurrent
+// tunnels but each gets its own connection. Targets whose circuit breaker
+// is open are not dialed.
+func (ps *Server) dialConnect(target string) (net.Conn, error) {
+	addrs, err := ps.prepareConnect(target)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := ps.dialTarget(target, addrs)
+	ps.recordCircuit(target, err != nil)
+	return conn, err
+}
+
+// prepareConnect checks the circuit breaker of target and resolves its host,
+// returning the addresses to dial, or none when the tunnel goes through the
+// parent proxy. With CoalesceConnectLookups concurrent tunnels to the same
+// target share one check and one resolution, so a burst of tunnels to a
+// freshly changed name triggers a single lookup. Tunnels sharing a check
+// also share a half-open trial.
+func (ps *Server) prepareConnect(target string) ([]string, error) {
+	prepare := func() (interface{}, error) {
+		if !ps.circuitAllows(target) {
+			return nil, errCircuitOpen
+		}
+		if ps.viaParent(target) {
+			return []string(nil), nil
+		}
+		host, _, err := net.SplitHostPort(target)
+		if err == nil {
+			var addrs []string
+			if addrs, err = ps.resolveHost(host); err == nil {
+				return addrs, nil
+			}
+		}
+		ps.recordCircuit(target, true)
+		return nil, err
+	}
+	if !ps.CoalesceConnectLookups {
+		addrs, err := prepare()
+		if err != nil {
+			return nil, err
+		}
+		return addrs.([]string), nil
+	}
+
+	addrs, err, _ := ps.lookupGroup.Do(target, prepare)
+	if err != nil {
+		return nil, err
+	}
+	return addrs.([]string), nil
+}
+
+// dialTarget connects to target through the parent proxy, or directly to the
+// first of addrs that accepts
+func (ps *Server) dialTarget(target string, addrs []string) (net.Conn, error) {
+	if ps.viaParent(target) {
+		return ps.dialParent(target)
+	}
+
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := ps.dialTimeoutFor(target)
+	var lastErr error
+	for _, addr := range addrs {
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(addr, port), timeout)
+		ps.observeLatency(ps.dialLatency, target, time.Since(start), err)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = &net.DNSError{Err: "no addresses found", Name: host, IsNotFound: true}
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,287 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestConnectLookupCoalescing(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	_, echoPort, _ := net.SplitHostPort(echoAddr)
+
+	tests := []struct {
+		name            string
+		coalesce        bool
+		expectedLookups int64
+	}{
+		{"coalesced", true, 1},
+		{"independent", false, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.CoalesceConnectLookups = tt.coalesce
+			allowConnectPort(t, proxy, echoAddr)
+
+			var lookups atomic.Int64
+			release := make(chan struct{})
+			proxy.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+				lookups.Add(1)
+				<-release
+				return []string{"127.0.0.1"}, nil
+			}
+
+			server := httptest.NewServer(proxy)
+			defer server.Close()
+			proxyAddr := server.Listener.Addr().String()
+			target := net.JoinHostPort("echo.test", echoPort)
+
+			const clients = 10
+			var wg sync.WaitGroup
+			errs := make(chan error, clients)
+			for i := 0; i < clients; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs <- tunnelRoundTrip(proxyAddr, target, fmt.Sprintf("client %d", i))
+				}(i)
+			}
+
+			// Give every CONNECT time to reach the lookup before it completes
+			time.Sleep(200 * time.Millisecond)
+			close(release)
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				if err != nil {
+					t.Error(err)
+				}
+			}
+			if got := lookups.Load(); got != tt.expectedLookups {
+				t.Errorf("Expected %d lookups, got %d", tt.expectedLookups, got)
+			}
+		})
+	}
+}
+
+func TestConnectLookupCoalescing_CircuitBreaker(t *testing.T) {
+	tests := []struct {
+		name             string
+		coalesce         bool
+		expectedFailures int
+	}{
+		{"coalesced", true, 1},
+		{"independent", false, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.CoalesceConnectLookups = tt.coalesce
+			proxy.CircuitBreaker = &CircuitBreaker{Threshold: 100, Cooldown: time.Minute}
+
+			release := make(chan struct{})
+			proxy.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+				<-release
+				return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			}
+
+			const clients = 10
+			target := "down.test:443"
+			var wg sync.WaitGroup
+			for i := 0; i < clients; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					proxy.dialConnect(target)
+				}()
+			}
+
+			// Give every dial time to reach the lookup before it fails
+			time.Sleep(200 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			proxy.circuits.mu.Lock()
+			failures := proxy.circuits.hosts[target].failures
+			proxy.circuits.mu.Unlock()
+			if failures != tt.expectedFailures {
+				t.Errorf("Expected %d recorded failures, got %d", tt.expectedFailures, failures)
+			}
+		})
+	}
+}
+
+// tunnelRoundTrip opens its own tunnel to target and checks that payload is
+// echoed back over it
+func tunnelRoundTrip(proxyAddr, target, payload string) error {
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\nProxy-Authorization: %s\r\n\r\n",
+		target, target, CreateBasicAuth("admin", "password123"))
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	fmt.Fprintf(conn, "%s\n", payload)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if line != payload+"\n" {
+		return fmt.Errorf("Expected echo %q, got %q", payload, line)
+	}
+	return nil
+}
+
+func TestResolveHost_IPLiteral(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		t.Errorf("Unexpected lookup of %s", host)
+		return nil, nil
+	}
+
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		addrs, err := proxy.resolveHost(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(addrs) != 1 || addrs[0] != host {
+			t.Errorf("Expected [%s], got %v", host, addrs)
+		}
+	}
+}
+
+// startMockDNS starts a UDP DNS server answering A queries for name, or for
+// every name when it is empty, with 127.0.0.1 and everything else with no
+// records. It returns a resolver that sends all its queries there.
+func startMockDNS(t *testing.T, name string) *net.Resolver {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if err := query.Unpack(buf[:n]); err != nil || len(query.Questions) == 0 {
+				continue
+			}
+			q := query.Questions[0]
+			reply := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, Authoritative: true},
+				Questions: query.Questions,
+			}
+			matches := name == "" || q.Name.String() == name+"."
+			if matches && q.Type == dnsmessage.TypeA {
+				reply.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+				}}
+			} else if !matches {
+				reply.RCode = dnsmessage.RCodeNameError
+			}
+			packed, err := reply.Pack()
+			if err != nil {
+				continue
+			}
+			pc.WriteTo(packed, addr)
+		}
+	}()
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", pc.LocalAddr().String())
+		},
+	}
+}
+
+func TestCustomResolver_Connect(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	_, echoPort, _ := net.SplitHostPort(echoAddr)
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.Resolver = startMockDNS(t, "backend.proxy-test")
+	allowConnectPort(t, proxy, echoAddr)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	conn, reader, resp := openTunnel(t, server.Listener.Addr().String(), net.JoinHostPort("backend.proxy-test", echoPort))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprint(conn, "ping")
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(reader, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("Expected echo %q, got %q (%v)", "ping", buf, err)
+	}
+}
+
+func TestCustomResolver_HTTP(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Host)
+	}))
+	defer backend.Close()
+	_, backendPort, _ := net.SplitHostPort(backend.Listener.Addr().String())
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.Resolver = startMockDNS(t, "backend.proxy-test")
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	target := "http://" + net.JoinHostPort("backend.proxy-test", backendPort) + "/"
+	req, _ := http.NewRequest("GET", target, nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req.WriteProxy(conn)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != net.JoinHostPort("backend.proxy-test", backendPort) {
+		t.Errorf("Expected backend to see host %q, got %q", net.JoinHostPort("backend.proxy-test", backendPort), body)
+	}
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultRetryBackoff is the delay before the first retry of a failed
+// upstream request. Each further retry waits twice as long.
+const defaultRetryBackoff = 100 * time.Millisecond
+
+// isIdempotent reports whether a request with method may be sent again
+// after a failure without changing its effect
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryableError reports whether err is a transient connection failure
+// worth retrying. Timeouts are not retried since each attempt already
+// waited the full timeout.
+func retryableError(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryableBody reads body into memory so it can be sent again. If the
+// memory budget runs out first it returns a reader streaming the body once
+// and false.
+func retryableBody(body io.Reader, budget *memoryBudget) (io.Reader, bool, error) {
+	if body == nil {
+		return nil, true, nil
+	}
+	buffered, err := bufferBody(body, budget)
+	if err != nil {
+		return nil, false, err
+	}
+	if !buffered.complete {
+		return buffered.Reader(), false, nil
+	}
+	return bytes.NewReader(buffered.data), true, nil
+}
+
+// expectsContinue reports whether the client waits for 100 Continue before
+// sending the body of r
+func expectsContinue(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+}
+
+// doWithRetry sends req, retrying transient failures up to retries times
+// with exponential backoff. req must have GetBody set if it has a body.
+func (ps *Server) doWithRetry(client *http.Client, req *http.Request, retries int) (*http.Response, error) {
+	backoff := ps.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Do(req)
+		if err == nil || attempt >= retries || !retryableError(err) {
+			return resp, err
+		}
+		log.Printf("Retrying %s %s in %v after error: %v", req.Method, req.URL, backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		backoff *= 2
+
+		req = req.Clone(req.Context())
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
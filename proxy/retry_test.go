@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFlakyBackend returns a server that drops the connection of its first
+// failures requests and then echoes request bodies
+func newFlakyBackend(t *testing.T, failures int64) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) <= failures {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+func TestRetryIdempotentRequests(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		maxRetries     int
+		failures       int64
+		expectedStatus int
+		expectedHits   int64
+	}{
+		{"GET succeeds after failures", "GET", "", 3, 2, http.StatusOK, 3},
+		{"GET gives up after retries", "GET", "", 2, 3, http.StatusBadGateway, 3},
+		{"PUT body is replayed", "PUT", "payload", 1, 1, http.StatusOK, 2},
+		{"POST is never retried", "POST", "payload", 3, 1, http.StatusBadGateway, 1},
+		{"Retries disabled", "GET", "", 0, 1, http.StatusBadGateway, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, hits := newFlakyBackend(t, tt.failures)
+
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.MaxRetries = tt.maxRetries
+			proxy.RetryBackoff = time.Millisecond
+
+			req := httptest.NewRequest(tt.method, backend.URL, strings.NewReader(tt.body))
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if result := hits.Load(); result != tt.expectedHits {
+				t.Errorf("Expected %d upstream attempts, got %d", tt.expectedHits, result)
+			}
+			if tt.expectedStatus == http.StatusOK && w.Body.String() != tt.body {
+				t.Errorf("Expected body %q, got %q", tt.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRetry_BodyOverMemoryBudget(t *testing.T) {
+	backend, hits := newFlakyBackend(t, 1)
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.MaxRetries = 3
+	proxy.RetryBackoff = time.Millisecond
+	proxy.MaxRequestMemory = 4
+
+	// The body cannot be buffered for replay, so the request is sent once
+	req := httptest.NewRequest("PUT", backend.URL, strings.NewReader("larger than the budget"))
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Expected status %d, got %d", http.StatusBadGateway, w.Code)
+	}
+	if result := hits.Load(); result != 1 {
+		t.Errorf("Expected 1 upstream attempt, got %d", result)
+	}
+}
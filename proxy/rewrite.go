@@ -0,0 +1,22 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+)
+
+// rewriteHost maps addr, a host with an optional port, to its destination in
+// HostRewrites. The original port is kept unless the destination names one.
+func (ps *Server) rewriteHost(addr string) (string, bool) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, ""
+	}
+	for from, to := range ps.HostRewrites {
+		if !strings.EqualFold(from, host) {
+			continue
+		}
+		return withPort(to, port), true
+	}
+	return addr, false
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRewriteHost(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.HostRewrites = map[string]string{
+		"api.prod":      "api.staging",
+		"db.prod":       "127.0.0.1:5432",
+		"v6.prod":       "[::1]",
+		"Mixed.Example": "mixed.staging",
+	}
+
+	tests := []struct {
+		addr      string
+		expected  string
+		rewritten bool
+	}{
+		{"api.prod", "api.staging", true},
+		{"api.prod:8443", "api.staging:8443", true},
+		{"API.PROD:80", "api.staging:80", true},
+		{"db.prod:3306", "127.0.0.1:5432", true},
+		{"v6.prod:443", "[::1]:443", true},
+		{"mixed.example", "mixed.staging", true},
+		{"api.prod.example.com", "api.prod.example.com", false},
+		{"example.com:443", "example.com:443", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			got, rewritten := proxy.rewriteHost(tt.addr)
+			if got != tt.expected || rewritten != tt.rewritten {
+				t.Errorf("Expected %s (%v), got %s (%v)", tt.expected, tt.rewritten, got, rewritten)
+			}
+		})
+	}
+}
+
+func TestHandleHTTP_HostRewrites(t *testing.T) {
+	var gotHost string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost = r.Host
+		io.WriteString(w, "staging")
+	}))
+	defer backend.Close()
+	backendAddr := strings.TrimPrefix(backend.URL, "http://")
+
+	tests := []struct {
+		name          string
+		rawURL        string
+		rewriteHeader bool
+		expectedHost  string
+	}{
+		{"rewritten host keeps Host header", "http://api.prod/v1", false, "api.prod"},
+		{"rewritten host with Host header", "http://api.prod/v1", true, backendAddr},
+		{"passthrough host", backend.URL + "/v1", false, backendAddr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.HostRewrites = map[string]string{"api.prod": backendAddr}
+			proxy.RewriteHostHeader = tt.rewriteHeader
+
+			gotHost = ""
+			req := httptest.NewRequest("GET", tt.rawURL, nil)
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK || w.Body.String() != "staging" {
+				t.Fatalf("Expected the rewritten upstream to answer, got %d %q", w.Code, w.Body.String())
+			}
+			if gotHost != tt.expectedHost {
+				t.Errorf("Expected Host %s, got %s", tt.expectedHost, gotHost)
+			}
+		})
+	}
+}
+
+func TestHandleHTTPS_HostRewrites(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.HostRewrites = map[string]string{"api.prod": echoAddr}
+	allowConnectPort(t, proxy, echoAddr)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	conn, reader, resp := openTunnel(t, server.Listener.Addr().String(), "api.prod:443")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("Expected the rewritten target to echo ping, got %q", buf)
+	}
+}
+
+func TestHostRewrites_BlockedRequestedHost(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "reached")
+	}))
+	defer backend.Close()
+	backendAddr := strings.TrimPrefix(backend.URL, "http://")
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.HostRewrites = map[string]string{"blocked.example": backendAddr}
+	proxy.BlockedDomains = map[string]struct{}{"blocked.example": {}}
+	allowConnectPort(t, proxy, backendAddr)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	t.Run("HTTP", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://blocked.example/", nil)
+		req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+		}
+	})
+
+	t.Run("CONNECT", func(t *testing.T) {
+		_, _, resp := openTunnel(t, server.Listener.Addr().String(), "blocked.example:443")
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected status %d, got %d", http.StatusForbidden, resp.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Defaults for the rotating access log file
+const (
+	DefaultLogMaxBytes = 100 << 20
+	DefaultLogKeep     = 5
+)
+
+// RotatingFile is an io.Writer appending to a file that is rotated once it
+// would grow past maxBytes. Rotated files are renamed to path.1, path.2 and
+// so on, and only the keep most recent are kept. Each write goes entirely
+// to one file, so log lines are never split.
+type RotatingFile struct {
+	path     string
+	maxBytes int64
+	keep     int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotatingFile opens path for appending, creating it if needed
+func NewRotatingFile(path string, maxBytes int64, keep int) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, maxBytes: maxBytes, keep: keep}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file, rf.size = file, info.Size()
+	return nil
+}
+
+// Write appends p, rotating first if p would not fit in the current file
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the old files up by one, dropping the oldest, and starts
+// a new file at path
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	os.Remove(rf.backup(rf.keep))
+	for i := rf.keep - 1; i >= 1; i-- {
+		os.Rename(rf.backup(i), rf.backup(i+1))
+	}
+	if rf.keep > 0 {
+		if err := os.Rename(rf.path, rf.backup(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(rf.path); err != nil {
+		return err
+	}
+	return rf.open()
+}
+
+// backup returns the name of the i-th most recent rotated file
+func (rf *RotatingFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", rf.path, i)
+}
+
+// Close closes the current file
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.file.Close()
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	file, err := NewRotatingFile(path, 512, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	logger := NewJSONLogger(file)
+	for i := 0; i < 50; i++ {
+		logger.Log(AccessLogEntry{Time: time.Now(), ClientIP: "192.0.2.1", Method: "GET", Host: "example.com", Status: 200})
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("Expected %s to exist, got %v", filepath.Base(name), err)
+		}
+		if info.Size() > 512 {
+			t.Errorf("Expected %s to stay within 512 bytes, got %d", filepath.Base(name), info.Size())
+		}
+		assertJSONLines(t, name)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 rotated files to be kept, got %v", err)
+	}
+}
+
+func TestRotatingFile_AppendsToExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	if err := os.WriteFile(path, []byte("existing\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := NewRotatingFile(path, 12, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	// Does not fit next to the existing line, so it starts a new file
+	file.Write([]byte("new line\n"))
+
+	if data, _ := os.ReadFile(path + ".1"); string(data) != "existing\n" {
+		t.Errorf("Expected the existing file to be rotated, got %q", data)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new line\n" {
+		t.Errorf("Expected the new line in a fresh file, got %q", data)
+	}
+}
+
+// assertJSONLines checks that every line of the file is a complete JSON object
+func assertJSONLines(t *testing.T, name string) {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Errorf("Expected a JSON line in %s, got %q", filepath.Base(name), scanner.Text())
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strconv"
+)
+
+type sampledKey struct{}
+
+// requestID returns a sequential ID for the next request, so sampling stays
+// reproducible across runs when a seed is configured. It never comes from
+// the client, which could otherwise pick IDs that are never sampled.
+func (ps *Server) requestID() string {
+	return strconv.FormatUint(ps.requestSeq.Add(1), 10)
+}
+
+// sampled decides whether the request with the given ID gets an access log
+// line and detailed metrics. With SampleSeed set the decision depends only
+// on the seed and the ID; otherwise it is random.
+func (ps *Server) sampled(id string) bool {
+	if ps.SampleRate >= 1 {
+		return true
+	}
+	if ps.SampleRate <= 0 {
+		return false
+	}
+	if ps.SampleSeed == 0 {
+		return rand.Float64() < ps.SampleRate
+	}
+
+	h := fnv.New64a()
+	var seed [8]byte
+	binary.BigEndian.PutUint64(seed[:], uint64(ps.SampleSeed))
+	h.Write(seed[:])
+	h.Write([]byte(id))
+	return float64(mix64(h.Sum64()))/float64(math.MaxUint64) < ps.SampleRate
+}
+
+// mix64 spreads the bits of an FNV hash, whose high bits vary little for
+// similar short IDs (the splitmix64 finalizer)
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// withSampled returns a copy of ctx recording the sampling decision
+func withSampled(ctx context.Context, sampled bool) context.Context {
+	return context.WithValue(ctx, sampledKey{}, sampled)
+}
+
+// isSampled reports whether detailed logs and metrics should be recorded
+// for the request. Requests without a decision are always sampled.
+func isSampled(ctx context.Context) bool {
+	sampled, ok := ctx.Value(sampledKey{}).(bool)
+	return sampled || !ok
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSampling(t *testing.T) {
+	tests := []struct {
+		name string
+		rate float64
+		seed int64
+	}{
+		{"Seeded 10 percent", 0.1, 42},
+		{"Seeded 50 percent", 0.5, 7},
+		{"Random 25 percent", 0.25, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.SampleRate = tt.rate
+			proxy.SampleSeed = tt.seed
+
+			total := 20000
+			sampled := 0
+			for i := 0; i < total; i++ {
+				if proxy.sampled(fmt.Sprintf("req-%d", i)) {
+					sampled++
+				}
+			}
+
+			fraction := float64(sampled) / float64(total)
+			if fraction < tt.rate*0.8 || fraction > tt.rate*1.2 {
+				t.Errorf("Expected roughly %.2f sampled, got %.3f", tt.rate, fraction)
+			}
+		})
+	}
+}
+
+func TestSamplingDeterministic(t *testing.T) {
+	first := NewProxyServer("admin", "password123", "8080")
+	second := NewProxyServer("admin", "password123", "8080")
+	for _, ps := range []*Server{first, second} {
+		ps.SampleRate = 0.3
+		ps.SampleSeed = 1234
+	}
+
+	// The same seed and request ID always give the same decision
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("req-%d", i)
+		if first.sampled(id) != second.sampled(id) {
+			t.Fatalf("Sampling of %s differs between runs with the same seed", id)
+		}
+	}
+}
+
+func TestSamplingBounds(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	if !proxy.sampled("any") {
+		t.Error("Default rate should sample every request")
+	}
+	proxy.SampleRate = 0
+	if proxy.sampled("any") {
+		t.Error("Zero rate should sample nothing")
+	}
+
+	if !isSampled(context.Background()) {
+		t.Error("Requests without a decision should be sampled")
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	if proxy.requestID() == proxy.requestID() {
+		t.Error("Generated request IDs should be unique")
+	}
+}
+
+func TestSampling_IgnoresClientRequestID(t *testing.T) {
+	logs := captureLog(t)
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.SampleRate = 0.5
+	proxy.SampleSeed = 42
+
+	// Find a client ID that would never be sampled if it were hashed
+	unsampled := ""
+	for i := 0; unsampled == ""; i++ {
+		if id := fmt.Sprintf("client-%d", i); !proxy.sampled(id) {
+			unsampled = id
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		req := httptest.NewRequest("GET", backendServer.URL+"/hidden", nil)
+		req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+		req.Header.Set("X-Request-ID", unsampled)
+		proxy.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if !strings.Contains(logs.String(), "/hidden") {
+		t.Error("Expected some requests to be access-logged despite the client's request ID")
+	}
+}
@@ -0,0 +1,1350 @@
+// Package proxy implements an authenticating HTTP and HTTPS forward proxy.
+// A Server is an http.Handler, so it can be run with Start or mounted in an
+// existing http.Server.
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+)
+
+// defaultTimeout is used for upstream requests and dials unless configured
+const defaultTimeout = 30 * time.Second
+
+// Client connection timeouts used unless configured
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+)
+
+// defaultMaxURLLength is the longest request URL forwarded unless configured
+const defaultMaxURLLength = 8192
+
+// defaultPort is the port the proxy listens on unless configured
+const defaultPort = "8080"
+
+// defaultRealm is the realm of Proxy-Authenticate challenges unless configured
+const defaultRealm = "Proxy Server"
+
+// Server represents the HTTP proxy server
+type Server struct {
+	username string
+	password string
+	port     string
+
+	// BindAddress is the local address the proxy, SOCKS5 and admin listeners
+	// bind to, such as 127.0.0.1. They listen on all interfaces when empty.
+	BindAddress string
+	// ProxyProtocol reads a PROXY protocol v1 or v2 header from every
+	// connection to the proxy listener and uses the client address it
+	// carries for logging and IP checks. Only enable it behind a load
+	// balancer that sends one, since clients could otherwise spoof it.
+	ProxyProtocol bool
+
+	// users maps every accepted username to its credential, guarded by
+	// usersMu so passwords can be rotated while serving. usersMu also
+	// guards PolicyTags, AllowedCIDRs and DeniedCIDRs once serving, since
+	// ReloadConfig replaces them.
+	usersMu sync.RWMutex
+	users   map[string]*credential
+
+	// HashedCredentials treats every configured password as a bcrypt hash
+	// of the password clients must present. Plaintext passwords are used
+	// when false.
+	HashedCredentials bool
+	// verifiedHashes remembers recent successful bcrypt checks so clients
+	// do not pay the hashing cost on every request
+	verifiedHashes hashCache
+	// Realm is sent in Proxy-Authenticate challenges. Clients may store
+	// credentials per realm, so proxies sharing credentials should share it.
+	Realm string
+
+	// ForwardedHeaders controls whether X-Forwarded-* headers are added to
+	// forwarded HTTP requests. Disable it to hide client addresses upstream.
+	ForwardedHeaders bool
+	// ViaName identifies this proxy in the Via header of forwarded requests
+	// and responses. Requests already carrying it have looped back and are
+	// rejected. No Via header is added when empty.
+	ViaName string
+
+	// RequestTimeout bounds a complete forwarded HTTP request
+	RequestTimeout time.Duration
+	// DialTimeout bounds establishing the upstream connection of a CONNECT tunnel
+	DialTimeout time.Duration
+	// ExpectContinueTimeout is how long a request with Expect: 100-continue
+	// waits for the upstream's 100 Continue. The client's body is not read,
+	// so the client gets no 100 Continue either, until the upstream asks for
+	// it or the timeout passes. Zero sends the body upstream right away.
+	ExpectContinueTimeout time.Duration
+	// TunnelIdleTimeout closes a CONNECT tunnel once neither side has sent
+	// anything for this long. Zero keeps idle tunnels open.
+	TunnelIdleTimeout time.Duration
+	// MaxTunnelDuration closes a CONNECT tunnel this long after it was
+	// established, even while data is flowing. Zero leaves tunnels uncapped.
+	MaxTunnelDuration time.Duration
+	// TunnelGracePeriod lets open tunnels keep running for up to this long
+	// after Shutdown before they are closed. Zero closes them right away.
+	TunnelGracePeriod time.Duration
+	// AdaptiveTimeout, when set, replaces RequestTimeout and DialTimeout
+	// for hosts with observed latency by a multiple of their average. The
+	// request timeout then bounds the wait for response headers only, so
+	// long downloads are not cut off.
+	AdaptiveTimeout *AdaptiveTimeout
+	dialLatency     *latencyTracker
+	responseLatency *latencyTracker
+
+	// CircuitBreaker, when set, stops contacting upstream hosts that keep
+	// failing until they have had time to recover
+	CircuitBreaker *CircuitBreaker
+	circuits       *circuitTracker
+
+	// Tracer records a span for every proxied request. A CONNECT span covers
+	// the tunnel's whole lifetime. Defaults to a no-op tracer.
+	Tracer trace.Tracer
+
+	// DebugConnectionHeaders adds response headers reporting whether the
+	// upstream connection was reused and, for new connections, how long DNS
+	// and connecting took
+	DebugConnectionHeaders bool
+
+	// CoalesceConnectLookups shares one circuit breaker check and DNS lookup
+	// between concurrent CONNECT tunnels to the same host and port
+	CoalesceConnectLookups bool
+
+	// Resolver resolves upstream hostnames for CONNECT tunnels and forwarded
+	// requests. The system resolver is used when nil.
+	Resolver *net.Resolver
+
+	// AllowedCIDRs restricts which client IPs may use the proxy. An empty
+	// list allows all clients.
+	AllowedCIDRs []*net.IPNet
+	// DeniedCIDRs rejects client IPs and takes precedence over AllowedCIDRs
+	DeniedCIDRs []*net.IPNet
+
+	// MaxRequestMemory caps the bytes a single request may hold in memory
+	// across all buffering features. Requests over the cap fall back to
+	// streaming or are rejected. Zero means unlimited.
+	MaxRequestMemory int64
+
+	// MaxRetries is how many times a GET, HEAD, PUT or DELETE is retried
+	// after a transient upstream connection failure, waiting RetryBackoff
+	// before the first retry and doubling it each time. Request bodies are
+	// buffered within MaxRequestMemory so they can be replayed; requests
+	// whose body does not fit are not retried. Zero disables retries.
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	// RateLimit is the number of requests per second allowed from each
+	// client IP. Zero means unlimited.
+	RateLimit float64
+	// RateLimitBurst is the number of requests a client may make at once.
+	// It defaults to the per-second rate when unset.
+	RateLimitBurst int
+
+	// AuthBan, when set, rejects every request from client IPs that keep
+	// presenting wrong credentials with 403 for a while
+	AuthBan  *AuthBan
+	authBans *banTracker
+
+	// SampleRate is the fraction of requests that get an access log line and
+	// detailed metrics, from 0 to 1. Aggregate counters are always exact.
+	SampleRate float64
+	// SampleSeed makes sampling deterministic per request ID when non-zero.
+	// IDs are assigned by the server in arrival order; a client supplied
+	// X-Request-ID does not affect sampling.
+	SampleSeed int64
+
+	// ParentProxy is a sibling proxy that forwarded requests and tunnels are
+	// chained through. Userinfo in the URL is sent as Proxy-Authorization.
+	ParentProxy *url.URL
+	// NoProxyHosts are dialed directly instead of through ParentProxy. The
+	// entries use NO_PROXY syntax: domains, IPs and CIDRs, optionally with
+	// a port, or "*".
+	NoProxyHosts []string
+	// HopSecret is shared between chained proxies. It is sent to
+	// ParentProxy, and requests presenting it are accepted without user
+	// credentials.
+	HopSecret string
+
+	// AllowTrace permits the TRACE and TRACK methods, which are rejected by
+	// default to prevent cross-site tracing
+	AllowTrace bool
+	// AllowedMethods restricts the methods of forwarded HTTP requests, which
+	// are otherwise all allowed. It does not apply to CONNECT tunnels.
+	AllowedMethods []string
+
+	// MaxRequestBody and MaxResponseBody cap the size of forwarded request
+	// and response bodies. Larger requests are rejected with 413. Zero
+	// disables the limit.
+	MaxRequestBody  int64
+	MaxResponseBody int64
+
+	// ResponseCacheBytes is the size of an in-memory LRU cache of GET
+	// responses with explicit freshness from Cache-Control or Expires,
+	// keyed by the URL requested before HostRewrites and UpstreamPools.
+	// Responses are stored as ResponseModifier left them, and hits are
+	// served without calling it again. Hits carry no
+	// DebugConnectionHeaders since no upstream connection is made. Zero
+	// disables caching.
+	ResponseCacheBytes int64
+	cacheOnce          sync.Once
+	cache              *responseCache
+
+	// MaxHeaderBytes caps the size of a client's request line and headers.
+	// Larger requests are rejected with 431.
+	MaxHeaderBytes int
+
+	// ReadHeaderTimeout bounds how long a client may take to send its
+	// request headers, so slow clients cannot hold connections open
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout and WriteTimeout bound reading a whole request and writing
+	// its response. They also cut off long uploads, downloads and HTTP/2
+	// tunnels, so zero leaves them unbounded. HTTP/1 tunnels are unaffected.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// IdleTimeout closes keep-alive connections waiting this long for the
+	// next request
+	IdleTimeout time.Duration
+
+	// MaxDecompressedBytes caps how large a gzip body may expand when the
+	// proxy decompresses it for inspection. Bodies that expand further are
+	// treated as gzip bombs and cut off, closing the client connection.
+	// Passthrough is unaffected.
+	MaxDecompressedBytes int64
+	// InspectBodies decompresses gzip responses on the side to log their
+	// uncompressed size. Clients still receive the compressed bytes.
+	InspectBodies bool
+	// EncodeRequestBodies gzips request bodies sent upstream, skipping small
+	// bodies and ones that already have a Content-Encoding or a compressed
+	// media type. Upstreams must accept Content-Encoding: gzip requests.
+	EncodeRequestBodies bool
+
+	// MaxConcurrentConnections caps the requests and tunnels served at once.
+	// Further requests get 503 until one finishes. Zero means no limit.
+	MaxConcurrentConnections int
+
+	// MaxConnectionBytes caps the total bytes moved in both directions of a
+	// single request or tunnel. The connection is closed once it is reached.
+	// Zero disables the quota.
+	MaxConnectionBytes int64
+
+	// RateLimitBytesPerSec caps the throughput of a single request or
+	// tunnel, shared between both directions. Zero means unlimited.
+	RateLimitBytesPerSec int
+
+	// TunnelHalfClose keeps a CONNECT tunnel open in one direction after the
+	// other side finishes sending, forwarding the EOF as a TCP half-close.
+	// When false the whole tunnel is torn down as soon as either side
+	// closes. Either way the client connection is closed once the tunnel
+	// ends and is never reused for another request.
+	TunnelHalfClose bool
+
+	// BlockedDomains lists domains, including their subdomains, that may not
+	// be reached through the proxy
+	BlockedDomains map[string]struct{}
+	// BlockedPage, when set, is the HTML page served for blocked domains in
+	// place of a plain 403. See ParseBlockedPage.
+	BlockedPage *template.Template
+
+	// HealthPath is the origin-form path answering liveness probes without
+	// authentication. Empty disables the endpoint.
+	HealthPath string
+	// started is when the server was created, reported as uptime
+	started time.Time
+
+	// PACPath is the origin-form path serving a proxy auto-config file
+	// without authentication. Empty disables the endpoint.
+	PACPath string
+	// PACHost is the host:port the PAC file points clients at. When empty
+	// the address the client fetched the file from is used.
+	PACHost string
+
+	// ManifestPath is where a JSON manifest describing the running server is
+	// written once its listeners are bound. It is removed on Shutdown.
+	ManifestPath string
+
+	// AdminPort is the port of the admin listener serving /metrics. It is
+	// disabled when empty.
+	AdminPort string
+
+	// RandomPort allows the proxy, admin and SOCKS5 ports to be "0", letting
+	// the system pick any free port. The bound addresses are reported by
+	// Addr and the manifest. Port 0 is rejected when it is false.
+	RandomPort bool
+
+	// AccessLog receives an entry for every sampled request once it
+	// completes. It is nil by default; see NewJSONLogger.
+	AccessLog Logger
+
+	// RequestHeaders are set on every forwarded request, replacing what the
+	// client sent. ResponseHeaders are set on every response relayed back.
+	// An empty value removes the header instead.
+	RequestHeaders  map[string]string
+	ResponseHeaders map[string]string
+	// ErrorHandler writes the response when forwarding a request or opening
+	// a tunnel fails, such as a branded error page. err's message is meant
+	// for the client and wraps the underlying cause, if any. It defaults to
+	// DefaultErrorHandler.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error, status int)
+	// ResponseModifier, when set, may change each upstream response before
+	// its headers are relayed, such as stripping Set-Cookie. An error fails
+	// the request with 502.
+	ResponseModifier func(*http.Response) error
+
+	// HostRewrites sends requests and tunnels for a host to another one
+	// instead, keeping the port unless the replacement names one. Forwarded
+	// requests keep their original Host header unless RewriteHostHeader is
+	// set. BlockedDomains is checked against both the requested host and
+	// its replacement.
+	HostRewrites      map[string]string
+	RewriteHostHeader bool
+
+	// UpstreamPools balances requests and tunnels for a host across several
+	// backends by weighted round-robin, skipping backends whose circuit
+	// breaker is open. The port is kept unless a backend names one, and the
+	// Host header is rewritten as for HostRewrites.
+	UpstreamPools map[string][]Upstream
+	pools         *poolTracker
+
+	// BearerTokens maps static tokens accepted as "Proxy-Authorization:
+	// Bearer <token>" to the client name used in place of a username for
+	// policies, rate limits and logs. Basic credentials keep working.
+	BearerTokens map[string]string
+	// Authenticator replaces the built-in Basic and bearer token checks of
+	// HTTP requests and CONNECT tunnels when set. SOCKS5 clients keep using
+	// the configured users.
+	Authenticator Authenticator
+
+	// PolicyTags maps usernames to the policy tag their requests carry
+	PolicyTags map[string]string
+	// Policies holds the limits applied to each policy tag
+	Policies map[string]Policy
+
+	// TLSConfig customizes the client-facing listener used by StartTLS
+	TLSConfig *tls.Config
+	// certFile and keyFile make Start serve TLS, as set by WithTLS
+	certFile, keyFile string
+	// DisableHTTP2 stops StartTLS from offering HTTP/2 via ALPN
+	DisableHTTP2 bool
+
+	// ClientCRLFile is a PEM or DER certificate revocation list checked for
+	// client certificates when TLSConfig enables mTLS. Call ReloadCRL to pick
+	// up changes.
+	ClientCRLFile string
+	crl           atomic.Pointer[revocationList]
+
+	// AllowedConnectPorts lists the destination ports CONNECT may target
+	AllowedConnectPorts []int
+
+	// MinClientTLSVersion rejects CONNECT tunnels whose TLS ClientHello
+	// offers nothing newer than this version, e.g. tls.VersionTLS12.
+	// Zero disables the check.
+	MinClientTLSVersion uint16
+	// RejectWeakCiphers rejects CONNECT tunnels whose ClientHello offers
+	// only insecure cipher suites
+	RejectWeakCiphers bool
+
+	// MaxOutboundHeaderBytes caps the total size of headers forwarded
+	// upstream. Zero means no limit.
+	MaxOutboundHeaderBytes int
+
+	// MaxURLLength caps the length of a forwarded request's URL. Longer
+	// requests are rejected with 414. Zero means no limit.
+	MaxURLLength int
+	// TrimmableHeaders lists the headers that may be dropped to fit within
+	// MaxOutboundHeaderBytes. Requests that still do not fit are rejected.
+	TrimmableHeaders []string
+
+	// BufferHTTP10Responses buffers responses of unknown length for HTTP/1.0
+	// clients so they can be sent with a Content-Length instead of relying
+	// on the connection close to delimit the body
+	BufferHTTP10Responses bool
+
+	// TLSSessionCacheSize is the number of upstream TLS sessions kept for
+	// resumption. Zero disables session resumption.
+	TLSSessionCacheSize int
+	// MaxIdleConnsPerHost is the number of idle upstream connections kept
+	// per host for reuse by later HTTP requests. Zero uses net/http's
+	// default of 2. CONNECT tunnels always dial their own connection.
+	MaxIdleConnsPerHost int
+	// RootCAs verifies upstream TLS certificates when the proxy makes
+	// HTTPS requests itself. The system trust store is used when nil.
+	RootCAs *x509.CertPool
+	// InsecureSkipVerify disables verification of upstream certificates.
+	// It is meant for testing only.
+	InsecureSkipVerify bool
+
+	// InterceptHTTPS terminates TLS on CONNECT tunnels with a certificate
+	// for the target signed by InterceptCA, so decrypted requests can be
+	// inspected before they are forwarded. Clients must trust the CA. This
+	// is meant for debugging.
+	InterceptHTTPS bool
+	InterceptCA    *tls.Certificate
+	interceptCerts certCache
+
+	// RobotsTxt is served at /robots.txt for requests addressed to the proxy
+	// itself. An empty value disables the endpoint.
+	RobotsTxt string
+
+	userLimiters   *limiterSet
+	clientLimiters *limiterSet
+
+	requestSeq atomic.Uint64
+
+	// now returns the current time and can be replaced in tests
+	now func() time.Time
+
+	metrics *Metrics
+	// usage attributes transferred bytes to authenticated users
+	usage userUsage
+
+	// lookupHost resolves CONNECT targets. It defaults to the Resolver and
+	// can be replaced in tests. lookupGroup coalesces the circuit breaker
+	// check and resolution of concurrent tunnels to the same target.
+	lookupHost  func(ctx context.Context, host string) ([]string, error)
+	lookupGroup singleflight.Group
+
+	mu          sync.Mutex
+	server      *http.Server
+	adminServer *http.Server
+	// socksListener accepts SOCKS5 clients when StartSOCKS5 is running
+	socksListener net.Listener
+	// http3Server serves HTTP/3 when StartHTTP3 is running, advertised
+	// to clients with the altSvc value
+	http3Server io.Closer
+	altSvc      string
+	// addr and adminAddr are the bound listener addresses
+	addr      net.Addr
+	adminAddr net.Addr
+	useTLS    bool
+	tunnels   map[net.Conn]struct{}
+	shutdown  bool
+	// drained is closed once no tunnels remain after Shutdown
+	drained chan struct{}
+
+	transportOnce sync.Once
+	transport     *http.Transport
+}
+
+// New creates a proxy server with default settings changed by opts. It
+// listens on port 8080 unless an option says otherwise.
+func New(opts ...Option) *Server {
+	ps := &Server{
+		port:                   defaultPort,
+		users:                  make(map[string]*credential),
+		ForwardedHeaders:       true,
+		RequestTimeout:         defaultTimeout,
+		DialTimeout:            defaultTimeout,
+		MaxDecompressedBytes:   defaultMaxDecompressedBytes,
+		MaxHeaderBytes:         http.DefaultMaxHeaderBytes,
+		ReadHeaderTimeout:      defaultReadHeaderTimeout,
+		IdleTimeout:            defaultIdleTimeout,
+		MaxURLLength:           defaultMaxURLLength,
+		MaxRequestMemory:       defaultMaxRequestMemory,
+		RetryBackoff:           defaultRetryBackoff,
+		SampleRate:             1,
+		RobotsTxt:              defaultRobotsTxt,
+		HealthPath:             defaultHealthPath,
+		Realm:                  defaultRealm,
+		started:                time.Now(),
+		BufferHTTP10Responses:  true,
+		TrimmableHeaders:       []string{"Cookie"},
+		AllowedConnectPorts:    append([]int(nil), defaultAllowedConnectPorts...),
+		TLSSessionCacheSize:    defaultTLSSessionCacheSize,
+		MaxIdleConnsPerHost:    defaultMaxIdleConnsPerHost,
+		ExpectContinueTimeout:  defaultExpectContinueTimeout,
+		userLimiters:           newLimiterSet(),
+		clientLimiters:         newLimiterSet(),
+		now:                    time.Now,
+		CoalesceConnectLookups: true,
+		dialLatency:            newLatencyTracker(),
+		responseLatency:        newLatencyTracker(),
+		circuits:               newCircuitTracker(),
+		pools:                  newPoolTracker(),
+		authBans:               newBanTracker(),
+		Tracer:                 defaultTracer(),
+		metrics:                newMetrics(),
+		tunnels:                make(map[net.Conn]struct{}),
+	}
+	for _, opt := range opts {
+		opt.apply(ps)
+	}
+	return ps
+}
+
+// NewProxyServer creates a new proxy server instance
+func NewProxyServer(username, password, port string) *Server {
+	return New(WithPort(port), WithCredentials(username, password))
+}
+
+// authenticateRequest checks if the request has valid Basic Auth credentials
+func (ps *Server) authenticateRequest(r *http.Request) bool {
+	_, ok := ps.authenticatedUser(r)
+	return ok
+}
+
+// authenticatedUser returns the user that sent r according to the
+// configured Authenticator, or the built-in checks when there is none
+func (ps *Server) authenticatedUser(r *http.Request) (string, bool) {
+	if ps.Authenticator != nil {
+		return ps.Authenticator.Authenticate(r)
+	}
+	return ps.Authenticate(r)
+}
+
+// Authenticate is the default Authenticator. It returns the username of
+// valid Basic Auth credentials, or the client name of a valid bearer token.
+// Custom authenticators can fall back to it.
+func (ps *Server) Authenticate(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Proxy-Authorization")
+	if auth == "" {
+		return "", false
+	}
+
+	if strings.HasPrefix(auth, "Bearer ") {
+		return ps.checkBearerToken(auth[7:])
+	}
+
+	username, password, ok := proxyBasicAuth(r)
+	if !ok || !ps.checkCredentials(username, password) {
+		return "", false
+	}
+	return username, true
+}
+
+// proxyBasicAuth returns the credentials of a Basic Proxy-Authorization
+// header
+func proxyBasicAuth(r *http.Request) (username, password string, ok bool) {
+	auth := r.Header.Get("Proxy-Authorization")
+
+	// Check if it's Basic authentication
+	if !strings.HasPrefix(auth, "Basic ") {
+		return "", "", false
+	}
+
+	// Decode the base64 encoded credentials
+	payload, err := base64.StdEncoding.DecodeString(auth[6:])
+	if err != nil {
+		return "", "", false
+	}
+
+	// Split username and password
+	credentials := strings.SplitN(string(payload), ":", 2)
+	if len(credentials) != 2 {
+		return "", "", false
+	}
+	return credentials[0], credentials[1], true
+}
+
+// authorize authenticates the request and applies the policy for its tag.
+// It writes the error response and returns false if the request may not proceed.
+func (ps *Server) authorize(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	user, ok := ps.authenticatedUser(r)
+	if !ok && ps.hopAuthenticated(r) {
+		// A child proxy already authenticated its client
+		ok = true
+	}
+	if !ok {
+		ps.metrics.recordAuthFailure()
+		// Clients first try without credentials to get the challenge
+		if r.Header.Get("Proxy-Authorization") != "" {
+			ps.recordAuthFailure(remoteIP(r))
+		}
+		// Read the body before refusing it so the client is not left
+		// writing into a connection the server has stopped reading. A
+		// client waiting for 100 Continue has not sent it yet.
+		if !expectsContinue(r) {
+			drainBody(r.Body)
+		}
+		ps.advertiseHTTP3(w)
+		w.Header().Set("Proxy-Authenticate", fmt.Sprintf("Basic realm=%q", ps.Realm))
+		if len(ps.BearerTokens) > 0 {
+			w.Header().Add("Proxy-Authenticate", fmt.Sprintf("Bearer realm=%q", ps.Realm))
+		}
+		ps.writeError(w, r, "Proxy Authentication Required", http.StatusProxyAuthRequired)
+		return r, false
+	}
+
+	tag := ps.policyTag(user)
+	r = r.WithContext(withPolicyTag(r.Context(), tag))
+
+	info := requestInfoFromContext(r.Context())
+	info.user, info.tag = user, tag
+
+	policy := ps.policyFor(r.Context())
+	if ok, retryAfter := ps.userLimiters.allow(user, policy.RequestsPerSecond, policy.Burst, ps.now()); !ok {
+		ps.writeTooManyRequests(w, r, retryAfter)
+		return r, false
+	}
+
+	target := r.URL.Host
+	if r.Method == http.MethodConnect {
+		target = r.Host
+	}
+	if !ps.userMayReach(user, target) {
+		ps.writeError(w, r, "Access to this domain is not allowed for this user", http.StatusForbidden)
+		return r, false
+	}
+
+	return r, true
+}
+
+// handleHTTP handles HTTP requests through the proxy
+func (ps *Server) handleHTTP(w http.ResponseWriter, r *http.Request) {
+	// Check authentication and policy limits
+	r, ok := ps.authorize(w, r)
+	if !ok {
+		return
+	}
+	if !ps.forwardMethodAllowed(r.Method) {
+		w.Header().Set("Allow", strings.Join(ps.AllowedMethods, ", "))
+		ps.writeError(w, r, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Block the host the client asked for, not only where it is sent
+	if ps.domainBlocked(r.URL.Host) {
+		ps.writeBlocked(w, r, r.URL.Host)
+		return
+	}
+	requestInfoFromContext(r.Context()).requestedURL = r.URL.String()
+	if host, rewritten := ps.rewriteHost(r.URL.Host); rewritten {
+		r.URL.Host = host
+		if ps.RewriteHostHeader {
+			r.Host = host
+		}
+	}
+	if host, pooled, err := ps.pickUpstream(r.URL.Host, schemePort(r.URL.Scheme)); pooled {
+		if err != nil {
+			ps.writeProxyError(w, r, err)
+			return
+		}
+		r.URL.Host = host
+		if ps.RewriteHostHeader {
+			r.Host = host
+		}
+	}
+	ps.forward(w, r)
+}
+
+// forward sends an authorized request to its upstream and relays the
+// response
+func (ps *Server) forward(w http.ResponseWriter, r *http.Request) {
+	if ps.MaxURLLength > 0 && len(r.URL.String()) > ps.MaxURLLength {
+		ps.writeError(w, r, "URI Too Long", http.StatusRequestURITooLong)
+		return
+	}
+
+	if ps.ViaName != "" && viaLoop(r.Header, ps.ViaName) {
+		ps.writeError(w, r, "Loop Detected", http.StatusLoopDetected)
+		return
+	}
+
+	if ps.domainBlocked(r.URL.Host) {
+		ps.writeBlocked(w, r, r.URL.Host)
+		return
+	}
+
+	// Reject oversized request bodies up front when their size is known
+	if ps.MaxRequestBody > 0 {
+		if r.ContentLength > ps.MaxRequestBody {
+			ps.writeError(w, r, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, ps.MaxRequestBody)
+		}
+	}
+
+	// Remove proxy-specific headers
+	r.Header.Del("Proxy-Authorization")
+	r.Header.Del("Proxy-Connection")
+	r.Header.Del(hopSecretHeader)
+
+	// Bytes this request may hold in memory across buffering features
+	budget := newMemoryBudget(ps.MaxRequestMemory)
+
+	// Create HTTP client
+	client := ps.upstreamClient(r.URL.Host)
+
+	// Create new request, counting the body bytes sent upstream against the
+	// connection's quota
+	quota := newByteQuota(ps.connectionBytesFor(r.Context()))
+	traffic := ps.usage.forUser(requestInfoFromContext(r.Context()).user)
+	var requestBody io.Reader
+	upstreamBytes := &countingReader{r: http.NoBody, quota: quota, total: traffic.upstreamCounter()}
+	if r.Body != nil {
+		upstreamBytes.r = r.Body
+		requestBody = upstreamBytes
+	}
+
+	// Idempotent requests keep their body in memory so a retry can resend it.
+	// Reading it up front would tell a client expecting 100 Continue to send
+	// it before the upstream agreed, so those requests are not retried.
+	retries := 0
+	if ps.MaxRetries > 0 && isIdempotent(r.Method) && !expectsContinue(r) {
+		body, replayable, err := retryableBody(requestBody, budget)
+		if errors.Is(err, errByteQuotaExceeded) {
+			ps.abortOverQuota(r)
+		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			ps.writeError(w, r, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			ps.writeError(w, r, "Error reading request body", http.StatusBadRequest)
+			return
+		}
+		requestBody = body
+		if replayable {
+			retries = ps.MaxRetries
+		}
+	}
+
+	// The upstream request is abandoned when the client goes away
+	proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, r.URL.String(), requestBody)
+	if err != nil {
+		ps.writeError(w, r, "Error creating proxy request", http.StatusInternalServerError)
+		return
+	}
+
+	// Keep the Host the client asked for when the URL was rewritten
+	proxyReq.Host = r.Host
+
+	// Copy headers
+	for name, values := range r.Header {
+		for _, value := range values {
+			proxyReq.Header.Add(name, value)
+		}
+	}
+	ps.addVia(proxyReq.Header)
+	injectTraceContext(r.Context(), proxyReq.Header)
+
+	if ps.ForwardedHeaders {
+		setForwardedHeaders(proxyReq, r)
+	}
+	overrideHeaders(proxyReq.Header, ps.RequestHeaders)
+
+	if ps.EncodeRequestBodies && proxyReq.Body != nil && shouldEncodeRequestBody(r.ContentLength, proxyReq.Header) {
+		encodeRequestBody(proxyReq)
+	}
+
+	// Plain HTTP requests reach the parent as-is, so they carry the secret
+	// themselves. HTTPS requests present it on the transport's CONNECT.
+	if ps.viaParent(upstreamAddr(proxyReq)) && proxyReq.URL.Scheme == "http" {
+		ps.setHopHeaders(proxyReq.Header)
+	}
+
+	// Keep the forwarded headers within what upstreams accept
+	if ps.MaxOutboundHeaderBytes > 0 && !harmonizeHeaderSize(proxyReq.Header, ps.MaxOutboundHeaderBytes, ps.TrimmableHeaders) {
+		ps.writeError(w, r, "Request Header Fields Too Large", http.StatusRequestHeaderFieldsTooLarge)
+		return
+	}
+
+	trace := &connTrace{}
+	clientTrace := trace.clientTrace()
+	clientTrace.Got1xxResponse = relayInformational(w, r)
+	proxyReq = proxyReq.WithContext(httptrace.WithClientTrace(proxyReq.Context(), clientTrace))
+
+	if isUpgradeRequest(r) {
+		ps.handleUpgrade(w, r, proxyReq)
+		return
+	}
+
+	cache := ps.responseCache()
+	// One logical URL shares an entry whichever backend serves it
+	cacheKey := requestInfoFromContext(r.Context()).requestedURL
+	if cacheKey == "" {
+		cacheKey = r.URL.String()
+	}
+	useCache := cache != nil && cacheableRequest(r)
+	if useCache {
+		if entry, ok := cache.get(cacheKey, ps.now()); ok {
+			ps.serveCached(w, entry, traffic)
+			return
+		}
+	}
+
+	// Make the request, unless the upstream has been failing
+	circuitHost := upstreamAddr(proxyReq)
+	if !ps.circuitAllows(circuitHost) {
+		ps.writeProxyError(w, r, errCircuitOpen)
+		return
+	}
+	upstreamStart := time.Now()
+	resp, err := ps.doWithRetry(client, proxyReq, retries)
+	latency := time.Since(upstreamStart)
+	if isSampled(r.Context()) {
+		ps.metrics.observeUpstreamDuration(latency)
+	}
+	ps.observeLatency(ps.responseLatency, r.URL.Host, latency, err)
+	if errors.Is(err, errByteQuotaExceeded) {
+		ps.abortOverQuota(r)
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		ps.writeError(w, r, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+		log.Printf("Client %s went away before %s responded", r.RemoteAddr, r.URL.Host)
+		return
+	}
+	ps.recordCircuit(circuitHost, err != nil)
+	if err != nil {
+		ps.writeProxyError(w, r, err)
+		return
+	}
+	defer resp.Body.Close()
+	trace.record(ps.metrics)
+	info := requestInfoFromContext(r.Context())
+	info.gotConn, info.reused = trace.connState()
+
+	if ps.MaxResponseBody > 0 && resp.ContentLength > ps.MaxResponseBody {
+		ps.writeError(w, r, "Upstream response too large", http.StatusBadGateway)
+		return
+	}
+
+	if ps.ResponseModifier != nil {
+		if err := ps.ResponseModifier(resp); err != nil {
+			log.Printf("Error modifying response from %s: %v", r.URL.Host, err)
+			ps.writeError(w, r, "Error modifying upstream response", http.StatusBadGateway)
+			return
+		}
+	}
+
+	// Copy response headers
+	for name, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	ps.addVia(w.Header())
+	if ps.DebugConnectionHeaders {
+		trace.setHeaders(w.Header())
+	}
+	overrideHeaders(w.Header(), ps.ResponseHeaders)
+
+	var body io.Reader = newLimitedReader(resp.Body, ps.MaxResponseBody)
+	if !r.ProtoAtLeast(1, 1) && ps.BufferHTTP10Responses {
+		body, err = prepareHTTP10Response(w, r, resp, body, budget)
+		if errors.Is(err, errResponseTooLarge) {
+			log.Printf("Closing connection from %s to %s: %v", r.RemoteAddr, r.URL.Host, err)
+			w.Header().Set("Connection", "close")
+			ps.writeError(w, r, "Upstream response too large", http.StatusBadGateway)
+			return
+		}
+		if err != nil {
+			ps.writeError(w, r, "Error reading upstream response", http.StatusBadGateway)
+			return
+		}
+	}
+
+	// Keep a copy of cacheable responses as they are relayed
+	var capture *captureWriter
+	var cacheLifetime time.Duration
+	if useCache {
+		w.Header().Set(cacheStatusHeader, "MISS")
+		if lifetime, ok := freshnessLifetime(resp, ps.now()); ok {
+			capture = &captureWriter{limit: ps.ResponseCacheBytes, budget: budget}
+			cacheLifetime = lifetime
+			body = io.TeeReader(body, capture)
+		}
+	}
+
+	// Measure gzip bodies as relayed. Bodies the transport already
+	// decompressed have no Content-Encoding and are not counted twice.
+	var inspector *gzipInspector
+	if ps.InspectBodies && strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		inspector = newGzipInspector(ps.MaxDecompressedBytes)
+		body = io.TeeReader(body, inspector)
+
+		// Decompress what has already arrived before the status is sent,
+		// so a bomb caught there can still be refused with 502
+		prefix := make([]byte, inspectPrefixSize)
+		n, err := body.Read(prefix)
+		if errors.Is(err, errDecompressedTooLarge) {
+			inspector.finish()
+			log.Printf("Refusing response from %s to %s: %v", r.URL.Host, r.RemoteAddr, err)
+			ps.writeError(w, r, "Upstream response too large", http.StatusBadGateway)
+			return
+		}
+		body = io.MultiReader(bytes.NewReader(prefix[:n]), body)
+	}
+
+	// Set status code
+	w.WriteHeader(resp.StatusCode)
+
+	// Copy response body
+	body = newThrottledReader(r.Context(), body, newThrottle(ps.bytesPerSecFor(r.Context())))
+	downstreamBytes, err := io.Copy(w, &countingReader{r: body, quota: quota, total: traffic.downstreamCounter()})
+	ps.metrics.recordBytes(upstreamBytes.n.Load(), downstreamBytes)
+	if inspector != nil {
+		ps.recordInspection(r, inspector, downstreamBytes)
+	}
+	if errors.Is(err, errByteQuotaExceeded) {
+		ps.abortOverQuota(r)
+	}
+	if errors.Is(err, errResponseTooLarge) || errors.Is(err, errDecompressedTooLarge) {
+		// The status line is already sent, so cut the client off rather
+		// than let a truncated body look complete
+		log.Printf("Closing connection from %s to %s: %v", r.RemoteAddr, r.URL.Host, err)
+		panic(http.ErrAbortHandler)
+	}
+	if err != nil {
+		log.Printf("Error copying response body: %v", err)
+		return
+	}
+	if capture != nil && !capture.overflow {
+		cache.put(newCacheEntry(cacheKey, resp, capture.buf.Bytes(), cacheLifetime, ps.now()))
+	}
+}
+
+// abortOverQuota logs why a request exceeded MaxConnectionBytes and drops the
+// client connection mid-exchange
+func (ps *Server) abortOverQuota(r *http.Request) {
+	log.Printf("Closing connection from %s to %s: %v (%d bytes)", r.RemoteAddr, r.URL.Host, errByteQuotaExceeded, ps.connectionBytesFor(r.Context()))
+	panic(http.ErrAbortHandler)
+}
+
+// setForwardedHeaders appends the client address to the X-Forwarded-For chain
+// and records the original protocol and host of the request
+func setForwardedHeaders(proxyReq, r *http.Request) {
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+	// The zone of a link-local address means nothing to the upstream
+	if ip := remoteIP(r); ip != nil {
+		clientIP = ip.String()
+	}
+
+	// Preserve any existing chain sent by the client or a previous proxy
+	if prior := r.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+		clientIP = strings.Join(prior, ", ") + ", " + clientIP
+	}
+	proxyReq.Header.Set("X-Forwarded-For", clientIP)
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	proxyReq.Header.Set("X-Forwarded-Proto", proto)
+	proxyReq.Header.Set("X-Forwarded-Host", r.Host)
+}
+
+// handleHTTPS handles HTTPS CONNECT requests
+func (ps *Server) handleHTTPS(w http.ResponseWriter, r *http.Request) {
+	// Check authentication and policy limits
+	r, ok := ps.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	// Get the destination host
+	target, port, err := connectTarget(r.Host)
+	if err != nil {
+		ps.writeError(w, r, "Invalid CONNECT target", http.StatusBadRequest)
+		return
+	}
+	if ps.domainBlocked(target) {
+		ps.writeBlocked(w, r, target)
+		return
+	}
+	if rewritten, ok := ps.rewriteHost(target); ok {
+		target, port, err = connectTarget(rewritten)
+		if err != nil {
+			ps.writeError(w, r, "Invalid CONNECT target", http.StatusBadGateway)
+			return
+		}
+	}
+	if picked, pooled, err := ps.pickUpstream(target, ""); pooled {
+		if err != nil {
+			ps.writeProxyError(w, r, err)
+			return
+		}
+		target, port, err = connectTarget(picked)
+		if err != nil {
+			ps.writeError(w, r, "Invalid CONNECT target", http.StatusBadGateway)
+			return
+		}
+	}
+	if !ps.connectPortAllowed(port) {
+		ps.writeError(w, r, "CONNECT to this port is not allowed", http.StatusForbidden)
+		return
+	}
+	if ps.domainBlocked(target) {
+		ps.writeBlocked(w, r, target)
+		return
+	}
+
+	// Intercepted tunnels dial the upstream per request instead
+	var destConn net.Conn
+	if !ps.InterceptHTTPS {
+		destConn, err = ps.dialConnect(target)
+		if err != nil {
+			ps.writeProxyError(w, r, err)
+			return
+		}
+		defer destConn.Close()
+	}
+
+	// HTTP/1 tunnels take over the client connection, so make sure that is
+	// possible before telling the client the tunnel is established
+	hijacker, canHijack := w.(http.Hijacker)
+	if r.ProtoMajor != 2 && !canHijack {
+		log.Printf("Rejecting CONNECT from %s: %s connection cannot be hijacked", r.RemoteAddr, r.Proto)
+		ps.writeError(w, r, "CONNECT tunnels are not supported over "+r.Proto+" on this server", http.StatusNotImplemented)
+		return
+	}
+
+	// Prove to a child proxy that this hop knows the shared secret
+	if ps.hopAuthenticated(r) {
+		w.Header().Set(hopProofHeader, hopProof(ps.HopSecret, r.Host))
+	}
+
+	// Send 200 Connection established
+	w.WriteHeader(http.StatusOK)
+
+	var clientConn net.Conn
+	var clientReader *bufio.Reader
+	if r.ProtoMajor == 2 {
+		// HTTP/2 carries the tunnel on the request's stream, which cannot
+		// be hijacked
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			return
+		}
+		clientConn = newStreamConn(w, r)
+		clientReader = bufio.NewReader(clientConn)
+	} else {
+		// Get the underlying connection. The 200 is already buffered, so a
+		// failure here can only drop the connection.
+		conn, clientBuf, err := hijacker.Hijack()
+		if err != nil {
+			log.Printf("Error hijacking CONNECT from %s: %v", r.RemoteAddr, err)
+			return
+		}
+		// Read through the buffer so bytes sent right after CONNECT are kept
+		clientConn, clientReader = conn, clientBuf.Reader
+	}
+	defer clientConn.Close()
+
+	// Register the tunnel so Shutdown can close it
+	if !ps.trackTunnel(clientConn) {
+		return
+	}
+	defer ps.untrackTunnel(clientConn)
+
+	if ps.MinClientTLSVersion != 0 || ps.RejectWeakCiphers {
+		clientReader, ok = ps.checkClientHello(clientConn, clientReader)
+		if !ok {
+			return
+		}
+	}
+
+	if ps.InterceptHTTPS {
+		ps.intercept(&bufferedConn{Conn: clientConn, reader: clientReader}, r, target)
+		return
+	}
+	ps.pipe(r.Context(), clientConn, clientReader, destConn, target, requestInfoFromContext(r.Context()).user)
+}
+
+// trackTunnel registers an open CONNECT tunnel. It returns false if the
+// server is shutting down and the tunnel should not be started.
+func (ps *Server) trackTunnel(conn net.Conn) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.shutdown {
+		return false
+	}
+	ps.tunnels[conn] = struct{}{}
+	return true
+}
+
+// untrackTunnel removes a closed CONNECT tunnel from the registry
+func (ps *Server) untrackTunnel(conn net.Conn) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	delete(ps.tunnels, conn)
+	if ps.shutdown && len(ps.tunnels) == 0 {
+		close(ps.drained)
+	}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (ps *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := ps.now()
+	id := ps.requestID()
+	ps.metrics.recordRequest(r.Method)
+
+	// Only a sample of requests is logged at very high throughput
+	sampled := ps.sampled(id)
+	info := &requestInfo{}
+	host := r.URL.Host
+	if host == "" {
+		host = r.Host
+	}
+	ctx, span := ps.startSpan(r, host)
+	r = r.WithContext(withRequestInfo(withSampled(ctx, sampled), info))
+
+	rec := newResponseRecorder(w)
+	ps.serveLimited(rec.writer(), r)
+	elapsed := ps.now().Sub(start)
+	endSpan(span, rec.statusCode(), elapsed)
+
+	if !sampled {
+		return
+	}
+	if info.gotConn {
+		log.Printf("%s %s %s %d %v reused=%t", r.RemoteAddr, r.Method, r.URL.String(), rec.statusCode(), elapsed, info.reused)
+	} else {
+		log.Printf("%s %s %s %d %v", r.RemoteAddr, r.Method, r.URL.String(), rec.statusCode(), elapsed)
+	}
+	if ps.AccessLog != nil {
+		ps.AccessLog.Log(AccessLogEntry{
+			Time:      start,
+			RequestID: id,
+			ClientIP:  remoteIP(r).String(),
+			User:      info.user,
+			Tag:       info.tag,
+			Method:    r.Method,
+			Host:      host,
+			Status:    rec.statusCode(),
+			Bytes:     rec.bytes.Load(),
+			Duration:  elapsed,
+			Unzipped:  info.unzipped,
+		})
+	}
+}
+
+// serve applies the client checks and dispatches the request by its form
+func (ps *Server) serve(w http.ResponseWriter, r *http.Request) {
+	normalizeProxyRequest(r)
+
+	// Reject disallowed clients before looking at credentials
+	clientIP := remoteIP(r)
+	if !ps.ipAllowed(clientIP) || ps.clientBanned(clientIP) {
+		ps.writeError(w, r, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if ok, retryAfter := ps.clientLimiters.allow(clientIP.String(), ps.RateLimit, ps.RateLimitBurst, ps.now()); !ok {
+		ps.writeTooManyRequests(w, r, retryAfter)
+		return
+	}
+
+	if !ps.methodAllowed(r.Method) {
+		ps.writeError(w, r, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch classifyRequest(r) {
+	case originForm:
+		ps.advertiseHTTP3(w)
+		ps.handleDirect(w, r)
+	case authorityForm:
+		ps.handleHTTPS(w, r)
+	case absoluteForm:
+		ps.handleHTTP(w, r)
+	default:
+		ps.writeError(w, r, "Bad Request: ambiguous request target", http.StatusBadRequest)
+	}
+}
+
+// advertiseHTTP3 adds Alt-Svc for the HTTP/3 listener to a response from the
+// proxy itself. Proxied responses are left alone, as Alt-Svc there would
+// describe the origin.
+func (ps *Server) advertiseHTTP3(w http.ResponseWriter) {
+	ps.mu.Lock()
+	altSvc := ps.altSvc
+	ps.mu.Unlock()
+	if altSvc != "" {
+		w.Header().Set("Alt-Svc", altSvc)
+	}
+}
+
+// newHTTPServer creates the http.Server used by the Start methods and
+// retains it for Shutdown
+func (ps *Server) newHTTPServer() *http.Server {
+	server := &http.Server{
+		Addr:              net.JoinHostPort(ps.BindAddress, ps.port),
+		Handler:           ps,
+		TLSConfig:         ps.TLSConfig,
+		MaxHeaderBytes:    ps.MaxHeaderBytes,
+		ReadHeaderTimeout: ps.ReadHeaderTimeout,
+		ReadTimeout:       ps.ReadTimeout,
+		WriteTimeout:      ps.WriteTimeout,
+		IdleTimeout:       ps.IdleTimeout,
+	}
+
+	if ps.ClientCRLFile != "" {
+		server.TLSConfig = ps.clientCRLTLSConfig()
+	}
+
+	ps.mu.Lock()
+	ps.server = server
+	ps.mu.Unlock()
+
+	return server
+}
+
+// Start starts the proxy server. It serves TLS as StartTLS does when the
+// server was created with WithTLS.
+func (ps *Server) Start() error {
+	if ps.certFile != "" {
+		return ps.StartTLS(ps.certFile, ps.keyFile)
+	}
+	if err := ps.Validate(); err != nil {
+		return err
+	}
+	server := ps.newHTTPServer()
+	ln, err := ps.listen(server, false)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Starting HTTP Proxy Server on port %s", ps.port)
+	log.Printf("Server ready to accept connections...")
+
+	return server.Serve(ln)
+}
+
+// Addr returns the address the proxy listener is bound to, which reports
+// the OS-assigned port when the server was started on port 0. It returns
+// nil until the server is listening.
+func (ps *Server) Addr() net.Addr {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.addr
+}
+
+// Port returns the port the proxy was configured to listen on
+func (ps *Server) Port() string {
+	return ps.port
+}
+
+// listen binds the proxy and admin listeners and then writes the manifest
+func (ps *Server) listen(server *http.Server, useTLS bool) (net.Listener, error) {
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if ps.ProxyProtocol {
+		ln = &proxyProtoListener{Listener: ln, timeout: ps.ReadHeaderTimeout}
+	}
+	ps.mu.Lock()
+	ps.addr, ps.useTLS = ln.Addr(), useTLS
+	ps.mu.Unlock()
+
+	if err := ps.startAdmin(); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if err := ps.writeManifest(); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("writing manifest: %w", err)
+	}
+	return ln, nil
+}
+
+// StartTLS starts the proxy server with TLS on the client-facing listener,
+// so credentials are never sent in plaintext
+func (ps *Server) StartTLS(certFile, keyFile string) error {
+	if err := ps.Validate(); err != nil {
+		return err
+	}
+	if ps.ClientCRLFile != "" {
+		if err := ps.ReloadCRL(); err != nil {
+			return err
+		}
+	}
+	server := ps.newHTTPServer()
+	if ps.DisableHTTP2 {
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+
+	ln, err := ps.listen(server, true)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Starting HTTPS Proxy Server on port %s", ps.port)
+	log.Printf("Server ready to accept connections...")
+
+	return server.ServeTLS(ln, certFile, keyFile)
+}
+
+// Shutdown gracefully stops the server. It waits for in-flight requests
+// until ctx is done and then closes any open CONNECT tunnels, which the
+// http.Server no longer tracks once they are hijacked. Tunnels that are
+// still open get TunnelGracePeriod, bounded by ctx, to finish first.
+func (ps *Server) Shutdown(ctx context.Context) error {
+	ps.mu.Lock()
+	server := ps.server
+	adminServer := ps.adminServer
+	socksListener := ps.socksListener
+	http3Server := ps.http3Server
+	if !ps.shutdown {
+		ps.shutdown = true
+		ps.drained = make(chan struct{})
+		if len(ps.tunnels) == 0 {
+			close(ps.drained)
+		}
+	}
+	drained := ps.drained
+	ps.mu.Unlock()
+
+	if socksListener != nil {
+		socksListener.Close()
+	}
+	if http3Server != nil {
+		http3Server.Close()
+	}
+	defer ps.removeManifest()
+
+	var err error
+	if server != nil {
+		err = server.Shutdown(ctx)
+	}
+	if adminServer != nil {
+		adminServer.Shutdown(ctx)
+	}
+
+	if ps.TunnelGracePeriod > 0 {
+		grace := time.NewTimer(ps.TunnelGracePeriod)
+		defer grace.Stop()
+		select {
+		case <-drained:
+		case <-grace.C:
+		case <-ctx.Done():
+		}
+	}
+
+	ps.mu.Lock()
+	for conn := range ps.tunnels {
+		conn.Close()
+	}
+	ps.mu.Unlock()
+
+	return err
+}
@@ -0,0 +1,1108 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewProxyServer(t *testing.T) {
+	username := "testuser"
+	password := "testpass"
+	port := "8080"
+
+	proxy := NewProxyServer(username, password, port)
+
+	if proxy.username != username {
+		t.Errorf("Expected username %s, got %s", username, proxy.username)
+	}
+	if proxy.password != password {
+		t.Errorf("Expected password %s, got %s", password, proxy.password)
+	}
+	if proxy.port != port {
+		t.Errorf("Expected port %s, got %s", port, proxy.port)
+	}
+}
+
+func TestAuthenticateRequest(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	tests := []struct {
+		name           string
+		auth           string
+		expectedResult bool
+	}{
+		{
+			name:           "Valid credentials",
+			auth:           "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:password123")),
+			expectedResult: true,
+		},
+		{
+			name:           "Invalid username",
+			auth:           "Basic " + base64.StdEncoding.EncodeToString([]byte("wrong:password123")),
+			expectedResult: false,
+		},
+		{
+			name:           "Invalid password",
+			auth:           "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:wrong")),
+			expectedResult: false,
+		},
+		{
+			name:           "No auth header",
+			auth:           "",
+			expectedResult: false,
+		},
+		{
+			name:           "Invalid auth type",
+			auth:           "Bearer token123",
+			expectedResult: false,
+		},
+		{
+			name:           "Malformed basic auth",
+			auth:           "Basic invalid-base64",
+			expectedResult: false,
+		},
+		{
+			name:           "Basic auth without colon",
+			auth:           "Basic " + base64.StdEncoding.EncodeToString([]byte("adminpassword")),
+			expectedResult: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com", nil)
+			if tt.auth != "" {
+				req.Header.Set("Proxy-Authorization", tt.auth)
+			}
+
+			result := proxy.authenticateRequest(req)
+			if result != tt.expectedResult {
+				t.Errorf("Expected %v, got %v", tt.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestHandleHTTP_Authentication(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	// Test without authentication
+	t.Run("No authentication", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		w := httptest.NewRecorder()
+
+		proxy.handleHTTP(w, req)
+
+		if w.Code != http.StatusProxyAuthRequired {
+			t.Errorf("Expected status %d, got %d", http.StatusProxyAuthRequired, w.Code)
+		}
+
+		if w.Header().Get("Proxy-Authenticate") == "" {
+			t.Error("Expected Proxy-Authenticate header to be set")
+		}
+	})
+
+	// Test with invalid authentication
+	t.Run("Invalid authentication", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("wrong:credentials")))
+		w := httptest.NewRecorder()
+
+		proxy.handleHTTP(w, req)
+
+		if w.Code != http.StatusProxyAuthRequired {
+			t.Errorf("Expected status %d, got %d", http.StatusProxyAuthRequired, w.Code)
+		}
+	})
+}
+
+func TestHandleHTTP_AuthFailureDrainsBody(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer backendServer.Close()
+
+	port := freePort(t)
+	proxy := NewProxyServer("admin", "password123", port)
+
+	rejected := strings.NewReader("rejected")
+	req := httptest.NewRequest("POST", backendServer.URL, rejected)
+	proxy.handleHTTP(httptest.NewRecorder(), req)
+	if rejected.Len() != 0 {
+		t.Errorf("Expected the rejected body to be drained, %d bytes left", rejected.Len())
+	}
+
+	go proxy.Start()
+	defer proxy.Shutdown(context.Background())
+	waitForListener(t, "127.0.0.1:"+port)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	send := func(auth, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("POST", backendServer.URL, strings.NewReader(body))
+		req.Header.Set("Proxy-Authorization", auth)
+		if err := req.WriteProxy(conn); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.ReadResponse(reader, req)
+		if err != nil {
+			t.Fatalf("Reading response failed: %v", err)
+		}
+		return resp
+	}
+
+	resp := send(CreateBasicAuth("admin", "wrong"), strings.Repeat("x", 64<<10))
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Fatalf("Expected status %d, got %d", http.StatusProxyAuthRequired, resp.StatusCode)
+	}
+	if resp.Close {
+		t.Error("Expected the connection to stay open after the 407")
+	}
+
+	// The retry must reuse the same connection
+	resp = send(CreateBasicAuth("admin", "password123"), "retry")
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if string(body) != "retry" {
+		t.Errorf("Expected %q, got %q", "retry", body)
+	}
+}
+
+func TestRealm(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.Realm = "Corp Egress"
+
+	tests := []struct {
+		method string
+		target string
+	}{
+		{"GET", "http://example.com"},
+		{"CONNECT", "example.com:443"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			w := httptest.NewRecorder()
+
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != http.StatusProxyAuthRequired {
+				t.Errorf("Expected status %d, got %d", http.StatusProxyAuthRequired, w.Code)
+			}
+			expected := `Basic realm="Corp Egress"`
+			if got := w.Header().Get("Proxy-Authenticate"); got != expected {
+				t.Errorf("Expected %q, got %q", expected, got)
+			}
+		})
+	}
+}
+
+func TestHandleHTTP_ValidRequest(t *testing.T) {
+	// Create a test server to simulate the target
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, `{"message": "success", "method": "`+r.Method+`"}`)
+	}))
+	defer targetServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	tests := []struct {
+		name   string
+		method string
+	}{
+		{"GET request", "GET"},
+		{"POST request", "POST"},
+		{"PUT request", "PUT"},
+		{"DELETE request", "DELETE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader
+			if tt.method == "POST" || tt.method == "PUT" {
+				body = strings.NewReader(`{"test": "data"}`)
+			}
+
+			req := httptest.NewRequest(tt.method, targetServer.URL, body)
+			req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:password123")))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			proxy.handleHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+
+			// Check if proxy-specific headers are removed
+			if req.Header.Get("Proxy-Authorization") != "" {
+				t.Error("Proxy-Authorization header should be removed")
+			}
+
+			// Check response
+			responseBody := w.Body.String()
+			if !strings.Contains(responseBody, tt.method) {
+				t.Errorf("Response should contain method %s", tt.method)
+			}
+		})
+	}
+}
+
+func TestHandleHTTP_InvalidURL(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	req := httptest.NewRequest("GET", "http://invalid-url-that-does-not-exist.local", nil)
+	req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:password123")))
+	w := httptest.NewRecorder()
+
+	proxy.handleHTTP(w, req)
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Expected status %d, got %d", http.StatusBadGateway, w.Code)
+	}
+}
+
+func TestHandleHTTPS_Authentication(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	// Test CONNECT without authentication
+	t.Run("CONNECT without auth", func(t *testing.T) {
+		req := httptest.NewRequest("CONNECT", "example.com:443", nil)
+		w := httptest.NewRecorder()
+
+		proxy.handleHTTPS(w, req)
+
+		if w.Code != http.StatusProxyAuthRequired {
+			t.Errorf("Expected status %d, got %d", http.StatusProxyAuthRequired, w.Code)
+		}
+	})
+
+	// Test CONNECT with invalid authentication
+	t.Run("CONNECT with invalid auth", func(t *testing.T) {
+		req := httptest.NewRequest("CONNECT", "example.com:443", nil)
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("wrong:credentials")))
+		w := httptest.NewRecorder()
+
+		proxy.handleHTTPS(w, req)
+
+		if w.Code != http.StatusProxyAuthRequired {
+			t.Errorf("Expected status %d, got %d", http.StatusProxyAuthRequired, w.Code)
+		}
+	})
+}
+
+func TestHandleHTTPS_InvalidHost(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	req := httptest.NewRequest("CONNECT", "invalid-host-that-does-not-exist.local:443", nil)
+	req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:password123")))
+	w := httptest.NewRecorder()
+
+	proxy.handleHTTPS(w, req)
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Expected status %d, got %d", http.StatusBadGateway, w.Code)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	// Test HTTP method routing
+	t.Run("HTTP GET routing", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		w := httptest.NewRecorder()
+
+		proxy.ServeHTTP(w, req)
+
+		// Should be handled by handleHTTP and require auth
+		if w.Code != http.StatusProxyAuthRequired {
+			t.Errorf("Expected status %d, got %d", http.StatusProxyAuthRequired, w.Code)
+		}
+	})
+
+	// Test CONNECT method routing
+	t.Run("CONNECT routing", func(t *testing.T) {
+		req := httptest.NewRequest("CONNECT", "example.com:443", nil)
+		w := httptest.NewRecorder()
+
+		proxy.ServeHTTP(w, req)
+
+		// Should be handled by handleHTTPS and require auth
+		if w.Code != http.StatusProxyAuthRequired {
+			t.Errorf("Expected status %d, got %d", http.StatusProxyAuthRequired, w.Code)
+		}
+	})
+}
+
+func TestServeHTTP_LogsStatusAndDuration(t *testing.T) {
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer targetServer.Close()
+
+	logs := captureLog(t)
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	req := httptest.NewRequest("GET", targetServer.URL, nil)
+	req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:password123")))
+	w := httptest.NewRecorder()
+
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	prefix := "GET " + targetServer.URL + " "
+	var line string
+	for _, l := range strings.Split(logs.String(), "\n") {
+		if i := strings.Index(l, prefix); i >= 0 {
+			line = l[i+len(prefix):]
+		}
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		t.Fatalf("Expected status, duration and reuse after %q, got %q", prefix, logs.String())
+	}
+	if fields[0] != "200" {
+		t.Errorf("Expected status 200, got %s", fields[0])
+	}
+	if _, err := time.ParseDuration(fields[1]); err != nil {
+		t.Errorf("Expected a duration, got %q: %v", fields[1], err)
+	}
+	if fields[2] != "reused=false" {
+		t.Errorf("Expected reused=false for the first request, got %s", fields[2])
+	}
+}
+
+func TestServeHTTP_LogsConnectionReuse(t *testing.T) {
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer targetServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	for _, expected := range []string{"reused=false", "reused=true"} {
+		logs := captureLog(t)
+		req := httptest.NewRequest("GET", targetServer.URL, nil)
+		req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+		proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+		if !strings.Contains(logs.String(), " "+expected+"\n") {
+			t.Errorf("Expected log line ending in %s, got %q", expected, logs.String())
+		}
+	}
+}
+
+func TestHeaderHandling(t *testing.T) {
+	// Create a test server that echoes headers
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Echo-User-Agent", r.Header.Get("User-Agent"))
+		w.Header().Set("Echo-Custom-Header", r.Header.Get("Custom-Header"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer targetServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	req := httptest.NewRequest("GET", targetServer.URL, nil)
+	req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:password123")))
+	req.Header.Set("User-Agent", "TestAgent/1.0")
+	req.Header.Set("Custom-Header", "CustomValue")
+	req.Header.Set("Proxy-Connection", "keep-alive")
+	w := httptest.NewRecorder()
+
+	proxy.handleHTTP(w, req)
+
+	// Check that custom headers are preserved
+	if w.Header().Get("Echo-User-Agent") != "TestAgent/1.0" {
+		t.Error("User-Agent header was not properly forwarded")
+	}
+	if w.Header().Get("Echo-Custom-Header") != "CustomValue" {
+		t.Error("Custom header was not properly forwarded")
+	}
+
+	// Check that proxy-specific headers were removed
+	if req.Header.Get("Proxy-Authorization") != "" {
+		t.Error("Proxy-Authorization header should be removed")
+	}
+	if req.Header.Get("Proxy-Connection") != "" {
+		t.Error("Proxy-Connection header should be removed")
+	}
+}
+
+func TestHandleHTTP_ClientCancelAbortsUpstream(t *testing.T) {
+	entered := make(chan struct{})
+	aborted := make(chan struct{})
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		select {
+		case <-r.Context().Done():
+			close(aborted)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := httptest.NewRequest("GET", backendServer.URL, nil).WithContext(ctx)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+
+	done := make(chan struct{})
+	go func() {
+		proxy.handleHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+
+	<-entered
+	cancel()
+	select {
+	case <-aborted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the upstream request to be aborted when the client went away")
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected handleHTTP to return after the client went away")
+	}
+}
+
+func TestRequestBodyHandling(t *testing.T) {
+	// Create a test server that echoes the request body
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}))
+	defer targetServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	testBody := `{"test": "data", "number": 123}`
+	req := httptest.NewRequest("POST", targetServer.URL, bytes.NewReader([]byte(testBody)))
+	req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:password123")))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	proxy.handleHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	responseBody := w.Body.String()
+	if responseBody != testBody {
+		t.Errorf("Expected body %s, got %s", testBody, responseBody)
+	}
+}
+
+func TestForwardedHeaders_IPv6Zone(t *testing.T) {
+	var forwardedFor string
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedFor = r.Header.Get("X-Forwarded-For")
+	}))
+	defer targetServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	req := httptest.NewRequest("GET", targetServer.URL, nil)
+	req.RemoteAddr = "[fe80::1%eth0]:1234"
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	proxy.handleHTTP(httptest.NewRecorder(), req)
+
+	if forwardedFor != "fe80::1" {
+		t.Errorf("Expected X-Forwarded-For fe80::1 without the zone, got %q", forwardedFor)
+	}
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	// Create a test server that echoes the forwarding headers
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Echo-Forwarded-For", r.Header.Get("X-Forwarded-For"))
+		w.Header().Set("Echo-Forwarded-Proto", r.Header.Get("X-Forwarded-Proto"))
+		w.Header().Set("Echo-Forwarded-Host", r.Header.Get("X-Forwarded-Host"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer targetServer.Close()
+
+	tests := []struct {
+		name        string
+		enabled     bool
+		priorHeader string
+		expectedXFF string
+	}{
+		{"No existing header", true, "", "192.0.2.1"},
+		{"Existing header is appended", true, "203.0.113.7", "203.0.113.7, 192.0.2.1"},
+		{"Disabled for anonymity", false, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.ForwardedHeaders = tt.enabled
+
+			req := httptest.NewRequest("GET", targetServer.URL, nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:password123")))
+			if tt.priorHeader != "" {
+				req.Header.Set("X-Forwarded-For", tt.priorHeader)
+			}
+			w := httptest.NewRecorder()
+
+			proxy.handleHTTP(w, req)
+
+			if got := w.Header().Get("Echo-Forwarded-For"); got != tt.expectedXFF {
+				t.Errorf("Expected X-Forwarded-For %q, got %q", tt.expectedXFF, got)
+			}
+			if !tt.enabled {
+				return
+			}
+			if got := w.Header().Get("Echo-Forwarded-Proto"); got != "http" {
+				t.Errorf("Expected X-Forwarded-Proto http, got %q", got)
+			}
+			if got := w.Header().Get("Echo-Forwarded-Host"); got != req.Host {
+				t.Errorf("Expected X-Forwarded-Host %s, got %q", req.Host, got)
+			}
+		})
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", freePort(t))
+	proxyAddr := "127.0.0.1:" + proxy.port
+
+	done := make(chan error, 1)
+	go func() {
+		done <- proxy.Start()
+	}()
+	waitForListener(t, proxyAddr)
+
+	// Fire a proxied request before shutting down
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nProxy-Authorization: %s\r\nConnection: close\r\n\r\n",
+		backendServer.URL, strings.TrimPrefix(backendServer.URL, "http://"), CreateBasicAuth("admin", "password123"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := proxy.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown returned error: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != http.ErrServerClosed {
+			t.Errorf("Expected %v, got %v", http.ErrServerClosed, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after Shutdown")
+	}
+}
+
+func TestAddr_ReportsAssignedPort(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "0")
+	proxy.RandomPort = true
+	if addr := proxy.Addr(); addr != nil {
+		t.Errorf("Expected nil address before Start, got %v", addr)
+	}
+	go proxy.Start()
+	defer proxy.Shutdown(context.Background())
+
+	addr, ok := waitForAddr(t, proxy).(*net.TCPAddr)
+	if !ok {
+		t.Fatalf("Expected a TCP address, got %T", proxy.Addr())
+	}
+	if addr.Port == 0 {
+		t.Fatal("Expected a non-zero port")
+	}
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", addr.Port))
+	if err != nil {
+		t.Fatalf("Expected proxy to accept on %v, got %v", addr, err)
+	}
+	conn.Close()
+}
+
+func TestBindAddress(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "0")
+	proxy.RandomPort = true
+	proxy.BindAddress = "127.0.0.1"
+	proxy.AdminPort = "0"
+	go proxy.Start()
+	defer proxy.Shutdown(context.Background())
+
+	addr := waitForAddr(t, proxy).(*net.TCPAddr)
+	if !addr.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("Expected proxy bound to 127.0.0.1, got %v", addr.IP)
+	}
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatalf("Expected proxy to accept on loopback, got %v", err)
+	}
+	conn.Close()
+
+	// The admin listener starts right after the proxy listener is bound
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		proxy.mu.Lock()
+		adminAddr := proxy.adminAddr
+		proxy.mu.Unlock()
+		if adminAddr != nil {
+			if ip := adminAddr.(*net.TCPAddr).IP; !ip.Equal(net.IPv4(127, 0, 0, 1)) {
+				t.Errorf("Expected admin listener bound to 127.0.0.1, got %v", ip)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Admin listener did not come up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "0")
+	proxy.RandomPort = true
+	proxy.BindAddress = "127.0.0.1"
+	proxy.ReadHeaderTimeout = 200 * time.Millisecond
+	go proxy.Start()
+	defer proxy.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", waitForAddr(t, proxy).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Send part of the headers, then stall like a slowloris client
+	start := time.Now()
+	if _, err := io.WriteString(conn, "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, conn)
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Expected the connection to be closed after about 200ms, took %v", elapsed)
+	}
+}
+
+func TestShutdown_ClosesTunnels(t *testing.T) {
+	// Create an echo server to tunnel to
+	echoListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echoListener.Close()
+	go func() {
+		for {
+			c, err := echoListener.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(c, c)
+		}
+	}()
+
+	proxy := NewProxyServer("admin", "password123", freePort(t))
+	allowConnectPort(t, proxy, echoListener.Addr().String())
+	proxyAddr := "127.0.0.1:" + proxy.port
+	go proxy.Start()
+	waitForListener(t, proxyAddr)
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	target := echoListener.Addr().String()
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\nProxy-Authorization: %s\r\n\r\n",
+		target, target, CreateBasicAuth("admin", "password123"))
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	proxy.Shutdown(ctx)
+
+	// The tunnel should be closed by Shutdown
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected tunnel to be closed with EOF, got %v", err)
+	}
+}
+
+func BenchmarkAuthenticateRequest(b *testing.B) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:password123")))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		proxy.authenticateRequest(req)
+	}
+}
+
+func BenchmarkHandleHTTP(b *testing.B) {
+	// Create a simple test server
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "OK")
+	}))
+	defer targetServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest("GET", targetServer.URL, nil)
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:password123")))
+		w := httptest.NewRecorder()
+
+		proxy.handleHTTP(w, req)
+	}
+}
+
+// Integration test for the complete proxy flow
+func TestShutdown_TunnelGracePeriod(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	proxy := NewProxyServer("admin", "password123", "0")
+	proxy.RandomPort = true
+	proxy.TunnelGracePeriod = 400 * time.Millisecond
+	allowConnectPort(t, proxy, echoAddr)
+	go proxy.Start()
+	proxyAddr := waitForAddr(t, proxy).String()
+
+	conn, reader, resp := openTunnel(t, proxyAddr, echoAddr)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- proxy.Shutdown(context.Background())
+	}()
+
+	// The tunnel keeps working during the grace period
+	time.Sleep(100 * time.Millisecond)
+	io.WriteString(conn, "ping")
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		t.Fatalf("Expected tunnel to stay open during the grace period, got %v", err)
+	}
+
+	// and is closed once it expires
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected tunnel to be closed with EOF, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Errorf("Expected tunnel to be closed after the 400ms grace period, took %v", elapsed)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Shutdown returned error: %v", err)
+	}
+}
+
+func TestShutdown_DrainedTunnelsEndGracePeriod(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	proxy := NewProxyServer("admin", "password123", "0")
+	proxy.RandomPort = true
+	proxy.TunnelGracePeriod = time.Minute
+	allowConnectPort(t, proxy, echoAddr)
+	go proxy.Start()
+	proxyAddr := waitForAddr(t, proxy).String()
+
+	conn, _, resp := openTunnel(t, proxyAddr, echoAddr)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- proxy.Shutdown(context.Background())
+	}()
+	time.Sleep(100 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Shutdown to return once the last tunnel closed")
+	}
+}
+
+func TestProxyIntegration(t *testing.T) {
+	// Create a test backend server
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Backend-Header", "backend-value")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "Backend response: %s %s", r.Method, r.URL.Path)
+	}))
+	defer backendServer.Close()
+
+	// Create proxy server
+	proxy := NewProxyServer("testuser", "testpass", "0")
+	proxy.RandomPort = true
+
+	// Create request to backend through proxy
+	req, err := http.NewRequest("GET", backendServer.URL+"/test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Set proxy authorization
+	req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("testuser:testpass")))
+
+	// Make request through proxy
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	// Verify response
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	// Check that backend headers are preserved
+	if w.Header().Get("Backend-Header") != "backend-value" {
+		t.Error("Backend header was not preserved")
+	}
+
+	// Check response body
+	expectedBody := "Backend response: GET /test"
+	if w.Body.String() != expectedBody {
+		t.Errorf("Expected body %s, got %s", expectedBody, w.Body.String())
+	}
+}
+
+func TestMaxURLLength(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	if proxy.MaxURLLength != 8192 {
+		t.Errorf("Expected default max URL length 8192, got %d", proxy.MaxURLLength)
+	}
+	proxy.MaxURLLength = 64
+
+	prefix := backendServer.URL + "/"
+	tests := []struct {
+		name     string
+		length   int
+		expected int
+	}{
+		{"At limit", 64, http.StatusOK},
+		{"Over limit", 65, http.StatusRequestURITooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", prefix+strings.Repeat("a", tt.length-len(prefix)), nil)
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+			w := httptest.NewRecorder()
+			proxy.handleHTTP(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestMaxHeaderBytes(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", freePort(t))
+	proxy.MaxHeaderBytes = 1024
+	proxyAddr := "127.0.0.1:" + proxy.port
+	go proxy.Start()
+	defer proxy.Shutdown(context.Background())
+	waitForListener(t, proxyAddr)
+
+	tests := []struct {
+		name     string
+		size     int
+		expected int
+	}{
+		{"Within limit", 256, http.StatusOK},
+		// The server allows some slack past MaxHeaderBytes before rejecting
+		{"Oversized", 64 << 10, http.StatusRequestHeaderFieldsTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", proxyAddr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nProxy-Authorization: %s\r\nX-Padding: %s\r\nConnection: close\r\n\r\n",
+				backendServer.URL, strings.TrimPrefix(backendServer.URL, "http://"),
+				CreateBasicAuth("admin", "password123"), strings.Repeat("a", tt.size))
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, resp.StatusCode)
+			}
+		})
+	}
+}
+
+// sendExpectContinue sends a request carrying Expect: 100-continue to the
+// proxy at addr and returns the first response. The body is only sent if
+// that response is 100 Continue, in which case the final one is returned.
+func sendExpectContinue(t *testing.T, addr, method, target string, body []byte) (continued bool, resp *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "%s %s HTTP/1.1\r\nHost: %s\r\nProxy-Authorization: %s\r\nExpect: 100-continue\r\nContent-Length: %d\r\n\r\n",
+		method, target, strings.SplitN(strings.TrimPrefix(target, "http://"), "/", 2)[0], CreateBasicAuth("admin", "password123"), len(body))
+
+	reader := bufio.NewReader(conn)
+	resp, err = http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusContinue {
+		return false, resp
+	}
+	conn.Write(body)
+	resp, err = http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return true, resp
+}
+
+func TestForward_ExpectContinue(t *testing.T) {
+	body := bytes.Repeat([]byte("upload "), 300)
+
+	tests := []struct {
+		name      string
+		method    string
+		configure func(*Server)
+	}{
+		{"POST", "POST", func(*Server) {}},
+		{"PUT with retries", "PUT", func(ps *Server) { ps.MaxRetries = 2 }},
+		{"Encoded body", "POST", func(ps *Server) { ps.EncodeRequestBodies = true }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var expect string
+			var received []byte
+			backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/reject" {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				expect = r.Header.Get("Expect")
+				var reader io.Reader = r.Body
+				if r.Header.Get("Content-Encoding") == "gzip" {
+					reader, _ = gzip.NewReader(r.Body)
+				}
+				received, _ = io.ReadAll(reader)
+			}))
+			defer backendServer.Close()
+
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.ExpectContinueTimeout = 5 * time.Second
+			tt.configure(proxy)
+			server := httptest.NewServer(proxy)
+			defer server.Close()
+			addr := server.Listener.Addr().String()
+
+			// The upstream agrees, so the body is sent after its 100 Continue
+			continued, resp := sendExpectContinue(t, addr, tt.method, backendServer.URL+"/accept", body)
+			if !continued {
+				t.Fatalf("Expected 100 Continue, got %d", resp.StatusCode)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+			}
+			if expect != "100-continue" {
+				t.Errorf("Expected the upstream to see Expect: 100-continue, got %q", expect)
+			}
+			if !bytes.Equal(received, body) {
+				t.Errorf("Expected the upstream to receive %d bytes, got %d", len(body), len(received))
+			}
+
+			// The upstream refuses, so the client never sends the body
+			continued, resp = sendExpectContinue(t, addr, tt.method, backendServer.URL+"/reject", body)
+			if continued {
+				t.Error("Expected no 100 Continue when the upstream refuses the body")
+			}
+			if resp.StatusCode != http.StatusForbidden {
+				t.Errorf("Expected status %d, got %d", http.StatusForbidden, resp.StatusCode)
+			}
+		})
+	}
+}
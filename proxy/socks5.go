@@ -0,0 +1,287 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SOCKS5 protocol constants from RFC 1928 and RFC 1929
+const (
+	socks5Version = 0x05
+
+	socks5MethodUserPass     = 0x02
+	socks5MethodNoAcceptable = 0xff
+
+	socks5AuthVersion = 0x01
+	socks5AuthSuccess = 0x00
+	socks5AuthFailure = 0x01
+
+	socks5CmdConnect = 0x01
+
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+
+	socks5ReplySucceeded          = 0x00
+	socks5ReplyGeneralFailure     = 0x01
+	socks5ReplyNotAllowed         = 0x02
+	socks5ReplyHostUnreachable    = 0x04
+	socks5ReplyCommandUnsupported = 0x07
+	socks5ReplyAddrUnsupported    = 0x08
+)
+
+// socks5HandshakeTimeout bounds the negotiation before a tunnel is established
+const socks5HandshakeTimeout = 30 * time.Second
+
+// Errors for SOCKS5 requests the proxy does not support
+var (
+	errSOCKS5Command  = errors.New("unsupported command")
+	errSOCKS5AddrType = errors.New("unsupported address type")
+	errSOCKS5Auth     = errors.New("authentication failed")
+)
+
+// StartSOCKS5 starts a SOCKS5 listener on port. It authenticates clients
+// with the same credentials as the HTTP proxy and supports the CONNECT
+// command only. It can run alongside Start or StartTLS.
+func (ps *Server) StartSOCKS5(port string) error {
+	if err := ps.validateListenPort(port); err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(ps.BindAddress, port))
+	if err != nil {
+		return err
+	}
+
+	ps.mu.Lock()
+	if ps.shutdown {
+		ps.mu.Unlock()
+		ln.Close()
+		return net.ErrClosed
+	}
+	ps.socksListener = ln
+	ps.mu.Unlock()
+
+	if err := ps.writeManifest(); err != nil {
+		log.Printf("Error writing manifest: %v", err)
+	}
+
+	log.Printf("Starting SOCKS5 Proxy Server on port %s", port)
+	return ps.serveSOCKS5(ln)
+}
+
+// serveSOCKS5 accepts SOCKS5 clients on ln until it is closed
+func (ps *Server) serveSOCKS5(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			ps.mu.Lock()
+			shutdown := ps.shutdown
+			ps.mu.Unlock()
+			if shutdown {
+				return nil
+			}
+			return err
+		}
+		go ps.handleSOCKS5(conn)
+	}
+}
+
+// handleSOCKS5 negotiates a SOCKS5 session on conn and tunnels it to the
+// requested destination
+func (ps *Server) handleSOCKS5(conn net.Conn) {
+	defer conn.Close()
+
+	if !ps.acquireConnection() {
+		return
+	}
+	defer ps.releaseConnection()
+
+	ip := tcpAddrIP(conn.RemoteAddr())
+	if !ps.ipAllowed(ip) || ps.clientBanned(ip) {
+		return
+	}
+
+	conn.SetDeadline(time.Now().Add(socks5HandshakeTimeout))
+	reader := bufio.NewReader(conn)
+
+	username, err := ps.socks5Authenticate(conn, reader)
+	if err != nil {
+		if errors.Is(err, errSOCKS5Auth) {
+			ps.metrics.recordAuthFailure()
+			ps.recordAuthFailure(ip)
+		}
+		log.Printf("SOCKS5 %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	target, err := readSOCKS5Request(reader)
+	if err != nil {
+		reply := byte(socks5ReplyGeneralFailure)
+		if errors.Is(err, errSOCKS5AddrType) {
+			reply = socks5ReplyAddrUnsupported
+		} else if errors.Is(err, errSOCKS5Command) {
+			reply = socks5ReplyCommandUnsupported
+		}
+		writeSOCKS5Reply(conn, reply)
+		log.Printf("SOCKS5 %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	_, port, err := connectTarget(target)
+	if err != nil || !ps.connectPortAllowed(port) || ps.domainBlocked(target) || !ps.userMayReach(username, target) {
+		writeSOCKS5Reply(conn, socks5ReplyNotAllowed)
+		return
+	}
+
+	destConn, err := ps.dialConnect(target)
+	if err != nil {
+		writeSOCKS5Reply(conn, socks5ReplyHostUnreachable)
+		return
+	}
+	defer destConn.Close()
+
+	if !ps.trackTunnel(conn) {
+		writeSOCKS5Reply(conn, socks5ReplyGeneralFailure)
+		return
+	}
+	defer ps.untrackTunnel(conn)
+
+	if err := writeSOCKS5Reply(conn, socks5ReplySucceeded); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	ctx := withPolicyTag(context.Background(), ps.policyTag(username))
+	ps.pipe(ctx, conn, reader, destConn, target, username)
+}
+
+// socks5Authenticate performs method negotiation and username/password
+// authentication, the only method the proxy accepts. It returns the
+// authenticated username.
+func (ps *Server) socks5Authenticate(w io.Writer, r *bufio.Reader) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+	if header[0] != socks5Version {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return "", err
+	}
+
+	offered := false
+	for _, method := range methods {
+		if method == socks5MethodUserPass {
+			offered = true
+		}
+	}
+	if !offered {
+		w.Write([]byte{socks5Version, socks5MethodNoAcceptable})
+		return "", errors.New("client does not offer username/password authentication")
+	}
+	if _, err := w.Write([]byte{socks5Version, socks5MethodUserPass}); err != nil {
+		return "", err
+	}
+
+	version, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	if version != socks5AuthVersion {
+		return "", fmt.Errorf("unsupported authentication version %d", version)
+	}
+	username, err := readSOCKS5String(r)
+	if err != nil {
+		return "", err
+	}
+	password, err := readSOCKS5String(r)
+	if err != nil {
+		return "", err
+	}
+
+	if !ps.checkCredentials(username, password) {
+		w.Write([]byte{socks5AuthVersion, socks5AuthFailure})
+		return "", fmt.Errorf("%w for %q", errSOCKS5Auth, username)
+	}
+	_, err = w.Write([]byte{socks5AuthVersion, socks5AuthSuccess})
+	return username, err
+}
+
+// readSOCKS5Request reads a CONNECT request and returns its host:port target
+func readSOCKS5Request(r *bufio.Reader) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+	if header[0] != socks5Version {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	if header[1] != socks5CmdConnect {
+		return "", fmt.Errorf("%w %d", errSOCKS5Command, header[1])
+	}
+
+	var host string
+	switch header[3] {
+	case socks5AddrIPv4, socks5AddrIPv6:
+		size := net.IPv4len
+		if header[3] == socks5AddrIPv6 {
+			size = net.IPv6len
+		}
+		ip := make(net.IP, size)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socks5AddrDomain:
+		domain, err := readSOCKS5String(r)
+		if err != nil {
+			return "", err
+		}
+		host = domain
+	default:
+		return "", fmt.Errorf("%w %d", errSOCKS5AddrType, header[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))), nil
+}
+
+// readSOCKS5String reads a single length-prefixed string
+func readSOCKS5String(r *bufio.Reader) (string, error) {
+	size, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// writeSOCKS5Reply sends a reply with an unspecified bound address
+func writeSOCKS5Reply(w io.Writer, reply byte) error {
+	_, err := w.Write([]byte{socks5Version, reply, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// tcpAddrIP returns the IP of a TCP address, or nil for other address kinds
+func tcpAddrIP(addr net.Addr) net.IP {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	return nil
+}
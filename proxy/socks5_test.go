@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startSOCKS5Proxy runs proxy's SOCKS5 listener on a free port and returns
+// its address
+func startSOCKS5Proxy(t *testing.T, proxy *Server) string {
+	t.Helper()
+	port := freePort(t)
+	go proxy.StartSOCKS5(port)
+	t.Cleanup(func() { proxy.Shutdown(context.Background()) })
+
+	addr := "127.0.0.1:" + port
+	waitForListener(t, addr)
+	return addr
+}
+
+// socks5Connect performs the SOCKS5 handshake with username/password
+// authentication and sends a CONNECT request for the encoded address
+func socks5Connect(t *testing.T, proxyAddr, username, password string, addr []byte) (net.Conn, byte, byte) {
+	t.Helper()
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte{socks5Version, 1, socks5MethodUserPass})
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(conn, method); err != nil {
+		t.Fatal(err)
+	}
+	if method[1] != socks5MethodUserPass {
+		t.Fatalf("Expected method %d, got %d", socks5MethodUserPass, method[1])
+	}
+
+	auth := []byte{socks5AuthVersion, byte(len(username))}
+	auth = append(auth, username...)
+	auth = append(auth, byte(len(password)))
+	auth = append(auth, password...)
+	conn.Write(auth)
+	status := make([]byte, 2)
+	if _, err := io.ReadFull(conn, status); err != nil {
+		t.Fatal(err)
+	}
+	if status[1] != socks5AuthSuccess {
+		return conn, status[1], 0
+	}
+
+	conn.Write(append([]byte{socks5Version, socks5CmdConnect, 0x00}, addr...))
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	return conn, status[1], reply[1]
+}
+
+// socks5Addr encodes an address type, address and port for a request
+func socks5Addr(t *testing.T, addrType byte, host, port string) []byte {
+	t.Helper()
+	var addr []byte
+	switch addrType {
+	case socks5AddrIPv4:
+		addr = append([]byte{addrType}, net.ParseIP(host).To4()...)
+	case socks5AddrIPv6:
+		addr = append([]byte{addrType}, net.ParseIP(host).To16()...)
+	case socks5AddrDomain:
+		addr = append([]byte{addrType, byte(len(host))}, host...)
+	default:
+		addr = []byte{addrType}
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(addr, byte(p>>8), byte(p))
+}
+
+// startEchoServerOn starts an echo server on network, skipping the test if
+// the address family is unavailable
+func startEchoServerOn(t *testing.T, network, address string) string {
+	t.Helper()
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		t.Skipf("%s unavailable: %v", network, err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestSOCKS5_Connect(t *testing.T) {
+	tests := []struct {
+		name     string
+		network  string
+		listen   string
+		addrType byte
+		host     string
+	}{
+		{"IPv4", "tcp4", "127.0.0.1:0", socks5AddrIPv4, "127.0.0.1"},
+		{"IPv6", "tcp6", "[::1]:0", socks5AddrIPv6, "::1"},
+		{"domain", "tcp4", "127.0.0.1:0", socks5AddrDomain, "echo.test"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			echoAddr := startEchoServerOn(t, tt.network, tt.listen)
+			_, echoPort, _ := net.SplitHostPort(echoAddr)
+
+			proxy := NewProxyServer("admin", "password123", "8080")
+			allowConnectPort(t, proxy, echoAddr)
+			proxy.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+				if host != "echo.test" {
+					t.Errorf("Unexpected lookup of %s", host)
+				}
+				return []string{"127.0.0.1"}, nil
+			}
+			proxyAddr := startSOCKS5Proxy(t, proxy)
+
+			conn, authStatus, reply := socks5Connect(t, proxyAddr, "admin", "password123", socks5Addr(t, tt.addrType, tt.host, echoPort))
+			if authStatus != socks5AuthSuccess {
+				t.Fatalf("Expected auth status %d, got %d", socks5AuthSuccess, authStatus)
+			}
+			if reply != socks5ReplySucceeded {
+				t.Fatalf("Expected reply %d, got %d", socks5ReplySucceeded, reply)
+			}
+
+			conn.Write([]byte("hello socks\n"))
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line != "hello socks\n" {
+				t.Errorf("Expected echo %q, got %q", "hello socks\n", line)
+			}
+		})
+	}
+}
+
+func TestSOCKS5_Rejections(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	_, echoPort, _ := net.SplitHostPort(echoAddr)
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxyAddr := startSOCKS5Proxy(t, proxy)
+
+	tests := []struct {
+		name          string
+		password      string
+		addr          []byte
+		expectedAuth  byte
+		expectedReply byte
+	}{
+		{"wrong password", "wrong", nil, socks5AuthFailure, 0},
+		{"disallowed port", "password123", socks5Addr(t, socks5AddrIPv4, "127.0.0.1", echoPort), socks5AuthSuccess, socks5ReplyNotAllowed},
+		{"unknown address type", "password123", socks5Addr(t, 0x09, "", "443"), socks5AuthSuccess, socks5ReplyAddrUnsupported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, authStatus, reply := socks5Connect(t, proxyAddr, "admin", tt.password, tt.addr)
+			if authStatus != tt.expectedAuth {
+				t.Errorf("Expected auth status %d, got %d", tt.expectedAuth, authStatus)
+			}
+			if reply != tt.expectedReply {
+				t.Errorf("Expected reply %d, got %d", tt.expectedReply, reply)
+			}
+		})
+	}
+}
+
+func TestSOCKS5_AuthBan(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	_, echoPort, _ := net.SplitHostPort(echoAddr)
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	allowConnectPort(t, proxy, echoAddr)
+	proxy.AuthBan = &AuthBan{MaxFailures: 3, Window: time.Minute, Duration: 5 * time.Minute}
+	var clock atomic.Int64
+	clock.Store(1700000000)
+	proxy.now = func() time.Time { return time.Unix(clock.Load(), 0) }
+	proxyAddr := startSOCKS5Proxy(t, proxy)
+
+	for i := 0; i < 3; i++ {
+		if _, status, _ := socks5Connect(t, proxyAddr, "admin", "wrong", nil); status != socks5AuthFailure {
+			t.Fatalf("Expected auth status %d for failure %d, got %d", socks5AuthFailure, i+1, status)
+		}
+	}
+
+	// The banned client is disconnected before method negotiation, even
+	// with valid credentials
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte{socks5Version, 1, socks5MethodUserPass})
+	if _, err := io.ReadFull(conn, make([]byte, 2)); err == nil {
+		t.Error("Expected the banned client to be disconnected")
+	}
+
+	clock.Add(int64((5 * time.Minute).Seconds()))
+	_, status, reply := socks5Connect(t, proxyAddr, "admin", "password123", socks5Addr(t, socks5AddrIPv4, "127.0.0.1", echoPort))
+	if status != socks5AuthSuccess || reply != socks5ReplySucceeded {
+		t.Errorf("Expected the ban to lift after the cooldown, got auth %d reply %d", status, reply)
+	}
+}
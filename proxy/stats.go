@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Stats is a snapshot of the proxy's activity served as JSON on /stats
+type Stats struct {
+	ActiveConnections int64   `json:"active_connections"`
+	ActiveTunnels     int     `json:"active_tunnels"`
+	Requests          int64   `json:"requests_total"`
+	BytesUpstream     int64   `json:"bytes_upstream"`
+	BytesDownstream   int64   `json:"bytes_downstream"`
+	UptimeSeconds     float64 `json:"uptime_seconds"`
+	// Users holds the traffic of the user requesting the stats. Other
+	// users' traffic is not disclosed.
+	Users map[string]UserBytes `json:"users"`
+}
+
+// stats reads the current counters shared with the Prometheus metrics, with
+// the traffic of user
+func (ps *Server) stats(user string) Stats {
+	ps.mu.Lock()
+	tunnels := len(ps.tunnels)
+	ps.mu.Unlock()
+
+	return Stats{
+		ActiveConnections: ps.metrics.activeConnections.Load(),
+		ActiveTunnels:     tunnels,
+		Requests:          ps.metrics.requests.Load(),
+		BytesUpstream:     ps.metrics.bytesUpstream.Load(),
+		BytesDownstream:   ps.metrics.bytesDownstream.Load(),
+		UptimeSeconds:     ps.now().Sub(ps.started).Truncate(time.Second).Seconds(),
+		Users:             ps.usage.snapshot(user),
+	}
+}
+
+// RequestCount returns the number of requests handled since the server was
+// created. It is safe to call concurrently with ServeHTTP.
+func (ps *Server) RequestCount() int64 {
+	return ps.metrics.requests.Load()
+}
+
+// serveStats writes the stats snapshot to clients holding proxy credentials
+func (ps *Server) serveStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok || !ps.checkCredentials(username, password) {
+		ps.metrics.recordAuthFailure()
+		w.Header().Set("WWW-Authenticate", "Basic realm=\"Proxy Admin\"")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(ps.stats(username))
+}
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func fetchStats(t *testing.T, ps *Server) Stats {
+	t.Helper()
+	return fetchStatsAs(t, ps, "admin", "password123")
+}
+
+// fetchStatsAs reads /stats with the credentials of username
+func fetchStatsAs(t *testing.T, ps *Server, username, password string) Stats {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/stats", nil)
+	req.SetBasicAuth(username, password)
+	w := httptest.NewRecorder()
+	ps.adminHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var stats Stats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("Expected JSON stats, got %v", err)
+	}
+	return stats
+}
+
+func TestStats(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789"))
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	before := fetchStats(t, proxy)
+	if before.Requests != 0 || before.BytesDownstream != 0 {
+		t.Errorf("Expected empty stats before proxying, got %+v", before)
+	}
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", backendServer.URL, nil)
+		req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+		proxy.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	after := fetchStats(t, proxy)
+	if after.Requests != 2 {
+		t.Errorf("Expected 2 requests, got %d", after.Requests)
+	}
+	if after.BytesDownstream != 20 {
+		t.Errorf("Expected 20 bytes downstream, got %d", after.BytesDownstream)
+	}
+	if after.ActiveConnections != 0 || after.ActiveTunnels != 0 {
+		t.Errorf("Expected no active connections after requests finish, got %+v", after)
+	}
+}
+
+func TestRequestCount_Concurrent(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.SampleRate = 0
+	if got := proxy.RequestCount(); got != 0 {
+		t.Fatalf("Expected 0 requests before proxying, got %d", got)
+	}
+
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", backendServer.URL, nil)
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+			proxy.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+	wg.Wait()
+
+	if got := proxy.RequestCount(); got != n {
+		t.Errorf("Expected %d requests, got %d", n, got)
+	}
+}
+
+func TestStats_PerUserBytes(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("0123456789"))
+	}))
+	defer backendServer.Close()
+	echoAddr := startEchoServer(t)
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.AddUser("alice", "secret")
+	allowConnectPort(t, proxy, echoAddr)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", backendServer.URL, strings.NewReader("hello"))
+		req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+		proxy.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Tunnel bytes are counted while the tunnel is still open
+	conn, reader, resp := openTunnel(t, server.Listener.Addr().String(), echoAddr)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "ping")
+	if _, err := io.ReadFull(reader, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := UserBytes{Upstream: 2*5 + 4, Downstream: 2*10 + 4}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := fetchStats(t, proxy).Users["admin"]
+		if got == expected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected admin to have %+v, got %+v", expected, got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := fetchStats(t, proxy).Users["alice"]; ok {
+		t.Error("Expected no traffic for a user that sent nothing")
+	}
+}
+
+func TestStats_OnlyOwnUsage(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789"))
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.AddUser("alice", "secret")
+	for _, user := range [][2]string{{"admin", "password123"}, {"alice", "secret"}} {
+		req := httptest.NewRequest("GET", backendServer.URL, nil)
+		req.Header.Set("Proxy-Authorization", CreateBasicAuth(user[0], user[1]))
+		proxy.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	stats := fetchStatsAs(t, proxy, "alice", "secret")
+	if _, ok := stats.Users["admin"]; ok {
+		t.Error("Expected alice not to see the traffic of admin")
+	}
+	if got := stats.Users["alice"].Downstream; got != 10 {
+		t.Errorf("Expected alice to see their own 10 bytes, got %d", got)
+	}
+	if len(fetchStats(t, proxy).Users) != 1 {
+		t.Error("Expected admin to see only their own entry")
+	}
+}
+
+func TestStats_RequiresAuth(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		expected int
+	}{
+		{"No credentials", "", "", http.StatusUnauthorized},
+		{"Wrong password", "admin", "wrong", http.StatusUnauthorized},
+		{"Valid credentials", "admin", "password123", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/stats", nil)
+			if tt.username != "" {
+				req.SetBasicAuth(tt.username, tt.password)
+			}
+			w := httptest.NewRecorder()
+			proxy.adminHandler().ServeHTTP(w, req)
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// newThrottle returns a token bucket refilling at bytesPerSec with a burst of
+// one second's worth, or nil for no limit
+func newThrottle(bytesPerSec int) *rate.Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), bytesPerSec)
+}
+
+// throttledReader delays reads so they do not outpace its limiter
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+// newThrottledReader wraps r with limiter. A nil limiter returns r unchanged.
+func newThrottledReader(ctx context.Context, r io.Reader, limiter *rate.Limiter) io.Reader {
+	if limiter == nil {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, limiter: limiter}
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	// A single wait may not ask for more tokens than the bucket holds
+	if burst := tr.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := tr.r.Read(p)
+	if n > 0 {
+		if waitErr := tr.limiter.WaitN(tr.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNewThrottle_Unlimited(t *testing.T) {
+	if limiter := newThrottle(0); limiter != nil {
+		t.Errorf("Expected no limiter for 0, got %v", limiter)
+	}
+	r := bytes.NewReader(nil)
+	if result := newThrottledReader(context.Background(), r, nil); result != r {
+		t.Errorf("Expected reader to be returned unchanged without a limiter")
+	}
+}
+
+func TestRateLimitBytesPerSec_HTTP(t *testing.T) {
+	payload := bytes.Repeat([]byte("x"), 30000)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer backend.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.RateLimitBytesPerSec = 20000
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	proxyURL, _ := url.Parse(server.URL)
+	proxyURL.User = url.UserPassword("admin", "password123")
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	start := time.Now()
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("Expected full body, got %v", err)
+	}
+	if !bytes.Equal(body, payload) {
+		t.Errorf("Expected %d bytes, got %d", len(payload), len(body))
+	}
+
+	// The first second's worth arrives as a burst, the rest at the limit
+	if floor := 500 * time.Millisecond; elapsed < floor {
+		t.Errorf("Expected transfer to take at least %v, took %v", floor, elapsed)
+	}
+}
+
+func TestRateLimitBytesPerSec_Tunnel(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.RateLimitBytesPerSec = 20000
+	allowConnectPort(t, proxy, echoAddr)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	conn, reader, resp := openTunnel(t, server.Listener.Addr().String(), echoAddr)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	payload := bytes.Repeat([]byte("x"), 20000)
+	start := time.Now()
+	go conn.Write(payload)
+	echoed := make([]byte, len(payload))
+	if _, err := io.ReadFull(reader, echoed); err != nil {
+		t.Fatalf("Expected echoed payload, got %v", err)
+	}
+	elapsed := time.Since(start)
+
+	// Both directions share the limit, so 40000 bytes move with a 20000
+	// byte burst
+	if floor := time.Second; elapsed < floor {
+		t.Errorf("Expected transfer to take at least %v, took %v", floor, elapsed)
+	}
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// startTLSProxy starts a TLS proxy on a free port and returns a client
+// configured to use it with the given credentials
+func startTLSProxy(t *testing.T, proxy *Server, username, password string, trusted ...*x509.Certificate) *http.Client {
+	t.Helper()
+	certFile, keyFile, cert := writeTestCertificate(t)
+
+	go proxy.StartTLS(certFile, keyFile)
+	waitForListener(t, "127.0.0.1:"+proxy.port)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	for _, c := range trusted {
+		pool.AddCert(c)
+	}
+
+	proxyURL := &url.URL{
+		Scheme: "https",
+		User:   url.UserPassword(username, password),
+		Host:   "127.0.0.1:" + proxy.port,
+	}
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+}
+
+func TestStartTLS(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Backend over TLS proxy"))
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", freePort(t))
+	client := startTLSProxy(t, proxy, "admin", "password123")
+
+	resp, err := client.Get(backendServer.URL)
+	if err != nil {
+		t.Fatalf("Request through TLS proxy failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "Backend over TLS proxy" {
+		t.Errorf("Unexpected body %q", body)
+	}
+}
+
+func TestStartTLS_Connect(t *testing.T) {
+	backendServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Tunneled over TLS proxy"))
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", freePort(t))
+	allowConnectPort(t, proxy, backendServer.Listener.Addr().String())
+	client := startTLSProxy(t, proxy, "admin", "password123", backendServer.Certificate())
+
+	// An https target forces the client to tunnel with CONNECT
+	resp, err := client.Get(backendServer.URL)
+	if err != nil {
+		t.Fatalf("CONNECT through TLS proxy failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "Tunneled over TLS proxy" {
+		t.Errorf("Unexpected body %q", body)
+	}
+}
+
+func TestStartTLS_InvalidCredentials(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", freePort(t))
+	client := startTLSProxy(t, proxy, "admin", "wrong")
+
+	resp, err := client.Get(backendServer.URL)
+	if err != nil {
+		t.Fatalf("Request through TLS proxy failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Errorf("Expected status %d, got %d", http.StatusProxyAuthRequired, resp.StatusCode)
+	}
+}
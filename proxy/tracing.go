@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName identifies the proxy's spans
+const tracerName = "go-proxy-server"
+
+// traceContext reads and writes W3C traceparent and tracestate headers
+var traceContext = propagation.TraceContext{}
+
+// defaultTracer records nothing
+func defaultTracer() trace.Tracer {
+	return noop.NewTracerProvider().Tracer(tracerName)
+}
+
+// startSpan starts the span of a proxied request to host, continuing any
+// trace the client sent
+func (ps *Server) startSpan(r *http.Request, host string) (context.Context, trace.Span) {
+	ctx := traceContext.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return ps.Tracer.Start(ctx, r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("server.address", host),
+		))
+}
+
+// endSpan records the outcome of a proxied request on its span
+func endSpan(span trace.Span, status int, elapsed time.Duration) {
+	span.SetAttributes(
+		attribute.Int("http.response.status_code", status),
+		attribute.Int64("proxy.duration_ms", elapsed.Milliseconds()),
+	)
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// injectTraceContext passes the trace in ctx on to an upstream in h
+func injectTraceContext(ctx context.Context, h http.Header) {
+	traceContext.Inject(ctx, propagation.HeaderCarrier(h))
+}
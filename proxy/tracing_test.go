@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newTracedProxy returns a proxy whose spans are recorded by the returned
+// exporter
+func newTracedProxy(t *testing.T) (*Server, *tracetest.InMemoryExporter) {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.Tracer = provider.Tracer(tracerName)
+	return proxy, exporter
+}
+
+// spanAttribute returns the value of key on span
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracing_HTTPRequestSpan(t *testing.T) {
+	var traceparent string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+	}))
+	defer backend.Close()
+
+	proxy, exporter := newTracedProxy(t)
+	req := httptest.NewRequest("GET", backend.URL, nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "GET" {
+		t.Errorf("Expected span name GET, got %s", span.Name)
+	}
+	if got := spanAttribute(span, "http.request.method").AsString(); got != "GET" {
+		t.Errorf("Expected method GET, got %q", got)
+	}
+	if got := spanAttribute(span, "server.address").AsString(); got != req.URL.Host {
+		t.Errorf("Expected host %s, got %q", req.URL.Host, got)
+	}
+	if got := spanAttribute(span, "http.response.status_code").AsInt64(); got != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, got)
+	}
+	if spanAttribute(span, "proxy.duration_ms").Type() != attribute.INT64 {
+		t.Error("Expected a duration attribute")
+	}
+
+	// The upstream continues the proxy's trace
+	expected := "00-" + span.SpanContext.TraceID().String() + "-" + span.SpanContext.SpanID().String() + "-01"
+	if traceparent != expected {
+		t.Errorf("Expected traceparent %s, got %q", expected, traceparent)
+	}
+}
+
+func TestTracing_ContinuesClientTrace(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	proxy, exporter := newTracedProxy(t)
+	req := httptest.NewRequest("GET", backend.URL, nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	if got := spans[0].SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the client's trace ID, got %s", got)
+	}
+	if got := spans[0].Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("Expected the client's span as parent, got %s", got)
+	}
+}
+
+func TestTracing_TunnelSpan(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxy, exporter := newTracedProxy(t)
+	allowConnectPort(t, proxy, echoAddr)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	conn, _, resp := openTunnel(t, server.Listener.Addr().String(), echoAddr)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Errorf("Expected the tunnel span to stay open with the tunnel, got %d spans", len(spans))
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(exporter.GetSpans()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span once the tunnel closed, got %d", len(spans))
+	}
+	if spans[0].Name != "CONNECT" {
+		t.Errorf("Expected span name CONNECT, got %s", spans[0].Name)
+	}
+	if got := spanAttribute(spans[0], "server.address").AsString(); got != echoAddr {
+		t.Errorf("Expected host %s, got %q", echoAddr, got)
+	}
+	if elapsed := spans[0].EndTime.Sub(spans[0].StartTime); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the span to cover the tunnel lifetime, got %v", elapsed)
+	}
+}
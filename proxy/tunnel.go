@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pipe copies data between an established tunnel's client and destination
+// until either side closes, or until both have with TunnelHalfClose.
+// clientReader holds any bytes the client sent ahead of the tunnel being
+// established. Traffic is attributed to user as it flows, within the limits
+// of the policy tag in ctx. Both connections are closed before pipe returns.
+func (ps *Server) pipe(ctx context.Context, clientConn net.Conn, clientReader io.Reader, destConn net.Conn, target, user string) {
+	start := ps.now()
+	var destReader io.Reader = destConn
+	if ps.TunnelIdleTimeout > 0 {
+		idle := &idleTimer{timeout: ps.TunnelIdleTimeout, conns: [2]net.Conn{clientConn, destConn}}
+		idle.touch()
+		clientReader = &idleReader{r: clientReader, idle: idle}
+		destReader = &idleReader{r: destConn, idle: idle}
+	}
+
+	var expired atomic.Bool
+	if ps.MaxTunnelDuration > 0 {
+		timer := time.AfterFunc(ps.MaxTunnelDuration, func() {
+			expired.Store(true)
+			clientConn.Close()
+			destConn.Close()
+		})
+		defer timer.Stop()
+	}
+
+	maxBytes := ps.connectionBytesFor(ctx)
+	quota := newByteQuota(maxBytes)
+	throttle := newThrottle(ps.bytesPerSecFor(ctx))
+	traffic := ps.usage.forUser(user)
+	upstream := &countingReader{r: newThrottledReader(context.Background(), clientReader, throttle), quota: quota, total: traffic.upstreamCounter()}
+	downstream := &countingReader{r: newThrottledReader(context.Background(), destReader, throttle), quota: quota, total: traffic.downstreamCounter()}
+
+	var wg sync.WaitGroup
+	var sent int64
+	var sendErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sent, sendErr = io.Copy(destConn, upstream)
+		ps.finishCopy(destConn, clientConn)
+	}()
+
+	received, err := io.Copy(clientConn, downstream)
+	ps.finishCopy(clientConn, destConn)
+	wg.Wait()
+	// Either direction may have hit the quota or idle timeout first
+	err = errors.Join(err, sendErr)
+	clientConn.Close()
+	destConn.Close()
+	log.Printf("Tunnel from %s to %s closed: %d bytes sent, %d bytes received in %v",
+		clientConn.RemoteAddr(), target, sent, received, ps.now().Sub(start))
+
+	ps.metrics.recordBytes(upstream.n.Load(), downstream.n.Load())
+	if errors.Is(err, errByteQuotaExceeded) {
+		log.Printf("Closing tunnel from %s to %s: %v (%d bytes)", clientConn.RemoteAddr(), target, errByteQuotaExceeded, maxBytes)
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		log.Printf("Closing tunnel from %s to %s: idle for %v", clientConn.RemoteAddr(), target, ps.TunnelIdleTimeout)
+	}
+	if expired.Load() {
+		log.Printf("Closing tunnel from %s to %s: open for longer than %v", clientConn.RemoteAddr(), target, ps.MaxTunnelDuration)
+	}
+}
+
+// idleTimer pushes back the read deadlines of both ends of a tunnel whenever
+// either of them receives data, so reads only time out when the whole
+// tunnel has been quiet for timeout
+type idleTimer struct {
+	timeout time.Duration
+	conns   [2]net.Conn
+}
+
+func (it *idleTimer) touch() {
+	deadline := time.Now().Add(it.timeout)
+	for _, conn := range it.conns {
+		conn.SetReadDeadline(deadline)
+	}
+}
+
+// idleReader reports reads from one end of a tunnel as activity
+type idleReader struct {
+	r    io.Reader
+	idle *idleTimer
+}
+
+func (ir *idleReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	if n > 0 {
+		ir.idle.touch()
+	}
+	return n, err
+}
+
+// finishCopy ends one direction of a tunnel once nothing more will be
+// written to dst. Without TunnelHalfClose, or when dst cannot half-close,
+// both connections are closed so the other direction stops too.
+func (ps *Server) finishCopy(dst, src net.Conn) {
+	if ps.TunnelHalfClose {
+		if hc, ok := dst.(interface{ CloseWrite() error }); ok && hc.CloseWrite() == nil {
+			return
+		}
+	}
+	dst.Close()
+	src.Close()
+}
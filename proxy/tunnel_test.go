@@ -0,0 +1,279 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// startReplyAfterEOFServer starts a TCP server that reads until the client
+// half-closes and then answers with reply
+func startReplyAfterEOFServer(t *testing.T, reply string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(io.Discard, conn)
+				io.WriteString(conn, reply)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestTunnelHalfClose(t *testing.T) {
+	tests := []struct {
+		name      string
+		halfClose bool
+		expected  string
+	}{
+		{"full close", false, ""},
+		{"half close", true, "done"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendAddr := startReplyAfterEOFServer(t, "done")
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.TunnelHalfClose = tt.halfClose
+			allowConnectPort(t, proxy, backendAddr)
+			server := httptest.NewServer(proxy)
+			defer server.Close()
+
+			conn, reader, resp := openTunnel(t, server.Listener.Addr().String(), backendAddr)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+			}
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			io.WriteString(conn, "request")
+			conn.(*net.TCPConn).CloseWrite()
+			reply, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("Expected tunnel to close cleanly, got %v", err)
+			}
+			if string(reply) != tt.expected {
+				t.Errorf("Expected reply %q, got %q", tt.expected, reply)
+			}
+		})
+	}
+
+	t.Run("target half-closes first", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		received := make(chan string, 1)
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			io.WriteString(conn, "hello")
+			conn.(*net.TCPConn).CloseWrite()
+			data, _ := io.ReadAll(conn)
+			received <- string(data)
+		}()
+
+		proxy := NewProxyServer("admin", "password123", "8080")
+		proxy.TunnelHalfClose = true
+		allowConnectPort(t, proxy, ln.Addr().String())
+		server := httptest.NewServer(proxy)
+		defer server.Close()
+
+		conn, reader, resp := openTunnel(t, server.Listener.Addr().String(), ln.Addr().String())
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		greeting, err := io.ReadAll(reader)
+		if err != nil || string(greeting) != "hello" {
+			t.Fatalf("Expected %q before EOF, got %q (%v)", "hello", greeting, err)
+		}
+		io.WriteString(conn, "late request")
+		conn.(*net.TCPConn).CloseWrite()
+
+		select {
+		case data := <-received:
+			if data != "late request" {
+				t.Errorf("Expected target to receive %q, got %q", "late request", data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Target never saw the client finish")
+		}
+	})
+}
+
+func TestTunnelClose_ReleasesResources(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxy := NewProxyServer("admin", "password123", "8080")
+	allowConnectPort(t, proxy, echoAddr)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	baseline := runtime.NumGoroutine()
+	conn, reader, resp := openTunnel(t, server.Listener.Addr().String(), echoAddr)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "ping")
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		t.Fatalf("Expected echo through tunnel, got %v", err)
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		proxy.mu.Lock()
+		open := len(proxy.tunnels)
+		proxy.mu.Unlock()
+		active := proxy.metrics.activeConnections.Load()
+		goroutines := runtime.NumGoroutine()
+		if open == 0 && active == 0 && goroutines <= baseline {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected tunnel resources to be released, got %d tunnels, %d active connections, %d goroutines (baseline %d)",
+				open, active, goroutines, baseline)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTunnelIdleTimeout(t *testing.T) {
+	logs := captureLog(t)
+	echoAddr := startEchoServer(t)
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.TunnelIdleTimeout = 200 * time.Millisecond
+	allowConnectPort(t, proxy, echoAddr)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	conn, reader, resp := openTunnel(t, server.Listener.Addr().String(), echoAddr)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Traffic within the timeout keeps the tunnel open
+	for i := 0; i < 3; i++ {
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(conn, "ping")
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			t.Fatalf("Expected active tunnel to stay open, got %v", err)
+		}
+	}
+
+	// Sending nothing closes it once the timeout passes
+	start := time.Now()
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Fatalf("Expected idle tunnel to be closed by the proxy, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Expected tunnel to close after about 200ms idle, took %v", elapsed)
+	}
+	waitForLog(t, logs, "idle for 200ms")
+}
+
+func TestMaxTunnelDuration(t *testing.T) {
+	logs := captureLog(t)
+	echoAddr := startEchoServer(t)
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.MaxTunnelDuration = 300 * time.Millisecond
+	allowConnectPort(t, proxy, echoAddr)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	conn, reader, resp := openTunnel(t, server.Listener.Addr().String(), echoAddr)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Keep data flowing until the proxy closes the tunnel
+	start := time.Now()
+	buf := make([]byte, 4)
+	for {
+		if _, err := io.WriteString(conn, "ping"); err != nil {
+			break
+		}
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			break
+		}
+		if time.Since(start) > 3*time.Second {
+			t.Fatal("Expected active tunnel to be closed after the max duration")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("Expected tunnel to stay open for about 300ms, closed after %v", elapsed)
+	}
+	waitForLog(t, logs, "open for longer than 300ms")
+}
+
+func TestPipe_LogsTunnelTotals(t *testing.T) {
+	logs := captureLog(t)
+
+	// The target reads a fixed request and answers with a shorter reply
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.ReadFull(conn, make([]byte, 1000))
+		conn.Write(bytes.Repeat([]byte("r"), 300))
+	}()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	allowConnectPort(t, proxy, ln.Addr().String())
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	conn, reader, resp := openTunnel(t, server.Listener.Addr().String(), ln.Addr().String())
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write(bytes.Repeat([]byte("s"), 1000)); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply) != 300 {
+		t.Errorf("Expected 300 reply bytes, got %d", len(reply))
+	}
+	waitForLog(t, logs, "1000 bytes sent, 300 bytes received in ")
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// isUpgradeRequest reports whether r asks to switch protocols, as a
+// WebSocket handshake does. Only HTTP/1.1 can upgrade a connection.
+func isUpgradeRequest(r *http.Request) bool {
+	return r.ProtoMajor == 1 && r.ProtoMinor == 1 &&
+		r.Header.Get("Upgrade") != "" &&
+		httpguts.HeaderValuesContainsToken(r.Header["Connection"], "upgrade")
+}
+
+// upstreamAddr returns the host:port to dial for req, defaulting the port
+// from its scheme
+func upstreamAddr(req *http.Request) string {
+	port := req.URL.Port()
+	if port == "" {
+		port = schemePort(req.URL.Scheme)
+	}
+	return net.JoinHostPort(req.URL.Hostname(), port)
+}
+
+// schemePort returns the default port of an http or https URL
+func schemePort(scheme string) string {
+	if scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// handleUpgrade forwards a protocol upgrade request on its own upstream
+// connection. If the upstream switches protocols, its 101 response is relayed
+// and the client connection is piped to it like a CONNECT tunnel. Any other
+// response is relayed as a normal response.
+func (ps *Server) handleUpgrade(w http.ResponseWriter, r *http.Request, proxyReq *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		ps.writeError(w, r, "Protocol upgrades are not supported on this connection", http.StatusNotImplemented)
+		return
+	}
+
+	target := upstreamAddr(proxyReq)
+	destConn, err := ps.dialConnect(target)
+	if err != nil {
+		ps.writeProxyError(w, r, err)
+		return
+	}
+	defer destConn.Close()
+
+	// Bound the handshake; the upgraded connection is governed by the
+	// tunnel timeouts instead
+	destConn.SetDeadline(time.Now().Add(ps.requestTimeoutFor(proxyReq.URL.Host)))
+	if proxyReq.URL.Scheme == "https" {
+		tlsConfig := ps.upstreamTransport().TLSClientConfig.Clone()
+		tlsConfig.ServerName = proxyReq.URL.Hostname()
+		tlsConn := tls.Client(destConn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			ps.writeProxyError(w, r, err)
+			return
+		}
+		destConn = tlsConn
+	}
+
+	if err := proxyReq.Write(destConn); err != nil {
+		ps.writeProxyError(w, r, err)
+		return
+	}
+	destReader := bufio.NewReader(destConn)
+	resp, err := http.ReadResponse(destReader, proxyReq)
+	if err != nil {
+		ps.writeProxyError(w, r, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	for name, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	overrideHeaders(w.Header(), ps.ResponseHeaders)
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+	destConn.SetDeadline(time.Time{})
+
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		ps.writeError(w, r, "Error hijacking connection", http.StatusInternalServerError)
+		return
+	}
+	defer clientConn.Close()
+
+	fmt.Fprintf(clientBuf, "HTTP/1.1 %s\r\n", resp.Status)
+	w.Header().Write(clientBuf)
+	clientBuf.WriteString("\r\n")
+	if err := clientBuf.Flush(); err != nil {
+		log.Printf("Error relaying upgrade response to %s: %v", r.RemoteAddr, err)
+		return
+	}
+
+	// Register the upgraded connection so Shutdown can close it
+	if !ps.trackTunnel(clientConn) {
+		return
+	}
+	defer ps.untrackTunnel(clientConn)
+
+	if destReader.Buffered() > 0 {
+		destConn = &bufferedConn{Conn: destConn, reader: destReader}
+	}
+	ps.pipe(r.Context(), clientConn, clientBuf.Reader, destConn, target, requestInfoFromContext(r.Context()).user)
+}
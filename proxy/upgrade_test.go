@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// websocketAccept computes the Sec-WebSocket-Accept value for key
+func websocketAccept(key string) string {
+	h := sha1.New()
+	io.WriteString(h, key+"258EAFA5-E914-47DA-95CA-C5AB0DC85B11")
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// startWebSocketEchoServer starts a minimal WebSocket server that answers
+// the handshake and echoes short masked text frames back unmasked
+func startWebSocketEchoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				req, err := http.ReadRequest(reader)
+				if err != nil || req.Header.Get("Upgrade") != "websocket" {
+					io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n")
+					return
+				}
+				fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+					websocketAccept(req.Header.Get("Sec-WebSocket-Key")))
+
+				for {
+					header := make([]byte, 6)
+					if _, err := io.ReadFull(reader, header); err != nil {
+						return
+					}
+					payload := make([]byte, header[1]&0x7f)
+					if _, err := io.ReadFull(reader, payload); err != nil {
+						return
+					}
+					for i := range payload {
+						payload[i] ^= header[2+i%4]
+					}
+					conn.Write(append([]byte{0x81, byte(len(payload))}, payload...))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestWebSocketUpgrade(t *testing.T) {
+	backendAddr := startWebSocketEchoServer(t)
+	proxy := NewProxyServer("admin", "password123", "8080")
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	fmt.Fprintf(conn, "GET http://%s/chat HTTP/1.1\r\nHost: %s\r\nProxy-Authorization: %s\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n",
+		backendAddr, backendAddr, CreateBasicAuth("admin", "password123"), key)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected status %d, got %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != websocketAccept(key) {
+		t.Errorf("Expected Sec-WebSocket-Accept %q, got %q", websocketAccept(key), accept)
+	}
+
+	for _, message := range []string{"hello", "over the proxy"} {
+		mask := []byte{1, 2, 3, 4}
+		frame := append([]byte{0x81, 0x80 | byte(len(message))}, mask...)
+		for i := range message {
+			frame = append(frame, message[i]^mask[i%4])
+		}
+		conn.Write(frame)
+
+		echoed := make([]byte, 2+len(message))
+		if _, err := io.ReadFull(reader, echoed); err != nil {
+			t.Fatalf("Expected echoed frame, got %v", err)
+		}
+		if echoed[0] != 0x81 || string(echoed[2:]) != message {
+			t.Errorf("Expected text frame %q, got %q", message, echoed)
+		}
+	}
+}
+
+func TestWebSocketUpgrade_Rejected(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upgrades not allowed", http.StatusForbidden)
+	}))
+	defer backendServer.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	host := backendServer.Listener.Addr().String()
+	fmt.Fprintf(conn, "GET http://%s/ HTTP/1.1\r\nHost: %s\r\nProxy-Authorization: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n",
+		host, host, CreateBasicAuth("admin", "password123"))
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, resp.StatusCode)
+	}
+}
+
+func TestIsUpgradeRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		upgrade    string
+		connection string
+		expected   bool
+	}{
+		{"WebSocket", "websocket", "Upgrade", true},
+		{"Token list", "websocket", "keep-alive, Upgrade", true},
+		{"No Connection token", "websocket", "keep-alive", false},
+		{"No Upgrade header", "", "Upgrade", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			if tt.upgrade != "" {
+				req.Header.Set("Upgrade", tt.upgrade)
+			}
+			req.Header.Set("Connection", tt.connection)
+			if result := isUpgradeRequest(req); result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
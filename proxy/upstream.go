@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// defaultTLSSessionCacheSize is the number of upstream TLS sessions cached
+const defaultTLSSessionCacheSize = 64
+
+// defaultMaxIdleConnsPerHost is the number of idle connections kept for reuse
+// per upstream host, well above net/http's default of 2 so concurrent
+// requests to one host do not keep dialing
+const defaultMaxIdleConnsPerHost = 32
+
+// defaultExpectContinueTimeout is how long a request expecting 100 Continue
+// waits for the upstream's before its body is sent anyway
+const defaultExpectContinueTimeout = time.Second
+
+// LoadCertPool reads a bundle of PEM encoded CA certificates into a pool
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// upstreamTransport returns the transport shared by all forwarded requests.
+// It is built on first use so configuration fields set after construction
+// are honored.
+func (ps *Server) upstreamTransport() *http.Transport {
+	ps.transportOnce.Do(func() {
+		ps.transport = ps.newTransport()
+	})
+	return ps.transport
+}
+
+// newTransport creates the upstream transport from the current configuration
+func (ps *Server) newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	tlsConfig := &tls.Config{
+		RootCAs:            ps.RootCAs,
+		InsecureSkipVerify: ps.InsecureSkipVerify,
+	}
+	if ps.TLSSessionCacheSize > 0 {
+		// Let repeated connections to the same upstream resume their session
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(ps.TLSSessionCacheSize)
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	transport.ExpectContinueTimeout = ps.ExpectContinueTimeout
+	transport.MaxIdleConnsPerHost = ps.MaxIdleConnsPerHost
+	if transport.MaxIdleConns < ps.MaxIdleConnsPerHost {
+		transport.MaxIdleConns = ps.MaxIdleConnsPerHost
+	}
+
+	if ps.Resolver != nil {
+		// Same dialer settings as http.DefaultTransport
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Resolver:  ps.Resolver,
+		}
+		transport.DialContext = dialer.DialContext
+	}
+
+	if ps.ParentProxy != nil {
+		transport.Proxy = ps.parentProxyFor
+		transport.ProxyConnectHeader = make(http.Header)
+		ps.setHopHeaders(transport.ProxyConnectHeader)
+		transport.OnProxyConnectResponse = ps.onParentConnectResponse
+	}
+
+	return transport
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTLSSessionResumption(t *testing.T) {
+	// Create a TLS backend that reports whether the session was resumed
+	backendServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Did-Resume", fmt.Sprint(r.TLS.DidResume))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backendServer.Close()
+
+	tests := []struct {
+		name           string
+		cacheSize      int
+		expectedResume string
+	}{
+		{"Session cache enabled", 8, "true"},
+		{"Session cache disabled", 0, "false"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.TLSSessionCacheSize = tt.cacheSize
+
+			pool := x509.NewCertPool()
+			pool.AddCert(backendServer.Certificate())
+			proxy.RootCAs = pool
+
+			var resumed []string
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest("GET", backendServer.URL, nil)
+				req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+				w := httptest.NewRecorder()
+
+				proxy.handleHTTP(w, req)
+
+				if w.Code != http.StatusOK {
+					t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+				}
+				resumed = append(resumed, w.Header().Get("Did-Resume"))
+
+				// Force the next request onto a fresh connection
+				proxy.upstreamTransport().CloseIdleConnections()
+			}
+
+			if resumed[0] != "false" {
+				t.Errorf("First connection should not resume, got %s", resumed[0])
+			}
+			if resumed[1] != tt.expectedResume {
+				t.Errorf("Expected second connection resume=%s, got %s", tt.expectedResume, resumed[1])
+			}
+		})
+	}
+}
+
+func TestUpstreamRootCAs(t *testing.T) {
+	backendServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backendServer.Close()
+
+	trusted := x509.NewCertPool()
+	trusted.AddCert(backendServer.Certificate())
+
+	tests := []struct {
+		name               string
+		rootCAs            *x509.CertPool
+		insecureSkipVerify bool
+		expected           int
+	}{
+		{"Custom CA pool", trusted, false, http.StatusOK},
+		{"System trust store", nil, false, http.StatusBadGateway},
+		{"Pool without the CA", x509.NewCertPool(), false, http.StatusBadGateway},
+		{"Verification disabled", nil, true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.RootCAs = tt.rootCAs
+			proxy.InsecureSkipVerify = tt.insecureSkipVerify
+
+			req := httptest.NewRequest("GET", backendServer.URL, nil)
+			req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+			w := httptest.NewRecorder()
+			proxy.handleHTTP(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestLoadCertPool(t *testing.T) {
+	certFile, _, cert := writeTestCertificate(t)
+	pool, err := LoadCertPool(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: pool}); err != nil {
+		t.Errorf("Expected loaded pool to trust the certificate, got %v", err)
+	}
+
+	if _, err := LoadCertPool(writeConfigFile(t, "empty.pem", "not a certificate")); err == nil {
+		t.Error("Expected an error for a file without certificates")
+	}
+}
+
+func TestUpstreamTransport_MaxIdleConnsPerHost(t *testing.T) {
+	proxy := NewProxyServer("admin", "password123", "8080")
+	if got := proxy.upstreamTransport().MaxIdleConnsPerHost; got != defaultMaxIdleConnsPerHost {
+		t.Errorf("Expected %d idle connections per host, got %d", defaultMaxIdleConnsPerHost, got)
+	}
+
+	proxy = NewProxyServer("admin", "password123", "8080")
+	proxy.MaxIdleConnsPerHost = 500
+	transport := proxy.upstreamTransport()
+	if transport.MaxIdleConnsPerHost != 500 || transport.MaxIdleConns < 500 {
+		t.Errorf("Expected room for 500 idle connections per host, got %d of %d", transport.MaxIdleConnsPerHost, transport.MaxIdleConns)
+	}
+}
+
+// BenchmarkHandleHTTP_SameHost sends bursts of concurrent requests to one
+// upstream and reports how many connections the proxy dialed per request.
+// Connections beyond MaxIdleConnsPerHost are closed between bursts and have
+// to be dialed again.
+func BenchmarkHandleHTTP_SameHost(b *testing.B) {
+	for _, perHost := range []int{2, defaultMaxIdleConnsPerHost} {
+		b.Run(fmt.Sprintf("MaxIdleConnsPerHost=%d", perHost), func(b *testing.B) {
+			var dials atomic.Int64
+			// A little latency keeps several requests in flight at once
+			backendServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(time.Millisecond)
+				fmt.Fprint(w, "OK")
+			}))
+			backendServer.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					dials.Add(1)
+				}
+			}
+			backendServer.Start()
+			defer backendServer.Close()
+
+			proxy := NewProxyServer("admin", "password123", "8080")
+			proxy.MaxIdleConnsPerHost = perHost
+			proxy.SampleRate = 0
+			auth := CreateBasicAuth("admin", "password123")
+
+			const burst = 16
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				for j := 0; j < burst; j++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						req := httptest.NewRequest("GET", backendServer.URL, nil)
+						req.Header.Set("Proxy-Authorization", auth)
+						proxy.ServeHTTP(httptest.NewRecorder(), req)
+					}()
+				}
+				wg.Wait()
+			}
+			b.ReportMetric(float64(dials.Load())/float64(b.N*burst), "dials/request")
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// userTraffic counts the bytes one user sent upstream and received back
+type userTraffic struct {
+	upstream   atomic.Int64
+	downstream atomic.Int64
+}
+
+// upstreamCounter returns the upstream counter, or nil for a nil traffic so
+// unattributed requests are simply not counted
+func (ut *userTraffic) upstreamCounter() *atomic.Int64 {
+	if ut == nil {
+		return nil
+	}
+	return &ut.upstream
+}
+
+// downstreamCounter is the downstream equivalent of upstreamCounter
+func (ut *userTraffic) downstreamCounter() *atomic.Int64 {
+	if ut == nil {
+		return nil
+	}
+	return &ut.downstream
+}
+
+// UserBytes is the traffic of one user served as JSON on /stats
+type UserBytes struct {
+	Upstream   int64 `json:"bytes_upstream"`
+	Downstream int64 `json:"bytes_downstream"`
+}
+
+// userUsage holds the traffic of every authenticated user. Entries are only
+// created for authenticated users, so clients cannot grow the map at will.
+type userUsage struct {
+	mu    sync.Mutex
+	users map[string]*userTraffic
+}
+
+// forUser returns the counters of user, or nil when the request was not
+// attributed to a user
+func (uu *userUsage) forUser(user string) *userTraffic {
+	if user == "" {
+		return nil
+	}
+	uu.mu.Lock()
+	defer uu.mu.Unlock()
+	if uu.users == nil {
+		uu.users = make(map[string]*userTraffic)
+	}
+	traffic, ok := uu.users[user]
+	if !ok {
+		traffic = &userTraffic{}
+		uu.users[user] = traffic
+	}
+	return traffic
+}
+
+// snapshot returns the current totals of user, keyed by username. It is
+// empty when user has not sent any traffic.
+func (uu *userUsage) snapshot(user string) map[string]UserBytes {
+	uu.mu.Lock()
+	defer uu.mu.Unlock()
+	totals := make(map[string]UserBytes, 1)
+	if traffic, ok := uu.users[user]; ok {
+		totals[user] = UserBytes{Upstream: traffic.upstream.Load(), Downstream: traffic.downstream.Load()}
+	}
+	return totals
+}
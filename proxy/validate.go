@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Validate checks that the server's configuration can be used to start it
+// without binding any ports. All problems found are reported together.
+func (ps *Server) Validate() error {
+	var errs []error
+	if err := ps.validateListenPort(ps.port); err != nil {
+		errs = append(errs, err)
+	}
+	if ps.AdminPort != "" {
+		if err := ps.validateListenPort(ps.AdminPort); err != nil {
+			errs = append(errs, fmt.Errorf("admin %w", err))
+		}
+	}
+	errs = append(errs, ps.validateUsers()...)
+	if err := ps.validateHashes(); err != nil {
+		errs = append(errs, err)
+	}
+
+	for i, cidr := range ps.AllowedCIDRs {
+		if cidr == nil || cidr.IP == nil || cidr.Mask == nil {
+			errs = append(errs, fmt.Errorf("allowed CIDR %d is empty", i))
+		}
+	}
+	for i, cidr := range ps.DeniedCIDRs {
+		if cidr == nil || cidr.IP == nil || cidr.Mask == nil {
+			errs = append(errs, fmt.Errorf("denied CIDR %d is empty", i))
+		}
+	}
+
+	if ps.InterceptHTTPS && (ps.InterceptCA == nil || ps.InterceptCA.Leaf == nil) {
+		errs = append(errs, errors.New("intercepting HTTPS requires an intercept CA"))
+	}
+
+	if ps.AuthBan != nil && (ps.AuthBan.MaxFailures < 1 || ps.AuthBan.Window <= 0 || ps.AuthBan.Duration <= 0) {
+		errs = append(errs, errors.New("auth ban needs a positive failure count, window and duration"))
+	}
+	if ps.CircuitBreaker != nil && (ps.CircuitBreaker.Threshold < 1 || ps.CircuitBreaker.Cooldown <= 0) {
+		errs = append(errs, errors.New("circuit breaker needs a positive threshold and cooldown"))
+	}
+
+	errs = append(errs, ps.validatePools()...)
+
+	if ps.ClientCRLFile != "" {
+		if _, err := loadRevocationList(ps.ClientCRLFile); err != nil {
+			errs = append(errs, fmt.Errorf("client CRL %s: %w", ps.ClientCRLFile, err))
+		}
+	}
+	if ps.ManifestPath != "" {
+		if info, err := os.Stat(filepath.Dir(ps.ManifestPath)); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("manifest directory %s does not exist", filepath.Dir(ps.ManifestPath)))
+		}
+	}
+	if ps.ParentProxy != nil && ps.ParentProxy.Scheme != "http" && ps.ParentProxy.Scheme != "https" {
+		errs = append(errs, fmt.Errorf("parent proxy %s: scheme must be http or https", ps.ParentProxy.Redacted()))
+	}
+
+	for name, path := range map[string]string{"health": ps.HealthPath, "PAC": ps.PACPath} {
+		if path != "" && !strings.HasPrefix(path, "/") {
+			errs = append(errs, fmt.Errorf("%s path %q must start with /", name, path))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateUsers checks that every user can authenticate with Basic auth
+func (ps *Server) validateUsers() []error {
+	ps.usersMu.RLock()
+	defer ps.usersMu.RUnlock()
+
+	usernames := make([]string, 0, len(ps.users))
+	for username := range ps.users {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+
+	var errs []error
+	for _, username := range usernames {
+		switch {
+		case username == "":
+			errs = append(errs, errors.New("username must not be empty"))
+		case strings.Contains(username, ":"):
+			errs = append(errs, fmt.Errorf("username %q must not contain a colon", username))
+		}
+		if ps.users[username].password == "" {
+			errs = append(errs, fmt.Errorf("password of user %q must not be empty", username))
+		}
+	}
+	return errs
+}
+
+// validatePools checks that every pool in UpstreamPools can serve requests
+func (ps *Server) validatePools() []error {
+	names := make([]string, 0, len(ps.UpstreamPools))
+	for name := range ps.UpstreamPools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		total := 0
+		for i, backend := range ps.UpstreamPools[name] {
+			if backend.Addr == "" {
+				errs = append(errs, fmt.Errorf("upstream pool %q: backend %d has no address", name, i+1))
+			}
+			if backend.Weight < 0 {
+				errs = append(errs, fmt.Errorf("upstream pool %q: backend %d has a negative weight", name, i+1))
+			}
+			total += max(backend.Weight, 0)
+		}
+		if total == 0 {
+			errs = append(errs, fmt.Errorf("upstream pool %q has no backend with a positive weight", name))
+		}
+	}
+	return errs
+}
+
+// validatePort checks that port is a TCP port number between 1 and 65535
+func validatePort(port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q: must be a number between 1 and 65535", port)
+	}
+	return nil
+}
+
+// validateListenPort checks a port the server binds, also accepting port 0
+// when RandomPort is set
+func (ps *Server) validateListenPort(port string) error {
+	if port == "0" && ps.RandomPort {
+		return nil
+	}
+	return validatePort(port)
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateAcceptsValidConfig(t *testing.T) {
+	dir := t.TempDir()
+	proxy := NewProxyServer("admin", "password123", "8080")
+	_, cidr, _ := net.ParseCIDR("10.0.0.0/8")
+	proxy.AllowedCIDRs = []*net.IPNet{cidr}
+	proxy.ManifestPath = filepath.Join(dir, "manifest.json")
+	proxy.ParentProxy = &url.URL{Scheme: "http", Host: "parent:3128"}
+	proxy.PACPath = "/proxy.pac"
+
+	if err := proxy.Validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+}
+
+func TestValidateRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(ps *Server)
+		want      string
+	}{
+		{"empty password", func(ps *Server) {
+			ps.users["admin"].password = ""
+		}, `password of user "admin"`},
+		{"colon in username", func(ps *Server) {
+			ps.users["ad:min"] = &credential{password: "secret"}
+		}, "must not contain a colon"},
+		{"admin port", func(ps *Server) {
+			ps.AdminPort = "99999"
+		}, "admin invalid port"},
+		{"empty CIDR", func(ps *Server) {
+			ps.DeniedCIDRs = []*net.IPNet{{}}
+		}, "denied CIDR 0 is empty"},
+		{"missing CRL", func(ps *Server) {
+			ps.ClientCRLFile = filepath.Join(t.TempDir(), "missing.crl")
+		}, "client CRL"},
+		{"missing manifest directory", func(ps *Server) {
+			ps.ManifestPath = filepath.Join(t.TempDir(), "missing", "manifest.json")
+		}, "manifest directory"},
+		{"parent proxy scheme", func(ps *Server) {
+			ps.ParentProxy = &url.URL{Scheme: "socks5", Host: "parent:1080"}
+		}, "scheme must be http or https"},
+		{"auth ban without window", func(ps *Server) {
+			ps.AuthBan = &AuthBan{MaxFailures: 5, Duration: time.Minute}
+		}, "auth ban"},
+		{"circuit breaker without threshold", func(ps *Server) {
+			ps.CircuitBreaker = &CircuitBreaker{Cooldown: time.Second}
+		}, "circuit breaker"},
+		{"upstream pool without weights", func(ps *Server) {
+			ps.UpstreamPools = map[string][]Upstream{"api.internal": {{Addr: "10.0.0.1"}}}
+		}, "no backend with a positive weight"},
+		{"upstream pool negative weight", func(ps *Server) {
+			ps.UpstreamPools = map[string][]Upstream{"api.internal": {{Addr: "10.0.0.1", Weight: -1}}}
+		}, "negative weight"},
+		{"relative PAC path", func(ps *Server) {
+			ps.PACPath = "proxy.pac"
+		}, "PAC path"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := NewProxyServer("admin", "password123", "8080")
+			tt.configure(proxy)
+			err := proxy.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	proxy := NewProxyServer("admin", "", "abc")
+	proxy.HealthPath = "healthz"
+
+	err := proxy.Validate()
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	for _, want := range []string{"invalid port", "password of user", "health path"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error containing %q, got %v", want, err)
+		}
+	}
+}
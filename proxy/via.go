@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// addVia records this proxy as a hop in h when ViaName is set
+func (ps *Server) addVia(h http.Header) {
+	if ps.ViaName != "" {
+		h.Add("Via", "1.1 "+ps.ViaName)
+	}
+}
+
+// viaLoop reports whether name already appears as a recipient in the Via
+// header, meaning the request has passed through this proxy before
+func viaLoop(h http.Header, name string) bool {
+	for _, value := range h.Values("Via") {
+		for _, hop := range strings.Split(value, ",") {
+			// Each hop is "protocol received-by [comment]"
+			fields := strings.Fields(hop)
+			if len(fields) >= 2 && strings.EqualFold(fields[1], name) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestViaLoop(t *testing.T) {
+	tests := []struct {
+		name     string
+		via      []string
+		expected bool
+	}{
+		{"no via", nil, false},
+		{"other proxy", []string{"1.1 edge"}, false},
+		{"own name", []string{"1.1 proxy-a"}, true},
+		{"own name case-insensitive", []string{"1.1 Proxy-A"}, true},
+		{"own name later in list", []string{"1.0 edge, 1.1 proxy-a (go-proxy-server)"}, true},
+		{"own name in second header", []string{"1.1 edge", "1.1 proxy-a"}, true},
+		{"name only in comment", []string{"1.1 edge (proxy-a)"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for _, v := range tt.via {
+				h.Add("Via", v)
+			}
+			if got := viaLoop(h, "proxy-a"); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestHandleHTTP_AddsVia(t *testing.T) {
+	var upstreamVia []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamVia = r.Header.Values("Via")
+		w.Header().Set("Via", "1.1 origin")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.ViaName = "proxy-a"
+
+	req := viaRequest(backend.URL)
+	req.Header.Set("Via", "1.1 edge")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if len(upstreamVia) != 2 || upstreamVia[0] != "1.1 edge" || upstreamVia[1] != "1.1 proxy-a" {
+		t.Errorf("Expected upstream Via [1.1 edge 1.1 proxy-a], got %v", upstreamVia)
+	}
+	if got := w.Header().Values("Via"); len(got) != 2 || got[0] != "1.1 origin" || got[1] != "1.1 proxy-a" {
+		t.Errorf("Expected response Via [1.1 origin 1.1 proxy-a], got %v", got)
+	}
+}
+
+func TestHandleHTTP_ViaLoopDetected(t *testing.T) {
+	reached := false
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	defer backend.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	proxy.ViaName = "proxy-a"
+
+	req := viaRequest(backend.URL)
+	req.Header.Set("Via", "1.1 edge, 1.1 proxy-a")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusLoopDetected {
+		t.Errorf("Expected status %d, got %d", http.StatusLoopDetected, w.Code)
+	}
+	if reached {
+		t.Error("Expected looped request not to reach the upstream")
+	}
+}
+
+func TestHandleHTTP_NoViaByDefault(t *testing.T) {
+	var upstreamVia string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamVia = r.Header.Get("Via")
+	}))
+	defer backend.Close()
+
+	proxy := NewProxyServer("admin", "password123", "8080")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, viaRequest(backend.URL))
+
+	if upstreamVia != "" {
+		t.Errorf("Expected no Via header, got %q", upstreamVia)
+	}
+}
+
+// viaRequest builds an authenticated proxied GET for rawURL
+func viaRequest(rawURL string) *http.Request {
+	req := httptest.NewRequest("GET", rawURL, nil)
+	req.Header.Set("Proxy-Authorization", CreateBasicAuth("admin", "password123"))
+	return req
+}